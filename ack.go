@@ -2,11 +2,15 @@ package gosocketio
 
 import (
 	"errors"
+	"github.com/graarh/golang-socketio/protocol"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
-	ErrorWaiterNotFound = errors.New("Waiter not found")
+	ErrorWaiterNotFound     = errors.New("Waiter not found")
+	ErrorTooManyPendingAcks = errors.New("Too many pending acks")
 )
 
 /**
@@ -16,8 +20,65 @@ type ackProcessor struct {
 	counter     int
 	counterLock sync.Mutex
 
-	resultWaiters     map[int](chan string)
+	resultWaiters     map[int](chan *protocol.Message)
 	resultWaitersLock sync.RWMutex
+
+	//limit of waiters, zero means unlimited, guarded by waiters lock
+	maxWaiters int
+
+	//nanoseconds, timeout of AckDefault
+	defaultTimeout int64
+
+	//closed when connection is closed and its session is not kept,
+	//so waiters are not answered anymore
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newAckProcessor() *ackProcessor {
+	return &ackProcessor{
+		resultWaiters: make(map[int](chan *protocol.Message)),
+		closed:        make(chan struct{}),
+	}
+}
+
+func (a *ackProcessor) close() {
+	a.closeOnce.Do(func() { close(a.closed) })
+}
+
+/**
+Set timeout of AckDefault, it is shared by all namespaces of the
+connection, zero means waiting for response till connection is closed
+*/
+func (c *Channel) SetDefaultAckTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&c.ack.defaultTimeout, int64(timeout))
+}
+
+/**
+Limit number of acks waiting for response, Ack over the limit fails
+with ErrorTooManyPendingAcks at once, the limit is shared by all
+namespaces of the connection, zero means unlimited
+*/
+func (c *Channel) SetMaxPendingAcks(n int) {
+	c.ack.resultWaitersLock.Lock()
+	defer c.ack.resultWaitersLock.Unlock()
+
+	c.ack.maxWaiters = n
+}
+
+func (a *ackProcessor) getDefaultTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&a.defaultTimeout))
+}
+
+/**
+Timeout of Ack, which does not wait for response if given zero one,
+unlike AckTimeout
+*/
+func exactTimeout(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return time.Nanosecond
+	}
+	return timeout
 }
 
 /**
@@ -33,12 +94,18 @@ func (a *ackProcessor) getNextId() int {
 
 /**
 Just before the ack function called, the waiter should be added
-to wait and receive response to ack call
+to wait and receive response to ack call, it fails if too many
+waiters are added already
 */
-func (a *ackProcessor) addWaiter(id int, w chan string) {
+func (a *ackProcessor) addWaiter(id int, w chan *protocol.Message) error {
 	a.resultWaitersLock.Lock()
+	defer a.resultWaitersLock.Unlock()
+
+	if a.maxWaiters > 0 && len(a.resultWaiters) >= a.maxWaiters {
+		return ErrorTooManyPendingAcks
+	}
 	a.resultWaiters[id] = w
-	a.resultWaitersLock.Unlock()
+	return nil
 }
 
 /**
@@ -53,7 +120,7 @@ func (a *ackProcessor) removeWaiter(id int) {
 /**
 check if waiter with given ack id is exists, and returns it
 */
-func (a *ackProcessor) getWaiter(id int) (chan string, error) {
+func (a *ackProcessor) getWaiter(id int) (chan *protocol.Message, error) {
 	a.resultWaitersLock.RLock()
 	defer a.resultWaitersLock.RUnlock()
 
@@ -0,0 +1,238 @@
+package gosocketio
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/graarh/golang-socketio/transport"
+)
+
+/**
+Connection that takes every packet and never answers, so acks keep waiting
+*/
+type silentConnection struct {
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (sc *silentConnection) GetMessage() (string, error) {
+	<-sc.closed
+	return "", ErrorNotConnected
+}
+
+func (sc *silentConnection) WriteMessage(message string) error {
+	select {
+	case <-sc.closed:
+		return ErrorNotConnected
+	default:
+		return nil
+	}
+}
+
+func (sc *silentConnection) Close() {
+	sc.closeOnce.Do(func() { close(sc.closed) })
+}
+
+func (sc *silentConnection) PingParams() (interval, timeout time.Duration) {
+	return time.Hour, time.Hour
+}
+
+func (sc *silentConnection) RemoteAddr() net.Addr { return nil }
+func (sc *silentConnection) LocalAddr() net.Addr  { return nil }
+
+/**
+Get open channel over silent connection with running loops
+*/
+func newSilentChannel() *Channel {
+	return newLoopChannel(&silentConnection{closed: make(chan struct{})}, 0)
+}
+
+/**
+Get open channel over given connection with running loops, default
+queue size is used if zero
+*/
+func newLoopChannel(conn transport.Connection, queueSize int) *Channel {
+	m := &methods{}
+	m.initMethods()
+
+	c := &Channel{conn: conn, queueSize: queueSize}
+	c.initChannel()
+	c.methods = m
+	c.setState(StateOpen)
+	go inLoop(c, m)
+	go outLoop(c, m)
+	return c
+}
+
+/**
+Get amount of acks waiting for response
+*/
+func pendingAcks(c *Channel) int {
+	c.ack.resultWaitersLock.RLock()
+	defer c.ack.resultWaitersLock.RUnlock()
+
+	return len(c.ack.resultWaiters)
+}
+
+func TestAckZeroTimeout(t *testing.T) {
+	_, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	c := dialServer(t, url)
+
+	start := time.Now()
+	if _, err := c.Ack("silent", nil, 0); err != ErrorSendTimeout {
+		t.Fatalf("expected ErrorSendTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("zero timeout ack waited for %v", elapsed)
+	}
+}
+
+func TestAckDefaultTimeout(t *testing.T) {
+	_, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	c := dialServer(t, url)
+	c.SetDefaultAckTimeout(50 * time.Millisecond)
+
+	if _, err := c.AckDefault("silent", nil); err != ErrorSendTimeout {
+		t.Fatalf("expected ErrorSendTimeout, got %v", err)
+	}
+}
+
+func TestAckDefaultWaitsTillClose(t *testing.T) {
+	_, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	c := dialServer(t, url)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := c.AckDefault("silent", nil)
+		result <- err
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case err := <-result:
+		t.Fatalf("ack without timeout returned before close, %v", err)
+	default:
+	}
+
+	c.Close()
+	select {
+	case err := <-result:
+		if err != ErrorNotConnected {
+			t.Fatalf("expected ErrorNotConnected, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ack without timeout is not released by close")
+	}
+}
+
+func TestTooManyPendingAcks(t *testing.T) {
+	c := newSilentChannel()
+	c.SetMaxPendingAcks(3)
+
+	results := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := c.AckDefault("silent", nil)
+			results <- err
+		}()
+	}
+	waitFor(t, "pending acks", func() bool { return pendingAcks(c) == 3 })
+
+	start := time.Now()
+	if _, err := c.Ack("silent", nil, time.Second); err != ErrorTooManyPendingAcks {
+		t.Fatalf("expected ErrorTooManyPendingAcks, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("ack over the limit waited for %v", elapsed)
+	}
+
+	c.Close()
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-results:
+			if err != ErrorNotConnected {
+				t.Fatalf("expected ErrorNotConnected, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("pending ack is not released by close")
+		}
+	}
+	if n := pendingAcks(c); n != 0 {
+		t.Fatalf("%d acks are still pending after close", n)
+	}
+}
+
+func TestPendingAckSlotIsReleased(t *testing.T) {
+	c := newSilentChannel()
+	defer c.Close()
+	c.SetMaxPendingAcks(1)
+
+	if _, err := c.Ack("silent", nil, 20*time.Millisecond); err != ErrorSendTimeout {
+		t.Fatalf("expected ErrorSendTimeout, got %v", err)
+	}
+	if _, err := c.Ack("silent", nil, 20*time.Millisecond); err != ErrorSendTimeout {
+		t.Fatalf("slot of timed out ack is not released, %v", err)
+	}
+}
+
+func TestServerAckRoundTrip(t *testing.T) {
+	s, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	connected := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) {
+		connected <- c
+	})
+
+	c := dialServer(t, url)
+	c.On("sum", func(c *Channel, numbers []int) int {
+		return numbers[0] + numbers[1]
+	})
+
+	result, err := (<-connected).Ack("sum", []int{1, 2}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "3" {
+		t.Fatalf("unexpected ack result %s", result)
+	}
+}
+
+func TestServerAckTextPackets(t *testing.T) {
+	s, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	connected := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) {
+		connected <- c
+	})
+
+	socket := dialRaw(t, url)
+	readPacket(t, socket)
+	readPacket(t, socket)
+
+	results := make(chan string, 1)
+	go func() {
+		result, err := (<-connected).Ack("sum", []int{1, 2}, time.Second)
+		if err != nil {
+			result = err.Error()
+		}
+		results <- result
+	}()
+
+	//ack request and its answer are plain text packets with ack id
+	packet := readPacket(t, socket)
+	if !strings.HasPrefix(packet, "42") || !strings.HasSuffix(packet, `["sum",[1,2]]`) {
+		t.Fatalf("unexpected ack request %s", packet)
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(packet, "42"), `["sum",[1,2]]`)
+	writeRaw(t, socket, "43"+id+"[3]")
+
+	select {
+	case result := <-results:
+		if result != "3" {
+			t.Fatalf("unexpected ack result %s", result)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ack answer is not delivered")
+	}
+}
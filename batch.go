@@ -0,0 +1,167 @@
+package gosocketio
+
+import (
+	"github.com/graarh/golang-socketio/protocol"
+)
+
+/**
+One event of batch, see EmitBatch
+*/
+type Event struct {
+	Method string
+	Args   interface{}
+}
+
+/**
+Result of batch writing, reported by outgoing loop
+*/
+type batchResult struct {
+	sent int
+	err  error
+}
+
+/**
+Send events one after another, so no other packet of the connection goes
+between them, and wait till they are written. Nothing is sent if any event
+fails to encode or outbound interceptor fails on it, events dropped by
+interceptor are skipped, otherwise number of written events is returned, with
+write error if connection failed in the middle of the batch. Batch is
+written to socket as usual packets, they are coalesced to fewer frames
+if transport does it, like polling, or to one socket write by websocket
+with write coalescing, number of written events is zero if that write fails.
+*/
+func (c *Channel) EmitBatch(events []Event) (int, error) {
+	if len(events) == 0 {
+		return 0, nil
+	}
+	if c.State() != StateOpen {
+		return 0, ErrorNotConnected
+	}
+
+	msgs := make([]*protocol.Message, 0, len(events))
+	for _, event := range events {
+		msg := &protocol.Message{
+			Type:         protocol.MessageTypeEmit,
+			Namespace:    c.namespace,
+			ExplicitRoot: c.explicitRoot(),
+			Method:       event.Method,
+		}
+		if err := encodeArgs(msg, c, event.Args); err != nil {
+			return 0, err
+		}
+		msg, err := c.interceptOutbound(msg)
+		if err != nil {
+			return 0, err
+		}
+		if msg != nil {
+			msgs = append(msgs, msg)
+		}
+	}
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	written := make(chan batchResult, 1)
+	if err := c.enqueueBatch(msgs, written); err != nil {
+		return 0, err
+	}
+
+	select {
+	case result := <-written:
+		return result.sent, result.err
+	case <-c.Context().Done():
+		//batch could be written right before closing
+		select {
+		case result := <-written:
+			return result.sent, result.err
+		default:
+			return 0, ErrorNotConnected
+		}
+	}
+}
+
+/**
+Encode batch and put it to the queue as one packet, sequence numbers
+are taken under lock, so they go in order of the queue
+*/
+func (c *Channel) enqueueBatch(msgs []*protocol.Message, written chan batchResult) error {
+	if !c.isSequenced() {
+		packet, err := batchPacket(msgs, written)
+		if err != nil {
+			return err
+		}
+		return c.enqueue(packet)
+	}
+
+	root := c
+	if c.root != nil {
+		root = c.root
+	}
+
+	root.seqLock.Lock()
+	defer root.seqLock.Unlock()
+
+	for i, msg := range msgs {
+		wrapSequence(msg, root.seq+uint64(i)+1)
+	}
+	packet, err := batchPacket(msgs, written)
+	if err != nil {
+		return err
+	}
+	if err := c.enqueue(packet); err != nil {
+		return err
+	}
+
+	for _, p := range packet.batch {
+		root.seq++
+		if root.replay != nil {
+			root.replay.add(root.seq, p, 0)
+		}
+	}
+	return nil
+}
+
+func batchPacket(msgs []*protocol.Message, written chan batchResult) (outPacket, error) {
+	packet := outPacket{batch: make([]outPacket, len(msgs)), written: written}
+	for i, msg := range msgs {
+		command, err := protocol.Encode(msg)
+		if err != nil {
+			return outPacket{}, err
+		}
+		packet.batch[i] = outPacket{text: command, attachments: msg.Buffers}
+	}
+	return packet, nil
+}
+
+/**
+Write packets of batch, called by outgoing loop
+*/
+func writeBatch(c *Channel, msg outPacket) error {
+	if cc, _ := coalescingConnection(c.conn); cc != nil && plainBatch(msg.batch) {
+		if err := writePackets(c, cc, msg.batch); err != nil {
+			msg.written <- batchResult{sent: 0, err: err}
+			return err
+		}
+		msg.written <- batchResult{sent: len(msg.batch)}
+		return nil
+	}
+
+	for i, p := range msg.batch {
+		if err := writeOutPacket(c, p); err != nil {
+			msg.written <- batchResult{sent: i, err: err}
+			return err
+		}
+	}
+
+	msg.written <- batchResult{sent: len(msg.batch)}
+	return nil
+}
+
+func plainBatch(batch []outPacket) bool {
+	for _, p := range batch {
+		if !plainPacket(p) {
+			return false
+		}
+	}
+	return true
+}
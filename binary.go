@@ -0,0 +1,304 @@
+package gosocketio
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/graarh/golang-socketio/protocol"
+	"github.com/graarh/golang-socketio/transport"
+	"time"
+)
+
+const (
+	//query parameter of binary codec offered by client
+	payloadParam = "payload"
+)
+
+var (
+	ErrorBinaryNotSupported = errors.New("Transport does not support binary messages")
+	ErrorAttachmentMissing  = errors.New("Binary attachment missing")
+)
+
+/**
+Set codec of arguments carried as binary attachments, like MessagePack,
+it is used for clients that offer it at connect time, other clients use json
+*/
+func (s *Server) SetBinaryCodec(codec protocol.BinaryCodec) {
+	s.binaryCodec = codec
+}
+
+/**
+Accept binary codec requested by client, if server has the same one
+and connection supports binary frames
+*/
+func (s *Server) acceptBinaryCodec(c *Channel, payload string) {
+	if s.binaryCodec == nil || payload == "" || payload != s.binaryCodec.Name() {
+		return
+	}
+	if _, ok := c.conn.(transport.BinaryConnection); !ok {
+		return
+	}
+
+	c.header.Payload = payload
+	c.binaryCodec = s.binaryCodec
+}
+
+/**
+Use requested binary codec if server accepted it in open packet
+*/
+func (c *Channel) negotiateBinaryCodec() {
+	if c.requestedCodec == nil || c.header.Payload != c.requestedCodec.Name() {
+		return
+	}
+	if _, ok := c.conn.(transport.BinaryConnection); !ok {
+		return
+	}
+
+	c.binaryCodecLock.Lock()
+	defer c.binaryCodecLock.Unlock()
+	c.binaryCodec = c.requestedCodec
+}
+
+/**
+Get binary codec in use, nil if arguments are sent as json
+*/
+func (c *Channel) getBinaryCodec() protocol.BinaryCodec {
+	if c.root != nil {
+		return c.root.getBinaryCodec()
+	}
+
+	c.binaryCodecLock.RLock()
+	defer c.binaryCodecLock.RUnlock()
+	return c.binaryCodec
+}
+
+/**
+Encode arguments with binary codec as the only attachment of message
+*/
+func encodeBinaryArgs(msg *protocol.Message, codec protocol.BinaryCodec, args interface{}) error {
+	data, err := codec.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	placeholder, err := protocol.Marshal(protocol.NewPlaceholder(0))
+	if err != nil {
+		return err
+	}
+
+	msg.Args = string(placeholder)
+	msg.Attachments = 1
+	msg.Buffers = [][]byte{data}
+
+	return nil
+}
+
+/**
+Get attachment the message arguments refer to, if any
+*/
+func getAttachment(msg *protocol.Message) ([]byte, bool) {
+	if len(msg.Buffers) == 0 {
+		return nil, false
+	}
+
+	placeholder, ok := protocol.GetPlaceholder(msg.Args)
+	if !ok || placeholder.Num < 0 || placeholder.Num >= len(msg.Buffers) {
+		return nil, false
+	}
+	return msg.Buffers[placeholder.Num], true
+}
+
+/**
+Decode message arguments to v, from binary attachment if they refer
+to it and binary codec is in use, or from json
+*/
+func (c *Channel) decodeArgs(msg *protocol.Message, v interface{}) error {
+	if codec := c.getBinaryCodec(); codec != nil {
+		if data, ok := getAttachment(msg); ok {
+			return codec.Unmarshal(data, v)
+		}
+	}
+
+	return c.unmarshalArgs([]byte(msg.Args), v)
+}
+
+/**
+Get ack response as json, binary attachment is decoded and converted to json
+*/
+func (c *Channel) ackResult(msg *protocol.Message) string {
+	codec := c.getBinaryCodec()
+	data, ok := getAttachment(msg)
+	if codec == nil || !ok {
+		return msg.Args
+	}
+
+	var result interface{}
+	if err := codec.Unmarshal(data, &result); err != nil {
+		return msg.Args
+	}
+	json, err := protocol.Marshal(result)
+	if err != nil {
+		return msg.Args
+	}
+	return string(json)
+}
+
+/**
+Emit event with binary data sent as attachment frame, the data is
+referred by placeholder as first event argument, and meta, if not nil,
+is sent as json second one. Binary events are not sequenced.
+*/
+func (c *Channel) EmitBinary(method string, data []byte, meta interface{}) error {
+	msg := &protocol.Message{
+		Type:         protocol.MessageTypeEmit,
+		Namespace:    c.namespace,
+		ExplicitRoot: c.explicitRoot(),
+		Method:       method,
+	}
+	if err := setBinaryArgs(msg, data, meta); err != nil {
+		return err
+	}
+
+	return sendBinary(msg, c)
+}
+
+/**
+Check that connection is able to send binary frames
+*/
+func (c *Channel) binarySupported() bool {
+	_, ok := c.conn.(transport.BinaryConnection)
+	return ok
+}
+
+/**
+Set message arguments to placeholder of data attachment, and meta
+as json second argument if it is not nil
+*/
+func setBinaryArgs(msg *protocol.Message, data []byte, meta interface{}) error {
+	args, err := protocol.Marshal(protocol.NewPlaceholder(0))
+	if err != nil {
+		return err
+	}
+	if meta != nil {
+		jsonMeta, err := protocol.Marshal(&meta)
+		if err != nil {
+			return err
+		}
+		args = append(append(args, ','), jsonMeta...)
+	}
+
+	msg.Args = string(args)
+	msg.Attachments = 1
+	msg.Buffers = [][]byte{data}
+
+	return nil
+}
+
+/**
+Send message with binary attachments set, as binary event or binary ack
+*/
+func sendBinary(msg *protocol.Message, c *Channel) error {
+	if c.State() != StateOpen {
+		return ErrorNotConnected
+	}
+	if !c.binarySupported() {
+		return ErrorBinaryNotSupported
+	}
+
+	result, err := c.interceptOutbound(msg)
+	if result == nil {
+		if err == nil && msg.Type == protocol.MessageTypeAckRequest {
+			return ErrorDropped
+		}
+		return err
+	}
+	msg = result
+	command, err := protocol.Encode(msg)
+	if err != nil {
+		return err
+	}
+
+	return c.enqueue(outPacket{text: command, attachments: msg.Buffers})
+}
+
+/**
+Send binary ack of handler result, AckError is sent instead if attachment
+can't be sent, so caller does not wait for the answer till timeout
+*/
+func sendBinaryAck(ack *protocol.Message, c *Channel, data []byte) error {
+	err := setBinaryArgs(ack, data, nil)
+	if err == nil {
+		err = sendBinary(ack, c)
+	}
+	if err == nil || err == ErrorNotConnected {
+		return err
+	}
+
+	ack.Attachments, ack.Buffers = 0, nil
+	if ackErr := send(ack, c, &AckError{Message: err.Error()}); ackErr != nil {
+		return ackErr
+	}
+	return err
+}
+
+/**
+Get data of attachment referred by first message argument, and raw json
+of second one, nil if it is absent
+*/
+func getBinaryArgs(msg *protocol.Message) (data []byte, meta json.RawMessage, err error) {
+	var args []json.RawMessage
+	if err := protocol.Unmarshal([]byte("["+msg.Args+"]"), &args); err != nil {
+		return nil, nil, err
+	}
+	if len(args) == 0 {
+		return nil, nil, ErrorAttachmentMissing
+	}
+
+	placeholder, ok := protocol.GetPlaceholder(string(args[0]))
+	if !ok || placeholder.Num < 0 || placeholder.Num >= len(msg.Buffers) {
+		return nil, nil, ErrorAttachmentMissing
+	}
+
+	if len(args) > 1 {
+		meta = args[1]
+	}
+	return msg.Buffers[placeholder.Num], meta, nil
+}
+
+/**
+Send ack and receive binary ack response, like the one of handler returning
+[]byte or of node.js callback called with Buffer, data of attachment
+referred by first response argument is returned with raw json of second
+one, ErrorAttachmentMissing is returned if response is not binary.
+Timeout is applied as by Ack
+*/
+func (c *Channel) AckBinary(method string, args interface{}, timeout time.Duration) (
+	data []byte, meta json.RawMessage, err error) {
+
+	response, err := c.ackResponse(method, args, exactTimeout(timeout))
+	if err != nil {
+		return nil, nil, err
+	}
+	return getBinaryArgs(response)
+}
+
+/**
+Add handler of binary event, it is called after all attachments are
+received, with data of attachment referred by first event argument
+and raw json of second one, nil if it is absent
+*/
+func (m *methods) OnBinary(method string, f func(c *Channel, data []byte, meta json.RawMessage)) {
+	m.addCaller(method, &caller{
+		direct: func(c *Channel, msg *protocol.Message) error {
+			if msg == nil {
+				return ErrorAttachmentMissing
+			}
+
+			data, meta, err := getBinaryArgs(msg)
+			if err != nil {
+				return err
+			}
+			f(c, data, meta)
+			return nil
+		},
+	})
+}
@@ -0,0 +1,161 @@
+package gosocketio
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/graarh/golang-socketio/protocol"
+	"github.com/graarh/golang-socketio/transport"
+)
+
+type testItem struct {
+	Name  string            `json:"name"`
+	Count int               `json:"count"`
+	Attrs map[string]string `json:"attrs"`
+}
+
+type testOrder struct {
+	Id    int64      `json:"id"`
+	Items []testItem `json:"items"`
+	Note  *testItem  `json:"note"`
+}
+
+/**
+Dial server offering given binary codec and wait till connect is confirmed
+*/
+func dialWithCodec(t *testing.T, url string, codec protocol.BinaryCodec) *Client {
+	c, err := DialWithOptions(url, transport.GetDefaultWebsocketTransport(),
+		DialOptions{BinaryCodec: codec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	waitFor(t, "connect", func() bool { return c.State() == StateOpen })
+	return c
+}
+
+func TestMsgpackArgsRoundTrip(t *testing.T) {
+	s, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	s.SetBinaryCodec(protocol.MsgpackCodec{})
+	received := make(chan testOrder, 1)
+	s.On("order", func(c *Channel, order testOrder) testOrder {
+		if c.getBinaryCodec() == nil {
+			t.Error("binary codec is not accepted")
+		}
+		received <- order
+		order.Id++
+		return order
+	})
+
+	c := dialWithCodec(t, url, protocol.MsgpackCodec{})
+	if c.getBinaryCodec() == nil {
+		t.Fatal("binary codec is not negotiated")
+	}
+
+	order := testOrder{
+		Id: 1 << 40,
+		Items: []testItem{
+			{Name: "first", Count: 2, Attrs: map[string]string{"size": "xl"}},
+			{Name: "second", Count: -1},
+		},
+		Note: &testItem{Name: "note", Attrs: map[string]string{}},
+	}
+	result, err := c.Ack("order", order, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; !reflect.DeepEqual(got, order) {
+		t.Fatalf("server got %+v", got)
+	}
+
+	var answer testOrder
+	if err := protocol.Unmarshal([]byte(result), &answer); err != nil {
+		t.Fatal(err)
+	}
+	order.Id++
+	if !reflect.DeepEqual(answer, order) {
+		t.Fatalf("ack result %+v", answer)
+	}
+}
+
+var testPhoto = []byte{0, 1, 2, 0xfe, 0xff}
+
+func TestBinaryAckFromServer(t *testing.T) {
+	s, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	s.On("download", func(c *Channel, name string) []byte {
+		return testPhoto
+	})
+
+	c := dialServer(t, url)
+	data, meta, err := c.AckBinary("download", "photo.jpg", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, testPhoto) || meta != nil {
+		t.Fatalf("unexpected binary ack %v %s", data, meta)
+	}
+}
+
+func TestBinaryAckFromClient(t *testing.T) {
+	s, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	connected := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) {
+		connected <- c
+	})
+
+	c := dialServer(t, url)
+	c.On("upload", func(c *Channel, name string) []byte {
+		return testPhoto
+	})
+
+	data, _, err := (<-connected).AckBinary("upload", "photo.jpg", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, testPhoto) {
+		t.Fatalf("unexpected binary ack %v", data)
+	}
+}
+
+func TestBinaryAckFailureAnswered(t *testing.T) {
+	s, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	s.Use(func(c *Channel, next func() error) error {
+		c.SetOutboundInterceptor(func(msg *protocol.Message) (*protocol.Message, error) {
+			if msg.Attachments > 0 {
+				return nil, errors.New("binary refused")
+			}
+			return msg, nil
+		})
+		return next()
+	})
+	failures := make(chan error, 1)
+	s.On(OnError, func(c *Channel, e EventError) {
+		failures <- e.Err
+	})
+	s.On("download", func(c *Channel, name string) []byte {
+		return testPhoto
+	})
+
+	c := dialServer(t, url)
+	start := time.Now()
+	result, err := c.Ack("download", "photo.jpg", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != `{"error":"binary refused"}` {
+		t.Fatalf("expected ack error, got %s", result)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("failed binary ack is answered after %v", elapsed)
+	}
+	select {
+	case err := <-failures:
+		if err == nil || err.Error() != "binary refused" {
+			t.Fatalf("unexpected error event %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("failed binary ack is not reported")
+	}
+}
@@ -1,7 +1,9 @@
 package gosocketio
 
 import (
+	"context"
 	"errors"
+	"github.com/graarh/golang-socketio/protocol"
 	"reflect"
 )
 
@@ -10,12 +12,26 @@ type caller struct {
 	Args        reflect.Type
 	ArgsPresent bool
 	Out         bool
+
+	//function gets context, cancelled on disconnection, as first argument
+	Ctx bool
+
+	//function returns error after its result
+	Err bool
+
+	//typed handler, decodes arguments itself without reflection
+	direct func(c *Channel, msg *protocol.Message) error
 }
 
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
 var (
 	ErrorCallerNotFunc     = errors.New("f is not function")
-	ErrorCallerNot2Args    = errors.New("f should have 1 or 2 args")
-	ErrorCallerMaxOneValue = errors.New("f should return not more than one value")
+	ErrorCallerNot2Args    = errors.New("f should have 1 or 2 args, not counting context")
+	ErrorCallerMaxOneValue = errors.New("f should return not more than one value, or value and error")
 )
 
 /**
@@ -29,19 +45,27 @@ func newCaller(f interface{}) (*caller, error) {
 	}
 
 	fType := fVal.Type()
-	if fType.NumOut() > 1 {
+	if fType.NumOut() > 2 || (fType.NumOut() == 2 && fType.Out(1) != errorType) {
 		return nil, ErrorCallerMaxOneValue
 	}
 
 	curCaller := &caller{
 		Func: fVal,
-		Out:  fType.NumOut() == 1,
+		Out:  fType.NumOut() > 0,
+		Err:  fType.NumOut() == 2,
+	}
+
+	numIn := fType.NumIn()
+	if numIn > 0 && fType.In(0) == contextType {
+		curCaller.Ctx = true
+		numIn--
 	}
-	if fType.NumIn() == 1 {
+
+	if numIn == 1 {
 		curCaller.Args = nil
 		curCaller.ArgsPresent = false
-	} else if fType.NumIn() == 2 {
-		curCaller.Args = fType.In(1)
+	} else if numIn == 2 {
+		curCaller.Args = fType.In(fType.NumIn() - 1)
 		curCaller.ArgsPresent = true
 	} else {
 		return nil, ErrorCallerNot2Args
@@ -70,6 +94,19 @@ func (c *caller) callFunc(h *Channel, args interface{}) []reflect.Value {
 	if !c.ArgsPresent {
 		a = a[0:1]
 	}
+	if c.Ctx {
+		a = append([]reflect.Value{reflect.ValueOf(h.Context())}, a...)
+	}
 
 	return c.Func.Call(a)
 }
+
+/**
+Get error returned by function, if it returns one
+*/
+func (c *caller) getError(result []reflect.Value) error {
+	if !c.Err || len(result) < 2 || result[1].IsNil() {
+		return nil
+	}
+	return result[1].Interface().(error)
+}
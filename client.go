@@ -1,8 +1,15 @@
 package gosocketio
 
 import (
+	"context"
+	"errors"
+	"github.com/graarh/golang-socketio/protocol"
 	"github.com/graarh/golang-socketio/transport"
+	"net"
+	"net/http"
+	neturl "net/url"
 	"strconv"
+	"time"
 )
 
 const (
@@ -11,6 +18,16 @@ const (
 	socketioUrl       = "/socket.io/?EIO=3&transport=websocket"
 )
 
+const (
+	socketioPath = "/socket.io/"
+)
+
+var (
+	ErrorHeaderNotSupported = errors.New("Transport does not support handshake headers")
+	ErrorHandshakeTimeout   = errors.New("Handshake timeout")
+	ErrorNoTransports       = errors.New("No transports given")
+)
+
 /**
 Socket.io client representation
 */
@@ -32,6 +49,174 @@ func GetUrl(host string, port int, secure bool) string {
 	return prefix + host + ":" + strconv.Itoa(port) + socketioUrl
 }
 
+/**
+Client url and handshake options
+*/
+type DialOptions struct {
+	//path of socket.io endpoint, used as given, so trailing slash
+	//should be present if server requires it, "/socket.io/" if empty
+	//and url has no path
+	Path string
+
+	//query parameters added to the url ones, EIO and transport
+	//are set to defaults if missing
+	Query neturl.Values
+
+	//extra handshake headers, transport should implement transport.HeaderTransport
+	Header http.Header
+
+	//codec of arguments sent as binary attachments, offered to server,
+	//json is used if server does not accept it
+	BinaryCodec protocol.BinaryCodec
+
+	//capacity of outgoing queue, default one is used if zero
+	QueueSize int
+
+	//extra attempts of failed connect, made after retry delay, like when
+	//server is restarting, connect is tried once if zero, error of the
+	//last attempt is returned, established connection is not retried,
+	//use DialContext to abort connect and waiting for the next attempt
+	Retries    int
+	RetryDelay time.Duration
+}
+
+/**
+Build client url from base one, like ws://myserver.com, and given options,
+query already present in the base url is preserved
+*/
+func GetUrlWithOptions(base string, opts DialOptions) (string, error) {
+	u, err := neturl.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	if opts.Path != "" {
+		u.Path = opts.Path
+	} else if u.Path == "" || u.Path == "/" {
+		u.Path = socketioPath
+	}
+
+	query := u.Query()
+	if query.Get("EIO") == "" {
+		query.Set("EIO", "3")
+	}
+	if query.Get("transport") == "" {
+		query.Set("transport", "websocket")
+	}
+	for name, values := range opts.Query {
+		query[name] = values
+	}
+	if opts.BinaryCodec != nil {
+		query.Set(payloadParam, opts.BinaryCodec.Name())
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+/**
+Transport with handshake headers added to every connect
+*/
+type headerTransport struct {
+	transport.HeaderTransport
+	header http.Header
+}
+
+func (ht *headerTransport) Connect(url string) (transport.Connection, error) {
+	return ht.ConnectWithHeaderContext(context.Background(), url, nil)
+}
+
+func (ht *headerTransport) ConnectContext(ctx context.Context, url string) (transport.Connection, error) {
+	return ht.ConnectWithHeaderContext(ctx, url, nil)
+}
+
+/**
+Connect with given headers added to the transport ones, so wrapped
+header transports keep their headers
+*/
+func (ht *headerTransport) ConnectWithHeader(url string, header http.Header) (transport.Connection, error) {
+	return ht.ConnectWithHeaderContext(context.Background(), url, header)
+}
+
+/**
+Connect with given headers and context, context is ignored if
+wrapped transport does not support it
+*/
+func (ht *headerTransport) ConnectWithHeaderContext(ctx context.Context, url string,
+	header http.Header) (transport.Connection, error) {
+
+	merged := make(http.Header, len(ht.header)+len(header))
+	for name, values := range ht.header {
+		merged[name] = values
+	}
+	for name, values := range header {
+		merged[name] = values
+	}
+	if ctr, ok := ht.HeaderTransport.(transport.ContextHeaderTransport); ok {
+		return ctr.ConnectWithHeaderContext(ctx, url, merged)
+	}
+	return ht.HeaderTransport.ConnectWithHeader(url, merged)
+}
+
+/**
+Transport connecting over given connection instead of dialing
+*/
+type connTransport struct {
+	*transport.WebsocketTransport
+	conn net.Conn
+}
+
+func (ct *connTransport) Connect(url string) (transport.Connection, error) {
+	return ct.ConnectOverConn(ct.conn, url)
+}
+
+/**
+Connection is already established, so context of websocket transport
+is not used, as it would dial
+*/
+func (ct *connTransport) ConnectContext(ctx context.Context, url string) (transport.Connection, error) {
+	return ct.ConnectOverConn(ct.conn, url)
+}
+
+/**
+Connect over already established connection, like stream of tunnel
+or multiplexer, websocket handshake is made over it with given url,
+see transport.WebsocketTransport.ConnectOverConn
+*/
+func DialOverConn(conn net.Conn, url string, tr *transport.WebsocketTransport) (*Client, error) {
+	return Dial(url, &connTransport{tr, conn})
+}
+
+/**
+Connect to base url with given options, see GetUrlWithOptions
+*/
+func DialWithOptions(base string, tr transport.Transport, opts DialOptions) (*Client, error) {
+	return DialContext(context.Background(), base, tr, opts)
+}
+
+/**
+Connect to base url with given options, connect in progress is aborted
+and retries are stopped when given context is done, its error is returned
+then, transport should implement transport.ContextTransport for the connect
+to be aborted. Context is not used after the connection is established.
+*/
+func DialContext(ctx context.Context, base string, tr transport.Transport, opts DialOptions) (*Client, error) {
+	url, err := GetUrlWithOptions(base, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(opts.Header) > 0 {
+		htr, ok := tr.(transport.HeaderTransport)
+		if !ok {
+			return nil, ErrorHeaderNotSupported
+		}
+		tr = &headerTransport{htr, opts.Header}
+	}
+
+	return dial(ctx, url, tr, opts)
+}
+
 /**
 connect to host and initialise socket.io protocol
 
@@ -41,23 +226,150 @@ ws://myserver.com/socket.io/?EIO=3&transport=websocket
 You can use GetUrlByHost for generating correct url
 */
 func Dial(url string, tr transport.Transport) (*Client, error) {
+	return dial(context.Background(), url, tr, DialOptions{})
+}
+
+/**
+Connect to base url with given options and first transport that succeeds,
+trying them in given order, like websocket and then polling for clients
+behind proxies that block websocket upgrade. Every transport is tried with
+its own retries, error of the last transport is returned if all of them
+fail, use Channel.Transport to get transport in use. Connection stays on
+the transport it is made with, it is not upgraded as engine.io does.
+*/
+func DialFirstAvailable(base string, opts DialOptions, transports ...transport.Transport) (*Client, error) {
+	err := ErrorNoTransports
+	for _, tr := range transports {
+		var c *Client
+		if c, err = DialWithOptions(base, tr, opts); err == nil {
+			return c, nil
+		}
+	}
+	return nil, err
+}
+
+/**
+Connect to host and wait till server confirms connection to root namespace,
+transport errors are returned as is, ErrorHandshakeTimeout is returned
+if confirmation is not received in time, *ConnectError if server refuses
+the connect, and ErrorNotConnected if connection is closed before it
+*/
+func DialAndWait(url string, tr transport.Transport, timeout time.Duration) (*Client, error) {
+	c, err := Dial(url, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-c.connected:
+		return c, nil
+	case <-c.Context().Done():
+		if c.connectErr != nil {
+			return nil, c.connectErr
+		}
+		return nil, ErrorNotConnected
+	case <-timer.C:
+		c.Close()
+		return nil, ErrorHandshakeTimeout
+	}
+}
+
+/**
+Connect with channel options, url and headers should be already applied
+*/
+func dial(ctx context.Context, url string, tr transport.Transport, opts DialOptions) (*Client, error) {
 	c := &Client{}
-	c.initChannel()
 	c.initMethods()
+	c.requestedCodec = opts.BinaryCodec
+	c.queueSize = opts.QueueSize
 
-	var err error
-	c.conn, err = tr.Connect(url)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	err := connectChannel(ctx, &c.Channel, &c.methods, url, tr)
+	for i := 0; err != nil && i < opts.Retries; i++ {
+		if err = waitRetry(ctx, opts.RetryDelay); err != nil {
+			return nil, err
+		}
+		err = connectChannel(ctx, &c.Channel, &c.methods, url, tr)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	go inLoop(&c.Channel, &c.methods)
-	go outLoop(&c.Channel, &c.methods)
-	go pinger(&c.Channel)
-
 	return c, nil
 }
 
+/**
+Wait for delay between connect attempts, error of context is returned
+if it is done before
+*/
+func waitRetry(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/**
+Connect transport with given context if it supports one
+*/
+func connectContext(ctx context.Context, url string, tr transport.Transport) (transport.Connection, error) {
+	if ctr, ok := tr.(transport.ContextTransport); ok {
+		return ctr.ConnectContext(ctx, url)
+	}
+	return tr.Connect(url)
+}
+
+/**
+Connect channel to given url and start its loops, ctx aborts
+the connect only, not the established connection
+*/
+func connectChannel(ctx context.Context, c *Channel, m *methods, url string, tr transport.Transport) error {
+	c.initChannel()
+	c.methods = m
+	if u, err := neturl.Parse(url); err == nil {
+		c.engineIO = protocol.GetEngineIOVersion(u.Query().Get("EIO"))
+	}
+
+	var err error
+	c.conn, err = connectContext(ctx, url, tr)
+	if err != nil {
+		c.releaseChannel()
+		c.setState(StateClosed)
+		return err
+	}
+	//channel is open when server confirms connect
+
+	go inLoop(c, m)
+	go outLoop(c, m)
+	//v4 server pings itself, and client answers them
+	if c.engineIO != protocol.EngineIOv4 {
+		go pinger(c)
+	}
+
+	return nil
+}
+
+/**
+Get handlers of given namespace and connect to it, use
+Channel.Of to emit to the namespace
+*/
+func (c *Client) Of(namespace string) *Namespace {
+	n := c.of(namespace, nil)
+	c.Channel.Of(namespace)
+
+	return n
+}
+
 /**
 Close client connection
 */
@@ -0,0 +1,201 @@
+package gosocketio
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graarh/golang-socketio/transport"
+)
+
+/**
+Start raw websocket server, that sends open packet and then gives
+socket to the test
+*/
+func startRawServer(t *testing.T, open string) (string, chan *websocket.Conn) {
+	sockets := make(chan *websocket.Conn, 1)
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		socket, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		socket.WriteMessage(websocket.TextMessage, []byte(open))
+		sockets <- socket
+	}))
+	t.Cleanup(httpServer.Close)
+
+	return "ws" + strings.TrimPrefix(httpServer.URL, "http") + socketioUrl, sockets
+}
+
+func TestClientOpenAfterConnectPacket(t *testing.T) {
+	url, sockets := startRawServer(t, `0{"sid":"test","upgrades":[],"pingInterval":30000,"pingTimeout":60000}`)
+
+	c, err := Dial(url, transport.GetDefaultWebsocketTransport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	socket := <-sockets
+	defer socket.Close()
+
+	if state := c.State(); state != StateConnecting {
+		t.Fatalf("expected connecting state before connect packet, got %v", state)
+	}
+	if !c.IsAlive() {
+		t.Fatal("connecting client is not alive")
+	}
+	if err := c.Emit("early", nil); err != ErrorNotConnected {
+		t.Fatalf("expected ErrorNotConnected, got %v", err)
+	}
+
+	socket.WriteMessage(websocket.TextMessage, []byte("40"))
+	waitFor(t, "open state", func() bool { return c.State() == StateOpen })
+	if err := c.Emit("late", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDialFirstAvailable(t *testing.T) {
+	_, url := startServer(t, transport.GetDefaultPollingTransport())
+
+	c, err := DialFirstAvailable(url, DialOptions{QueueSize: 10},
+		transport.GetDefaultWebsocketTransport(), transport.GetDefaultPollingTransport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if name := c.Transport(); name != "polling" {
+		t.Fatalf("expected polling transport, got %q", name)
+	}
+	if cap(c.out) != 10 {
+		t.Fatalf("dial options are not applied, queue size %d", cap(c.out))
+	}
+
+	if _, err := DialFirstAvailable(url, DialOptions{}); err != ErrorNoTransports {
+		t.Fatalf("expected ErrorNoTransports, got %v", err)
+	}
+}
+
+/**
+Transport that fails every connect and counts attempts
+*/
+type failingTransport struct {
+	transport.Transport
+	attempts int32
+}
+
+var errorConnectFailed = errors.New("connect failed")
+
+func (ft *failingTransport) Connect(url string) (transport.Connection, error) {
+	atomic.AddInt32(&ft.attempts, 1)
+	return nil, errorConnectFailed
+}
+
+func TestDialRetries(t *testing.T) {
+	tr := &failingTransport{}
+
+	_, err := DialWithOptions("ws://localhost", tr, DialOptions{Retries: 2, RetryDelay: time.Millisecond})
+	if err != errorConnectFailed {
+		t.Fatalf("expected error of the last attempt, got %v", err)
+	}
+	if attempts := atomic.LoadInt32(&tr.attempts); attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestDialContextCancelsRetryDelay(t *testing.T) {
+	tr := &failingTransport{}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := DialContext(ctx, "ws://localhost", tr, DialOptions{Retries: 5, RetryDelay: time.Minute})
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("retry delay is not cancelled, dial took %v", elapsed)
+	}
+	if attempts := atomic.LoadInt32(&tr.attempts); attempts != 1 {
+		t.Fatalf("expected 1 attempt, got %d", attempts)
+	}
+
+	if _, err := DialContext(ctx, "ws://localhost", tr, DialOptions{}); err != context.Canceled {
+		t.Fatalf("expected context.Canceled of done context, got %v", err)
+	}
+	if attempts := atomic.LoadInt32(&tr.attempts); attempts != 1 {
+		t.Fatalf("done context should not connect, got %d attempts", attempts)
+	}
+}
+
+func TestFailedConnectReleasesChannel(t *testing.T) {
+	c := &Channel{}
+	var m methods
+	m.initMethods()
+
+	if err := connectChannel(context.Background(), c, &m, "ws://localhost", &failingTransport{}); err != errorConnectFailed {
+		t.Fatalf("expected connect error, got %v", err)
+	}
+	if c.ctx.Err() == nil {
+		t.Fatal("context of failed connect is not cancelled")
+	}
+	select {
+	case <-c.ack.closed:
+	default:
+		t.Fatal("ack processor of failed connect is not closed")
+	}
+	if c.out != nil {
+		t.Fatal("outgoing queue of failed connect is kept")
+	}
+	if c.handlerQueue != nil {
+		t.Fatal("ordered handler queue of failed connect is kept")
+	}
+	if state := c.State(); state != StateClosed {
+		t.Fatalf("expected closed state, got %v", state)
+	}
+}
+
+func TestDialContextCancelsHandshake(t *testing.T) {
+	//server accepts connection and never answers handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	options := map[string]DialOptions{
+		"plain":   {},
+		"headers": {Header: http.Header{"X-Test": {"1"}}},
+	}
+	for name, opts := range options {
+		tr := transport.GetDefaultWebsocketTransport()
+		tr.HandshakeTimeout = 10 * time.Second
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		start := time.Now()
+		_, err := DialContext(ctx, "ws://"+listener.Addr().String(), tr, opts)
+		if err != context.Canceled {
+			t.Fatalf("%s: expected context.Canceled, got %v", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("%s: stalled handshake is not aborted, dial took %v", name, elapsed)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package gosocketio
+
+import (
+	"github.com/graarh/golang-socketio/protocol"
+	"reflect"
+	"strings"
+)
+
+/**
+Server refusal of connect, passed to OnConnectError handler and returned
+by DialAndWait, Data is set by socket.io v3+ servers only
+*/
+type ConnectError struct {
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *ConnectError) Error() string {
+	return e.Message
+}
+
+var connectErrorType = reflect.TypeOf(ConnectError{})
+
+/**
+Parse body of connect error packet, older servers send reason as string,
+newer ones as object with message
+*/
+func parseConnectError(msg *protocol.Message) *ConnectError {
+	ce := &ConnectError{}
+	if strings.HasPrefix(msg.Args, `"`) {
+		if err := protocol.Unmarshal([]byte(msg.Args), &ce.Message); err == nil {
+			return ce
+		}
+	} else if err := protocol.Unmarshal([]byte(msg.Args), ce); err == nil {
+		return ce
+	}
+
+	ce.Message = msg.Args
+	return ce
+}
+
+/**
+Call OnConnectError handler, it gets ConnectError or packet body
+decoded to handler argument type
+*/
+func (m *methods) callConnectError(c *Channel, msg *protocol.Message, ce *ConnectError) {
+	callers, _ := m.findMethod(OnConnectError)
+	for _, f := range callers {
+		switch {
+		case f.direct != nil:
+			m.callDirect(c, OnConnectError, f, msg)
+		case !f.ArgsPresent:
+			m.callHandler(c, OnConnectError, f, &struct{}{})
+		case f.Args == connectErrorType:
+			m.callHandler(c, OnConnectError, f, ce)
+		default:
+			data := f.getArgs()
+			if err := c.unmarshalArgs([]byte(msg.Args), &data); err != nil {
+				continue
+			}
+			m.callHandler(c, OnConnectError, f, data)
+		}
+	}
+}
+
+/**
+Client got refusal of root namespace connect, handler is called
+and connection is closed
+*/
+func rejectedByServer(c *Channel, m *methods, msg *protocol.Message) {
+	ce := parseConnectError(msg)
+	c.connectErr = ce
+
+	m.callConnectError(c, msg, ce)
+	closeChannel(c, m, ce)
+}
+
+/**
+Client got refusal of namespace connect, handler of namespace is called,
+and namespace channel is dropped, so Of connects it again
+*/
+func rejectedNamespace(c *Channel, n *Namespace, msg *protocol.Message) {
+	c.namespacesLock.Lock()
+	nc, ok := c.namespaces[msg.Namespace]
+	delete(c.namespaces, msg.Namespace)
+	c.namespacesLock.Unlock()
+
+	if ok {
+		n.callConnectError(nc, msg, parseConnectError(msg))
+	}
+}
@@ -0,0 +1,53 @@
+package gosocketio
+
+import (
+	"fmt"
+	"github.com/graarh/golang-socketio/transport"
+)
+
+/**
+Details of connection negotiated during handshake, fields are zero
+if transport does not tell them
+*/
+type ConnInfo struct {
+	//engine.io name of transport, like "websocket"
+	Transport string
+	//engine.io protocol version
+	EngineIO int
+
+	Subprotocol string
+	RemoteAddr  string
+	Compression bool
+
+	ReadBufferSize  int
+	WriteBufferSize int
+}
+
+func (i ConnInfo) String() string {
+	return fmt.Sprintf("transport=%s eio=%d subprotocol=%q remote=%s compression=%t buffers=%d/%d",
+		i.Transport, i.EngineIO, i.Subprotocol, i.RemoteAddr, i.Compression,
+		i.ReadBufferSize, i.WriteBufferSize)
+}
+
+/**
+Get details of connection, like for logging in OnConnection handler
+*/
+func (c *Channel) ConnInfo() ConnInfo {
+	if c.root != nil {
+		return c.root.ConnInfo()
+	}
+
+	info := ConnInfo{
+		Transport: c.Transport(),
+		EngineIO:  c.engineIO,
+	}
+	if addr := c.conn.RemoteAddr(); addr != nil {
+		info.RemoteAddr = addr.String()
+	}
+	if nc, ok := c.conn.(transport.NegotiatedConnection); ok {
+		info.Subprotocol = nc.Subprotocol()
+		info.Compression = nc.Compression()
+		info.ReadBufferSize, info.WriteBufferSize = nc.BufferSizes()
+	}
+	return info
+}
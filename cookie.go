@@ -0,0 +1,149 @@
+package gosocketio
+
+import (
+	"github.com/graarh/golang-socketio/transport"
+	"net/http"
+	"sync"
+)
+
+const (
+	//name of sid cookie, as engine.io servers use
+	DefaultSidCookieName = "io"
+)
+
+/**
+Cookie carrying sid of connection, set on handshake response, so load
+balancer could route following requests of the session to the same server
+*/
+type SidCookie struct {
+	//cookie name, DefaultSidCookieName if empty
+	Name string
+
+	//cookie path, root one if empty
+	Path string
+
+	HttpOnly bool
+	Secure   bool
+	SameSite http.SameSite
+}
+
+type sidCookieConfig struct {
+	cookie *SidCookie
+	lock   sync.RWMutex
+}
+
+/**
+Set sid cookie on handshake responses of accepted websocket and polling
+connections, nil turns it off, websocket gets it with upgrade response,
+before middlewares are run. It is meant for sticky load balancers, but
+polling request without sid in url continues connection named by the
+cookie, if it is a live polling connection of this server, sid of url
+is preferred. Cookie of resumed connection carries its previous sid,
+see EnableSessionResume
+*/
+func (s *Server) SetSidCookie(cookie *SidCookie) {
+	s.sidCookie.lock.Lock()
+	defer s.sidCookie.lock.Unlock()
+
+	if cookie != nil {
+		copied := *cookie
+		cookie = &copied
+	}
+	s.sidCookie.cookie = cookie
+}
+
+func (s *Server) getSidCookie() *SidCookie {
+	s.sidCookie.lock.RLock()
+	defer s.sidCookie.lock.RUnlock()
+
+	return s.sidCookie.cookie
+}
+
+func (cookie *SidCookie) name() string {
+	if cookie.Name == "" {
+		return DefaultSidCookieName
+	}
+	return cookie.Name
+}
+
+/**
+Get sid cookie of given sid, nil if it is turned off
+*/
+func (s *Server) newSidCookie(sid string) *http.Cookie {
+	cookie := s.getSidCookie()
+	if cookie == nil {
+		return nil
+	}
+
+	path := cookie.Path
+	if path == "" {
+		path = "/"
+	}
+	return &http.Cookie{
+		Name:     cookie.name(),
+		Value:    sid,
+		Path:     path,
+		HttpOnly: cookie.HttpOnly,
+		Secure:   cookie.Secure,
+		SameSite: cookie.SameSite,
+	}
+}
+
+/**
+Get handshake request, which websocket upgrade sets sid cookie, so it is
+not sent if upgrade fails
+*/
+func (s *Server) withSidCookie(r *http.Request, sid string) *http.Request {
+	cookie := s.newSidCookie(sid)
+	if cookie == nil {
+		return r
+	}
+	return transport.WithUpgradeHeader(r, http.Header{"Set-Cookie": {cookie.String()}})
+}
+
+/**
+Set sid cookie on response of accepted handshake, polling transport
+writes it after connection is set up, hijacked websocket connection
+ignores it, as it is sent with upgrade response
+*/
+func (s *Server) setSidCookie(w http.ResponseWriter, sid string) {
+	if cookie := s.newSidCookie(sid); cookie != nil {
+		http.SetCookie(w, cookie)
+	}
+}
+
+/**
+Get request with sid of sid cookie if url has none and the cookie names
+live polling connection of this server, so client which lost sid of the
+url continues its connection instead of making new one
+*/
+func (s *Server) withCookieSid(r *http.Request) *http.Request {
+	if r.URL.Query().Get("sid") != "" {
+		return r
+	}
+	cookie := s.getSidCookie()
+	if cookie == nil {
+		return r
+	}
+	value, err := r.Cookie(cookie.name())
+	if err != nil || value.Value == "" {
+		return r
+	}
+
+	c, err := s.GetChannel(value.Value)
+	if err != nil {
+		return r
+	}
+	if nc, ok := c.conn.(transport.NamedConnection); !ok || nc.TransportName() != transport.NamePolling {
+		return r
+	}
+
+	u := *r.URL
+	query := u.Query()
+	query.Set("sid", value.Value)
+	u.RawQuery = query.Encode()
+
+	withSid := r.WithContext(r.Context())
+	withSid.URL = &u
+	return withSid
+}
@@ -0,0 +1,147 @@
+package gosocketio
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graarh/golang-socketio/transport"
+)
+
+var sidPattern = regexp.MustCompile(`"sid":"([^"]+)"`)
+
+/**
+Start polling server with sid cookie, returns it with its base url
+*/
+func startCookieServer(t *testing.T) (*Server, string) {
+	s := NewServer(transport.GetDefaultPollingTransport())
+	s.SetSidCookie(&SidCookie{})
+	httpServer := httptest.NewServer(s)
+	t.Cleanup(httpServer.Close)
+
+	return s, httpServer.URL + "/socket.io/?EIO=3&transport=polling"
+}
+
+/**
+Make polling GET request with given sid cookie, returns response and body
+*/
+func pollWithCookie(t *testing.T, url string, sid string) (*http.Response, string) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sid != "" {
+		req.AddCookie(&http.Cookie{Name: DefaultSidCookieName, Value: sid})
+	}
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+/**
+Get sid of open packet in polling payload, empty if there is none
+*/
+func payloadSid(payload string) string {
+	if match := sidPattern.FindStringSubmatch(payload); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+/**
+Get value of sid cookie set by response, empty if none is set
+*/
+func responseSid(resp *http.Response) string {
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == DefaultSidCookieName {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func TestSidCookieOfAcceptedConnection(t *testing.T) {
+	_, url := startCookieServer(t)
+
+	resp, payload := pollWithCookie(t, url, "")
+	sid := payloadSid(payload)
+	if sid == "" || responseSid(resp) != sid {
+		t.Fatalf("expected cookie with sid %q, got %v", sid, resp.Cookies())
+	}
+
+	s, wsUrl := startServer(t, transport.GetDefaultWebsocketTransport())
+	s.SetSidCookie(&SidCookie{})
+	socket, wsResp, err := websocket.DefaultDialer.Dial(wsUrl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+	if sid := openHeader(t, readPacket(t, socket))["sid"]; responseSid(wsResp) != sid {
+		t.Fatalf("expected cookie with sid %v, got %v", sid, wsResp.Cookies())
+	}
+}
+
+func TestSidCookieNotSetOnRejected(t *testing.T) {
+	s, url := startCookieServer(t)
+
+	resp, _ := pollWithCookie(t, strings.Replace(url, "EIO=3", "EIO=5", 1), "")
+	if resp.StatusCode != http.StatusBadRequest || responseSid(resp) != "" {
+		t.Fatalf("bad handshake is answered with %d and cookies %v", resp.StatusCode, resp.Cookies())
+	}
+
+	s.Use(func(c *Channel, next func() error) error {
+		return errors.New("nope")
+	})
+	if resp, _ := pollWithCookie(t, url, ""); responseSid(resp) != "" {
+		t.Fatalf("rejected connection got cookies %v", resp.Cookies())
+	}
+
+	ws, wsUrl := startServer(t, transport.GetDefaultWebsocketTransport())
+	ws.SetSidCookie(&SidCookie{})
+	_, wsResp, err := websocket.DefaultDialer.Dial(strings.Replace(wsUrl, "EIO=3", "EIO=5", 1), nil)
+	if err == nil || wsResp == nil || responseSid(wsResp) != "" {
+		t.Fatalf("failed upgrade got cookie, %v", err)
+	}
+}
+
+func TestSidCookieContinuesPolling(t *testing.T) {
+	s, url := startCookieServer(t)
+	_, payload := pollWithCookie(t, url, "")
+	sid := payloadSid(payload)
+
+	c, err := s.GetChannel(sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Emit("hello", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	//url without sid continues connection of the cookie
+	if _, payload := pollWithCookie(t, url, sid); !strings.Contains(payload, `"hello"`) || payloadSid(payload) != "" {
+		t.Fatalf("request with sid cookie is answered with %q", payload)
+	}
+	//stale cookie makes new connection
+	resp, payload := pollWithCookie(t, url, "stale")
+	if newSid := payloadSid(payload); newSid == "" || newSid == sid || responseSid(resp) != newSid {
+		t.Fatalf("stale cookie handshake is answered with %q", payload)
+	}
+
+	//sid of url is preferred
+	if err := c.Emit("again", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, payload := pollWithCookie(t, url+"&sid="+sid, "stale"); !strings.Contains(payload, `"again"`) {
+		t.Fatalf("request with sid in url is answered with %q", payload)
+	}
+}
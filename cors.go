@@ -0,0 +1,98 @@
+package gosocketio
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrorOriginNotAllowed = errors.New("Origin not allowed")
+)
+
+/**
+Set origins allowed to connect, as hosts like example.com, origins
+like https://example.com, subdomain wildcards like *.example.com,
+or * for any. Empty list turns origin check off. Requests without
+Origin header, made not by browsers, are always allowed.
+*/
+func (s *Server) SetAllowedOrigins(origins []string) {
+	s.originsLock.Lock()
+	defer s.originsLock.Unlock()
+
+	s.allowedOrigins = append([]string(nil), origins...)
+}
+
+/**
+Check that origin matches one of allowed ones, returns value of
+Access-Control-Allow-Origin header for it, empty if origin check is off
+*/
+func (s *Server) allowOrigin(origin string) (allow string, ok bool) {
+	s.originsLock.RLock()
+	defer s.originsLock.RUnlock()
+
+	if len(s.allowedOrigins) == 0 || origin == "" {
+		return "", true
+	}
+
+	host, hostname := "", ""
+	if u, err := url.Parse(origin); err == nil {
+		host, hostname = u.Host, u.Hostname()
+	}
+
+	wildcard := false
+	for _, allowed := range s.allowedOrigins {
+		switch {
+		case allowed == "*":
+			wildcard = true
+		case allowed == origin:
+			return origin, true
+		case host == "":
+			//null or broken origin matches only exactly
+		case allowed == host, allowed == hostname:
+			return origin, true
+		case strings.HasPrefix(allowed, "*.") &&
+			strings.HasSuffix(hostname, allowed[1:]):
+			return origin, true
+		}
+	}
+	if wildcard {
+		return "*", true
+	}
+	return "", false
+}
+
+/**
+Check origin of request and set CORS headers of response,
+false if request is refused. Preflight requests are answered here
+if origin check is on. Origins allowed by * get literal * without
+credentials, as browsers refuse credentials with wildcard.
+*/
+func (s *Server) handleCORS(w http.ResponseWriter, r *http.Request) bool {
+	allow, ok := s.allowOrigin(r.Header.Get("Origin"))
+	if !ok {
+		http.Error(w, ErrorOriginNotAllowed.Error(), http.StatusForbidden)
+		return false
+	}
+	if allow == "" {
+		return true
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", allow)
+	if allow != "*" {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Add("Vary", "Origin")
+	}
+
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+
+	return true
+}
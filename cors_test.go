@@ -0,0 +1,91 @@
+package gosocketio
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/graarh/golang-socketio/transport"
+)
+
+/**
+Run CORS check of request with given method and origin
+*/
+func corsRequest(s *Server, method, origin string) (*httptest.ResponseRecorder, bool) {
+	r := httptest.NewRequest(method, "/socket.io/?EIO=3&transport=polling", nil)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	w := httptest.NewRecorder()
+	return w, s.handleCORS(w, r)
+}
+
+func TestCORSAllowedOrigins(t *testing.T) {
+	s := NewServer(transport.GetDefaultWebsocketTransport())
+	s.SetAllowedOrigins([]string{"https://example.com", "*.example.org", "null"})
+
+	for _, origin := range []string{"https://example.com", "https://chat.example.org", "null"} {
+		w, ok := corsRequest(s, "GET", origin)
+		if !ok {
+			t.Fatalf("%s is refused", origin)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != origin {
+			t.Fatalf("%s is answered with allowed origin %s", origin, got)
+		}
+		if w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+			t.Fatalf("%s is answered without credentials", origin)
+		}
+	}
+
+	w, ok := corsRequest(s, "OPTIONS", "https://example.com")
+	if ok || w.Code != http.StatusNoContent {
+		t.Fatalf("preflight is not answered, %d", w.Code)
+	}
+}
+
+func TestCORSDisallowedOrigins(t *testing.T) {
+	s := NewServer(transport.GetDefaultWebsocketTransport())
+	s.SetAllowedOrigins([]string{"https://example.com", "*.example.org"})
+
+	for _, origin := range []string{"https://evil.com", "https://example.org.evil.com", "null"} {
+		w, ok := corsRequest(s, "GET", origin)
+		if ok || w.Code != http.StatusForbidden {
+			t.Fatalf("%s is not refused, %d", origin, w.Code)
+		}
+		if w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Fatalf("%s is refused with allowed origin header", origin)
+		}
+	}
+}
+
+func TestCORSWildcard(t *testing.T) {
+	s := NewServer(transport.GetDefaultWebsocketTransport())
+	s.SetAllowedOrigins([]string{"*"})
+
+	for _, origin := range []string{"https://example.com", "null"} {
+		w, ok := corsRequest(s, "GET", origin)
+		if !ok {
+			t.Fatalf("%s is refused", origin)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+			t.Fatalf("%s is answered with allowed origin %s", origin, got)
+		}
+		if w.Header().Get("Access-Control-Allow-Credentials") != "" {
+			t.Fatalf("%s is answered with credentials", origin)
+		}
+	}
+}
+
+func TestCORSOff(t *testing.T) {
+	s := NewServer(transport.GetDefaultWebsocketTransport())
+
+	for _, method := range []string{"GET", "OPTIONS"} {
+		w, ok := corsRequest(s, method, "https://example.com")
+		if !ok {
+			t.Fatalf("%s is refused or answered", method)
+		}
+		if len(w.Header()) != 0 {
+			t.Fatalf("%s got CORS headers %v", method, w.Header())
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package gosocketio
+
+import (
+	"github.com/graarh/golang-socketio/transport"
+	"net"
+	"reflect"
+	"sync/atomic"
+)
+
+/**
+Reason of disconnection, passed to OnDisconnection handler
+if it accepts it as argument
+*/
+type DisconnectReason int32
+
+const (
+	//connection is not closed yet
+	DisconnectUnknown DisconnectReason = iota
+	//client closed connection, or server got close frame from it
+	DisconnectClientClose
+	//server closed connection, or client got close frame from it
+	DisconnectServerClose
+	//nothing received within ping or idle timeout
+	DisconnectPingTimeout
+	//connection read or written with error
+	DisconnectTransportError
+	//connection closed by server shutdown
+	DisconnectShutdown
+	//no namespace connect received within connect timeout
+	DisconnectConnectTimeout
+)
+
+var (
+	disconnectReasonType = reflect.TypeOf(DisconnectReason(0))
+	disconnectInfoType   = reflect.TypeOf(DisconnectInfo{})
+)
+
+/**
+Reason of disconnection with close frame of peer, passed to
+OnDisconnection handler if it accepts it as argument
+*/
+type DisconnectInfo struct {
+	Reason DisconnectReason
+
+	//code and text of peer close frame, like transport.CloseGoingAway,
+	//zero code if peer did not send it or transport has no close frames
+	Code int
+	Text string
+}
+
+func (r DisconnectReason) String() string {
+	switch r {
+	case DisconnectClientClose:
+		return "client close"
+	case DisconnectServerClose:
+		return "server close"
+	case DisconnectPingTimeout:
+		return "ping timeout"
+	case DisconnectTransportError:
+		return "transport error"
+	case DisconnectShutdown:
+		return "shutdown"
+	case DisconnectConnectTimeout:
+		return "connect timeout"
+	}
+	return "unknown"
+}
+
+/**
+Get reason of disconnection, DisconnectUnknown till connection is closed
+*/
+func (c *Channel) DisconnectReason() DisconnectReason {
+	if c.root != nil {
+		return c.root.DisconnectReason()
+	}
+	return DisconnectReason(atomic.LoadInt32(&c.disconnectReason))
+}
+
+/**
+Get reason of disconnection with close frame of peer, reason is
+DisconnectUnknown till connection is closed
+*/
+func (c *Channel) DisconnectInfo() DisconnectInfo {
+	if c.root != nil {
+		return c.root.DisconnectInfo()
+	}
+
+	info := DisconnectInfo{Reason: c.DisconnectReason()}
+	if cc, ok := c.conn.(transport.CloseStatusConnection); ok {
+		info.Code, info.Text = cc.CloseStatus()
+	}
+	return info
+}
+
+/**
+Get error closeChannel is called with, nil for local close
+*/
+func closeError(args []interface{}) error {
+	if len(args) == 0 {
+		return nil
+	}
+	err, _ := args[0].(error)
+	return err
+}
+
+/**
+Get disconnection reason by arguments of closeChannel, no arguments
+or local error means connection is closed by this side
+*/
+func (c *Channel) getDisconnectReason(args []interface{}) DisconnectReason {
+	local, remote := DisconnectClientClose, DisconnectServerClose
+	if c.server != nil {
+		local, remote = DisconnectServerClose, DisconnectClientClose
+		if c.server.isShutdown() {
+			local = DisconnectShutdown
+		}
+	}
+
+	if len(args) == 0 {
+		return local
+	}
+
+	switch err := args[0].(type) {
+	case DisconnectReason:
+		return err
+	case *ConnectError:
+		return remote
+	case error:
+		switch err {
+		case transport.ErrorConnectionClosed:
+			if atomic.LoadInt32(&c.closeSent) == 1 {
+				return local
+			}
+			return remote
+		case ErrorIdleTimeout, transport.ErrorReceiveTimeout:
+			return DisconnectPingTimeout
+		case ErrorConnectTimeout:
+			return DisconnectConnectTimeout
+		case ErrorSocketOverflood, ErrorRateLimited, ErrorEventNameTooLong,
+			ErrorPayloadTooLarge, ErrorPayloadTooDeep, transport.ErrorFrameTimeout:
+			return local
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return DisconnectPingTimeout
+		}
+	}
+
+	return DisconnectTransportError
+}
@@ -0,0 +1,130 @@
+package gosocketio
+
+import (
+	"context"
+	"github.com/graarh/golang-socketio/protocol"
+)
+
+/**
+Scheduling of event handlers of one connection
+*/
+type HandlerMode int
+
+const (
+	//every event is handled in its own goroutine, default one
+	HandlerConcurrent HandlerMode = iota
+	//events are handled one at a time in order of receiving
+	HandlerOrdered
+)
+
+const (
+	//events waiting for ordered handler, incoming loop waits if it is full
+	handlerQueueSize = 64
+)
+
+/**
+Set scheduling of event handlers of this connection and its namespaces,
+maxConcurrent bounds running handlers of concurrent mode, incoming loop
+waits for a free one, zero means no bound. It should be set before
+events arrive, like in server OnConnection handler or with
+Server.SetHandlerMode, as order of events queued before the change
+is not kept. Ack responses are never queued, so ordered handler
+could wait for ack.
+*/
+func (c *Channel) SetHandlerMode(mode HandlerMode, maxConcurrent int) {
+	if c.root != nil {
+		c.root.SetHandlerMode(mode, maxConcurrent)
+		return
+	}
+
+	c.handlerLock.Lock()
+	defer c.handlerLock.Unlock()
+
+	c.handlerMode = mode
+	c.handlerSlots = nil
+	if mode == HandlerConcurrent && maxConcurrent > 0 {
+		c.handlerSlots = make(chan struct{}, maxConcurrent)
+	}
+	if mode == HandlerOrdered && c.handlerQueue == nil {
+		c.handlerQueue = make(chan func(), handlerQueueSize)
+		go c.handlerWorker(c.ctx, c.handlerQueue)
+	}
+}
+
+/**
+Set handler mode of new connections, see Channel.SetHandlerMode
+*/
+func (s *Server) SetHandlerMode(mode HandlerMode, maxConcurrent int) {
+	s.handlerModeLock.Lock()
+	defer s.handlerModeLock.Unlock()
+
+	s.handlerMode = mode
+	s.maxConcurrentHandlers = maxConcurrent
+}
+
+func (s *Server) applyHandlerMode(c *Channel) {
+	s.handlerModeLock.RLock()
+	mode, maxConcurrent := s.handlerMode, s.maxConcurrentHandlers
+	s.handlerModeLock.RUnlock()
+
+	if mode != HandlerConcurrent || maxConcurrent > 0 {
+		c.SetHandlerMode(mode, maxConcurrent)
+	}
+}
+
+/**
+Run handlers of ordered mode till connection is closed,
+handlers queued after closing are dropped
+*/
+func (c *Channel) handlerWorker(ctx context.Context, queue chan func()) {
+	for {
+		select {
+		case f := <-queue:
+			f()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+/**
+Run handling of incoming message by handler mode of connection,
+called by incoming loop only
+*/
+func (c *Channel) dispatchHandler(msg *protocol.Message, f func()) {
+	root := c
+	if c.root != nil {
+		root = c.root
+	}
+
+	if msg.Type == protocol.MessageTypeAckResponse {
+		go f()
+		return
+	}
+
+	root.handlerLock.Lock()
+	mode, slots, queue := root.handlerMode, root.handlerSlots, root.handlerQueue
+	root.handlerLock.Unlock()
+
+	if mode == HandlerOrdered {
+		select {
+		case queue <- f:
+		case <-root.ctx.Done():
+		}
+		return
+	}
+
+	if slots == nil {
+		go f()
+		return
+	}
+	select {
+	case slots <- struct{}{}:
+	case <-root.ctx.Done():
+		return
+	}
+	go func() {
+		defer func() { <-slots }()
+		f()
+	}()
+}
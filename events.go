@@ -0,0 +1,112 @@
+//go:build go1.18
+// +build go1.18
+
+package gosocketio
+
+import (
+	"errors"
+	"github.com/graarh/golang-socketio/protocol"
+	"reflect"
+	"sync"
+)
+
+var (
+	ErrorEventNotRegistered = errors.New("Event type is not registered")
+	ErrorEventRegistered    = errors.New("Event type or name is already registered")
+)
+
+/**
+Event names of types registered by RegisterEvent
+*/
+var (
+	eventNames     = make(map[reflect.Type]string)
+	eventTypes     = make(map[string]reflect.Type)
+	eventNamesLock sync.RWMutex
+)
+
+/**
+Handlers registry, implemented by Server, Client, Namespace
+and ReconnectingClient
+*/
+type HandlerRegistry interface {
+	On(method string, f interface{}) error
+	addCaller(method string, c *caller)
+}
+
+/**
+Add typed message processing function, bind it to given method
+Arguments are decoded into T without reflection, decoding errors and
+errors returned by handler are passed to OnError handler as EventError
+*/
+func OnEvent[T any](r HandlerRegistry, method string, h func(c *Channel, data T) error) {
+	r.addCaller(method, &caller{
+		direct: func(c *Channel, msg *protocol.Message) error {
+			var data T
+			if msg != nil && msg.Args != "" {
+				if err := c.decodeArgs(msg, &data); err != nil {
+					return err
+				}
+			}
+
+			return h(c, data)
+		},
+	})
+}
+
+/**
+Bind event name to type T, so EmitTyped and OnTyped take name from the type,
+both peers should register the same names, usually at init, registering
+another name for the type or another type for the name is refused
+*/
+func RegisterEvent[T any](name string) error {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+
+	eventNamesLock.Lock()
+	defer eventNamesLock.Unlock()
+
+	if known, ok := eventNames[t]; ok && known != name {
+		return ErrorEventRegistered
+	}
+	if known, ok := eventTypes[name]; ok && known != t {
+		return ErrorEventRegistered
+	}
+	eventNames[t] = name
+	eventTypes[name] = t
+	return nil
+}
+
+/**
+Get event name registered for type T
+*/
+func EventName[T any]() (string, bool) {
+	eventNamesLock.RLock()
+	defer eventNamesLock.RUnlock()
+
+	name, ok := eventNames[reflect.TypeOf((*T)(nil)).Elem()]
+	return name, ok
+}
+
+/**
+Emit value as event registered for its type
+*/
+func EmitTyped[T any](c *Channel, value T) error {
+	name, ok := EventName[T]()
+	if !ok {
+		return ErrorEventNotRegistered
+	}
+
+	return c.Emit(name, value)
+}
+
+/**
+Add typed handler of event registered for type T, see OnEvent
+*/
+func OnTyped[T any](r HandlerRegistry, h func(c *Channel, data T) error) error {
+	name, ok := EventName[T]()
+	if !ok {
+		return ErrorEventNotRegistered
+	}
+
+	OnEvent(r, name, h)
+	return nil
+}
@@ -0,0 +1,89 @@
+//go:build go1.18
+// +build go1.18
+
+package gosocketio
+
+import (
+	"testing"
+
+	"github.com/graarh/golang-socketio/protocol"
+	"github.com/graarh/golang-socketio/transport"
+)
+
+type benchmarkEvent struct {
+	Id   int      `json:"id"`
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+const benchmarkArgs = `{"id":1,"name":"event","tags":["a","b"]}`
+
+/**
+Call handler of given method with benchmark event, as incoming loop does
+*/
+func benchmarkHandler(b *testing.B, s *Server, method string) {
+	c := &Channel{}
+	c.initChannel()
+	c.methods = &s.methods
+	msg := &protocol.Message{
+		Type:   protocol.MessageTypeEmit,
+		Method: method,
+		Args:   benchmarkArgs,
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.processIncomingMessage(c, msg)
+	}
+}
+
+func BenchmarkReflectiveOn(b *testing.B) {
+	s := NewServer(transport.GetDefaultWebsocketTransport())
+	s.On("event", func(c *Channel, e benchmarkEvent) {
+		if e.Id != 1 {
+			b.Fatal("wrong event")
+		}
+	})
+	benchmarkHandler(b, s, "event")
+}
+
+func BenchmarkGenericOnEvent(b *testing.B) {
+	s := NewServer(transport.GetDefaultWebsocketTransport())
+	OnEvent(s, "event", func(c *Channel, e benchmarkEvent) error {
+		if e.Id != 1 {
+			b.Fatal("wrong event")
+		}
+		return nil
+	})
+	benchmarkHandler(b, s, "event")
+}
+
+func TestOnEventDecodeError(t *testing.T) {
+	s := NewServer(transport.GetDefaultWebsocketTransport())
+	called := false
+	OnEvent(s, "event", func(c *Channel, e benchmarkEvent) error {
+		called = true
+		return nil
+	})
+	var reported EventError
+	s.On(OnError, func(c *Channel, e EventError) {
+		reported = e
+	})
+
+	c := &Channel{}
+	c.initChannel()
+	c.methods = &s.methods
+	s.processIncomingMessage(c, &protocol.Message{
+		Type:   protocol.MessageTypeEmit,
+		Method: "event",
+		Args:   `{"id":"not a number"}`,
+	})
+
+	if called {
+		t.Fatal("handler is called with undecodable args")
+	}
+	if reported.Event != "event" || reported.Err == nil {
+		t.Fatalf("decode error is not reported, got %+v", reported)
+	}
+}
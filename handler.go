@@ -2,65 +2,207 @@ package gosocketio
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/graarh/golang-socketio/protocol"
-	"sync"
 	"reflect"
+	"sync"
 )
 
 const (
 	OnConnection    = "connection"
 	OnDisconnection = "disconnection"
 	OnError         = "error"
+
+	//client side event of connect refused by server
+	OnConnectError = "connect_error"
+
+	//catch-all event, its handler is called for events without own handler
+	OnAny = "*"
+)
+
+var (
+	ErrorAnyHandlerWrongType = errors.New("f should be func(*Channel, string, json.RawMessage)")
 )
 
+/**
+Ack sent instead of result when handler returns error
+*/
+type AckError struct {
+	Message string `json:"error"`
+}
+
+/**
+Error of event processing, passed to OnError handler
+if it accepts it as argument
+*/
+type EventError struct {
+	Event string
+	Err   error
+}
+
+func (e EventError) Error() string {
+	return e.Event + ": " + e.Err.Error()
+}
+
+/**
+Catch-all handler, gets event name and its raw arguments
+*/
+type anyHandler func(c *Channel, event string, data json.RawMessage)
+
 /**
 System handler function for internal event processing
 */
 type systemHandler func(c *Channel)
 
 /**
-Contains maps of message processing functions
+Contains maps of message processing functions, every event could have
+several of them, they are called in order of registration
 */
 type methods struct {
-	messageHandlers     map[string]*caller
+	messageHandlers     map[string][]*caller
+	anyHandler          anyHandler
 	messageHandlersLock sync.RWMutex
 
 	onConnection    systemHandler
 	onDisconnection systemHandler
+
+	namespaces     map[string]*Namespace
+	namespacesLock sync.RWMutex
+
+	logger     Logger
+	loggerLock sync.RWMutex
 }
 
 /**
 create messageHandlers map
 */
 func (m *methods) initMethods() {
-	m.messageHandlers = make(map[string]*caller)
+	m.messageHandlers = make(map[string][]*caller)
+	m.namespaces = make(map[string]*Namespace)
+}
+
+/**
+Get handlers of given namespace, create and init them if not exists
+*/
+func (m *methods) of(namespace string, init func(n *Namespace)) *Namespace {
+	m.namespacesLock.Lock()
+	defer m.namespacesLock.Unlock()
+
+	n, ok := m.namespaces[namespace]
+	if !ok {
+		n = &Namespace{name: namespace}
+		n.initMethods()
+		if init != nil {
+			init(n)
+		}
+		m.namespaces[namespace] = n
+	}
+	return n
+}
+
+/**
+Get names of namespaces with handlers
+*/
+func (m *methods) namespaceNames() []string {
+	m.namespacesLock.RLock()
+	defer m.namespacesLock.RUnlock()
+
+	names := make([]string, 0, len(m.namespaces))
+	for name := range m.namespaces {
+		names = append(names, name)
+	}
+	return names
 }
 
 /**
-Add message processing function, and bind it to given method
+Find handlers of given namespace
+*/
+func (m *methods) findNamespace(namespace string) (*Namespace, bool) {
+	m.namespacesLock.RLock()
+	defer m.namespacesLock.RUnlock()
+
+	n, ok := m.namespaces[namespace]
+	return n, ok
+}
+
+/**
+Add message processing function, and bind it to given method,
+function is added to already bound ones, all of them are called
+in order of registration, and the first one returning result answers
+ack. Catch-all handler of OnAny is the only one, it is replaced.
 */
 func (m *methods) On(method string, f interface{}) error {
+	if method == OnAny {
+		h, ok := f.(func(c *Channel, event string, data json.RawMessage))
+		if !ok {
+			return ErrorAnyHandlerWrongType
+		}
+
+		m.messageHandlersLock.Lock()
+		defer m.messageHandlersLock.Unlock()
+		m.anyHandler = h
+
+		return nil
+	}
+
 	c, err := newCaller(f)
 	if err != nil {
 		return err
 	}
 
+	m.addCaller(method, c)
+
+	return nil
+}
+
+/**
+Remove all processing functions of given method, OnAny removes
+catch-all handler, events already being processed are not affected
+*/
+func (m *methods) Off(method string) {
 	m.messageHandlersLock.Lock()
 	defer m.messageHandlersLock.Unlock()
-	m.messageHandlers[method] = c
 
-	return nil
+	if method == OnAny {
+		m.anyHandler = nil
+		return
+	}
+	delete(m.messageHandlers, method)
+}
+
+/**
+Remove processing functions of all methods, including loop events
+ones and catch-all handler
+*/
+func (m *methods) RemoveAllListeners() {
+	m.messageHandlersLock.Lock()
+	defer m.messageHandlersLock.Unlock()
+
+	m.messageHandlers = make(map[string][]*caller)
+	m.anyHandler = nil
+}
+
+/**
+Add typed processing function, bind it to given method
+*/
+func (m *methods) addCaller(method string, c *caller) {
+	m.messageHandlersLock.Lock()
+	defer m.messageHandlersLock.Unlock()
+
+	//slice is copied, so found ones are not changed while being called
+	callers := m.messageHandlers[method]
+	m.messageHandlers[method] = append(callers[:len(callers):len(callers)], c)
 }
 
 /**
-Find message processing function associated with given method
+Find message processing functions associated with given method
 */
-func (m *methods) findMethod(method string) (*caller, bool) {
+func (m *methods) findMethod(method string) ([]*caller, bool) {
 	m.messageHandlersLock.RLock()
 	defer m.messageHandlersLock.RUnlock()
 
-	f, ok := m.messageHandlers[method]
-	return f, ok
+	callers := m.messageHandlers[method]
+	return callers, len(callers) > 0
 }
 
 func (m *methods) callLoopEvent(c *Channel, event string) {
@@ -71,12 +213,134 @@ func (m *methods) callLoopEvent(c *Channel, event string) {
 		m.onDisconnection(c)
 	}
 
-	f, ok := m.findMethod(event)
-	if !ok {
+	callers, _ := m.findMethod(event)
+	for _, f := range callers {
+		switch {
+		case f.direct != nil:
+			m.callDirect(c, event, f, nil)
+		case event == OnDisconnection && f.Args == disconnectReasonType:
+			reason := c.DisconnectReason()
+			m.callHandler(c, event, f, &reason)
+		case event == OnDisconnection && f.Args == disconnectInfoType:
+			info := c.DisconnectInfo()
+			m.callHandler(c, event, f, &info)
+		default:
+			m.callHandler(c, event, f, &struct{}{})
+		}
+	}
+}
+
+/**
+Pass event processing error to OnError handler, if it is set
+*/
+func (m *methods) callErrorEvent(c *Channel, event string, err error) {
+	callers, _ := m.findMethod(OnError)
+	for _, f := range callers {
+		switch {
+		case f.direct != nil:
+		case !f.ArgsPresent:
+			m.callHandler(c, OnError, f, &struct{}{})
+		case f.Args == reflect.TypeOf(EventError{}):
+			m.callHandler(c, OnError, f, &EventError{Event: event, Err: err})
+		}
+	}
+}
+
+/**
+Call handler of given event, handler panic is recovered
+and reported, and the channel is closed
+*/
+func (m *methods) callHandler(c *Channel, event string, f *caller,
+	args interface{}) (result []reflect.Value, ok bool) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			recoverHandler(c, event, r)
+			result, ok = nil, false
+		}
+	}()
+
+	return f.callFunc(c, args), true
+}
+
+/**
+Call typed handler of given event, decoding and handler errors
+are passed to OnError handler, msg is nil for loop events
+*/
+func (m *methods) callDirect(c *Channel, event string, f *caller, msg *protocol.Message) {
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				recoverHandler(c, event, r)
+				err = nil
+			}
+		}()
+
+		return f.direct(c, msg)
+	}()
+
+	if err != nil {
+		m.callErrorEvent(c, event, err)
+	}
+}
+
+/**
+Call catch-all handler, if set, for event without own handler
+*/
+func (m *methods) callAnyHandler(c *Channel, msg *protocol.Message) {
+	m.messageHandlersLock.RLock()
+	h := m.anyHandler
+	m.messageHandlersLock.RUnlock()
+
+	if h == nil {
 		return
 	}
 
-	f.callFunc(c, &struct{}{})
+	defer func() {
+		if r := recover(); r != nil {
+			recoverHandler(c, msg.Method, r)
+		}
+	}()
+
+	h(c, msg.Method, json.RawMessage(msg.Args))
+}
+
+/**
+Decode arguments of event or ack request and call handler with them,
+ok is false if handler is not called, panics or is a typed one
+*/
+func (m *methods) callEventHandler(c *Channel, msg *protocol.Message, f *caller) (
+	result []reflect.Value, ok bool) {
+
+	if f.direct != nil {
+		m.callDirect(c, msg.Method, f, msg)
+		return nil, false
+	}
+
+	if !f.ArgsPresent {
+		return m.callHandler(c, msg.Method, f, &struct{}{})
+	}
+
+	//data type should be defined for unmarshall
+	data := f.getArgs()
+	if err := c.decodeArgs(msg, &data); err != nil {
+		return nil, false
+	}
+	return m.callHandler(c, msg.Method, f, data)
+}
+
+/**
+Report handler panic to server panic handler or log, and close the channel
+*/
+func recoverHandler(c *Channel, event string, r interface{}) {
+	if c.server != nil && c.server.panicHandler != nil {
+		c.server.panicHandler(c, event, r)
+	} else {
+		c.logger().Error("handler panic", "sid", c.Id(), "event", event, "panic", r)
+	}
+
+	//handler could panic while channel is closing, so do not wait for it
+	go c.Close()
 }
 
 /**
@@ -88,54 +352,68 @@ On emit - look for processing function
 func (m *methods) processIncomingMessage(c *Channel, msg *protocol.Message) {
 	switch msg.Type {
 	case protocol.MessageTypeEmit:
-		f, ok := m.findMethod(msg.Method)
+		callers, ok := m.findMethod(msg.Method)
 		if !ok {
+			m.callAnyHandler(c, msg)
 			return
 		}
-
-		if !f.ArgsPresent {
-			f.callFunc(c, &struct{}{})
-			return
+		if st := c.stats(); st != nil {
+			st.event(msg.Method)
 		}
 
-		data := f.getArgs()
-		err := json.Unmarshal([]byte(msg.Args), &data)
-		if err != nil {
-			return
-		}
+		for _, f := range callers {
+			result, ok := m.callEventHandler(c, msg, f)
 
-		f.callFunc(c, data)
+			//nobody waits for result, so pass error to OnError handler
+			if err := f.getError(result); ok && err != nil {
+				m.callErrorEvent(c, msg.Method, err)
+			}
+		}
 
 	case protocol.MessageTypeAckRequest:
-		f, ok := m.findMethod(msg.Method)
-		if !ok || !f.Out {
+		callers, ok := m.findMethod(msg.Method)
+		if !ok {
+			m.callAnyHandler(c, msg)
 			return
 		}
+		if st := c.stats(); st != nil {
+			st.event(msg.Method)
+		}
 
-		var result []reflect.Value
-		if f.ArgsPresent {
-			//data type should be defined for unmarshall
-			data := f.getArgs()
-			err := json.Unmarshal([]byte(msg.Args), &data)
-			if err != nil {
-				return
-			}
+		answered := false
+		for _, f := range callers {
+			result, ok := m.callEventHandler(c, msg, f)
 
-			result = f.callFunc(c, data)
-		} else {
-			result = f.callFunc(c, &struct{}{})
-		}
+			//handler without result is called, but does not answer,
+			//neither do typed handlers, only the first result is sent
+			if !ok || !f.Out || answered {
+				continue
+			}
+			answered = true
 
-		ack := &protocol.Message{
-			Type:  protocol.MessageTypeAckResponse,
-			AckId: msg.AckId,
+			ack := &protocol.Message{
+				Type:         protocol.MessageTypeAckResponse,
+				AckId:        msg.AckId,
+				Namespace:    msg.Namespace,
+				ExplicitRoot: c.explicitRoot(),
+			}
+			if err := f.getError(result); err != nil {
+				send(ack, c, &AckError{Message: err.Error()})
+				continue
+			}
+			if data, ok := result[0].Interface().([]byte); ok && c.binarySupported() {
+				if err := sendBinaryAck(ack, c, data); err != nil {
+					m.callErrorEvent(c, msg.Method, err)
+				}
+				continue
+			}
+			send(ack, c, result[0].Interface())
 		}
-		send(ack, c, result[0].Interface())
 
 	case protocol.MessageTypeAckResponse:
 		waiter, err := c.ack.getWaiter(msg.AckId)
 		if err == nil {
-			waiter <- msg.Args
+			waiter <- msg
 		}
 	}
 }
@@ -0,0 +1,85 @@
+package gosocketio
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/graarh/golang-socketio/transport"
+)
+
+/**
+Start ordered server counting "count" events, "marker" event is reported
+to returned channel, so all events emitted before it are processed
+*/
+func startCountingServer(t *testing.T) (*Server, *Client, *int32, chan struct{}) {
+	s, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	s.SetHandlerMode(HandlerOrdered, 0)
+
+	var count int32
+	s.On("count", func(c *Channel) {
+		atomic.AddInt32(&count, 1)
+	})
+	markers := make(chan struct{}, 10)
+	s.On("marker", func(c *Channel) {
+		markers <- struct{}{}
+	})
+
+	return s, dialServer(t, url), &count, markers
+}
+
+/**
+Emit given amount of "count" events followed by marker, wait till processed
+*/
+func emitCounted(t *testing.T, c *Client, amount int, markers chan struct{}) {
+	for i := 0; i < amount; i++ {
+		if err := c.Emit("count", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Emit("marker", nil); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "marker", func() bool {
+		select {
+		case <-markers:
+			return true
+		default:
+			return false
+		}
+	})
+}
+
+func TestOffStopsHandler(t *testing.T) {
+	s, c, count, markers := startCountingServer(t)
+
+	emitCounted(t, c, 3, markers)
+	if n := atomic.LoadInt32(count); n != 3 {
+		t.Fatalf("expected 3 events before Off, got %d", n)
+	}
+
+	s.Off("count")
+	emitCounted(t, c, 3, markers)
+	if n := atomic.LoadInt32(count); n != 3 {
+		t.Fatalf("removed handler fired, %d events counted", n)
+	}
+}
+
+func TestRemoveAllListenersStopsHandlers(t *testing.T) {
+	s, c, count, markers := startCountingServer(t)
+
+	emitCounted(t, c, 2, markers)
+	s.RemoveAllListeners()
+
+	for i := 0; i < 3; i++ {
+		if err := c.Emit("count", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.On("marker", func(c *Channel) {
+		markers <- struct{}{}
+	})
+	emitCounted(t, c, 0, markers)
+	if n := atomic.LoadInt32(count); n != 2 {
+		t.Fatalf("removed handler fired, %d events counted", n)
+	}
+}
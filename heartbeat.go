@@ -0,0 +1,172 @@
+package gosocketio
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrorIdleTimeout = errors.New("Idle timeout")
+)
+
+/**
+Set function called with round trip time on every pong answer to ping,
+pings are sent by client, or by server to engine.io v4 clients
+*/
+func (c *Channel) OnPong(f func(latency time.Duration)) {
+	if c.root != nil {
+		c.root.OnPong(f)
+		return
+	}
+
+	c.heartbeatLock.Lock()
+	defer c.heartbeatLock.Unlock()
+	c.onPong = f
+}
+
+/**
+Close connection if nothing, including pongs, is received within
+given timeout, zero turns it off
+*/
+func (c *Channel) SetIdleTimeout(timeout time.Duration) {
+	if c.root != nil {
+		c.root.SetIdleTimeout(timeout)
+		return
+	}
+
+	c.heartbeatLock.Lock()
+	defer c.heartbeatLock.Unlock()
+
+	c.idleTimeout = timeout
+	c.lastActivity = time.Now()
+	switch {
+	case timeout <= 0 && c.idleTimer != nil:
+		c.idleTimer.Stop()
+	case timeout > 0 && c.idleTimer == nil:
+		c.idleTimer = time.AfterFunc(timeout, c.checkIdle)
+	case timeout > 0:
+		c.idleTimer.Reset(timeout)
+	}
+}
+
+/**
+Close idle connection, or wait till the end of timeout counted
+from last received frame, channel which is not connected yet
+has no handlers and is not closed
+*/
+func (c *Channel) checkIdle() {
+	m := c.methods
+	c.heartbeatLock.Lock()
+	if c.idleTimeout <= 0 || !c.IsAlive() || m == nil {
+		c.heartbeatLock.Unlock()
+		return
+	}
+
+	idle := time.Since(c.lastActivity)
+	if idle < c.idleTimeout {
+		c.idleTimer.Reset(c.idleTimeout - idle)
+		c.heartbeatLock.Unlock()
+		return
+	}
+	c.heartbeatLock.Unlock()
+
+	closeChannel(c, m, ErrorIdleTimeout)
+}
+
+/**
+Close engine.io v4 client connection if server pings stop, server sends
+them every ping interval of open packet and waits for pong within ping
+timeout, idle timeout set by user is kept
+*/
+func (c *Channel) watchServerPings(hdr Header) {
+	c.heartbeatLock.Lock()
+	idleTimeout := c.idleTimeout
+	c.heartbeatLock.Unlock()
+
+	if idleTimeout > 0 || hdr.PingInterval <= 0 {
+		return
+	}
+	c.SetIdleTimeout(time.Duration(hdr.PingInterval+hdr.PingTimeout) * time.Millisecond)
+}
+
+/**
+Stop idle timer of closed connection
+*/
+func (c *Channel) stopHeartbeat() {
+	c.heartbeatLock.Lock()
+	defer c.heartbeatLock.Unlock()
+
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+}
+
+/**
+Remember time of received frame, called by incoming loop
+*/
+func (c *Channel) receivedFrame() {
+	c.heartbeatLock.Lock()
+	c.lastActivity = time.Now()
+	c.heartbeatLock.Unlock()
+}
+
+/**
+Remember time of ping, called by pinger
+*/
+func (c *Channel) sentPing() {
+	//pong of previous ping is late, it does not answer this one
+	select {
+	case <-c.pong:
+	default:
+	}
+
+	c.heartbeatLock.Lock()
+	c.pingSent = time.Now()
+	c.heartbeatLock.Unlock()
+}
+
+/**
+Wait for pong of sent ping, false is returned if it is not received
+within timeout, zero timeout means pong is not waited for
+*/
+func (c *Channel) waitPong(timeout time.Duration) bool {
+	if timeout <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-c.pong:
+		return true
+	case <-c.ctx.Done():
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+/**
+Report round trip time of ping, called by incoming loop
+*/
+func (c *Channel) receivedPong() {
+	select {
+	case c.pong <- struct{}{}:
+	default:
+	}
+
+	c.heartbeatLock.Lock()
+	f, sent := c.onPong, c.pingSent
+	c.heartbeatLock.Unlock()
+
+	if sent.IsZero() {
+		return
+	}
+
+	latency := time.Since(sent)
+	c.metrics.pong(latency)
+	if f != nil {
+		f(latency)
+	}
+}
@@ -0,0 +1,138 @@
+package gosocketio
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graarh/golang-socketio/transport"
+)
+
+/**
+Start server, that pings engine.io v4 clients often
+*/
+func startPingingServer(t *testing.T) (*Server, string) {
+	tr := transport.GetDefaultWebsocketTransport()
+	tr.PingInterval = 20 * time.Millisecond
+	tr.PingTimeout = 50 * time.Millisecond
+	s, url := startServer(t, tr)
+
+	return s, strings.Replace(url, "EIO=3", "EIO=4", 1)
+}
+
+func TestServerPongTimeout(t *testing.T) {
+	s, url := startPingingServer(t)
+	reasons := make(chan DisconnectReason, 1)
+	s.OnDisconnect(func(c *Channel, reason DisconnectReason) {
+		reasons <- reason
+	})
+
+	socket := dialRaw(t, url)
+	readPacket(t, socket)
+	writeRaw(t, socket, "40")
+	readPacket(t, socket)
+	//pings are read, but not answered
+	go func() {
+		for {
+			if _, _, err := socket.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case reason := <-reasons:
+		if reason != DisconnectPingTimeout {
+			t.Fatalf("expected ping timeout, got %v", reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client without pongs is not disconnected")
+	}
+}
+
+func TestServerPongReceived(t *testing.T) {
+	s, url := startPingingServer(t)
+	disconnected := make(chan DisconnectReason, 1)
+	s.OnDisconnect(func(c *Channel, reason DisconnectReason) {
+		disconnected <- reason
+	})
+
+	socket := dialRaw(t, url)
+	readPacket(t, socket)
+	writeRaw(t, socket, "40")
+	readPacket(t, socket)
+
+	deadline := time.Now().Add(300 * time.Millisecond)
+	pings := 0
+	for time.Now().Before(deadline) {
+		if packet := readPacket(t, socket); packet == "2" {
+			pings++
+			writeRaw(t, socket, "3")
+		}
+	}
+	if pings < 3 {
+		t.Fatalf("only %d pings are received", pings)
+	}
+
+	select {
+	case reason := <-disconnected:
+		t.Fatalf("answering client is disconnected, %v", reason)
+	default:
+	}
+}
+
+func TestIdleCheckOfNotConnectedChannel(t *testing.T) {
+	c := &Channel{}
+	c.initChannel()
+	c.idleTimeout = time.Millisecond
+	c.lastActivity = time.Now().Add(-time.Second)
+
+	c.checkIdle()
+	if !c.IsAlive() {
+		t.Fatal("not connected channel is closed")
+	}
+}
+
+/**
+Dial raw engine.io v4 server with given ping params, client connect
+to root namespace is confirmed
+*/
+func dialPingedClient(t *testing.T, open string) (*Client, *websocket.Conn) {
+	url, sockets := startRawServer(t, open)
+	c, err := Dial(strings.Replace(url, "EIO=3", "EIO=4", 1), transport.GetDefaultWebsocketTransport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	socket := <-sockets
+	t.Cleanup(func() { socket.Close() })
+
+	if packet := readPacket(t, socket); packet != "40" {
+		t.Fatalf("expected root namespace connect, got %q", packet)
+	}
+	writeRaw(t, socket, `40{"sid":"test"}`)
+	waitFor(t, "open state", func() bool { return c.State() == StateOpen })
+
+	return c, socket
+}
+
+func TestClientAnswersServerPing(t *testing.T) {
+	_, socket := dialPingedClient(t, `0{"sid":"test","upgrades":[],"pingInterval":100,"pingTimeout":1000}`)
+
+	for i := 0; i < 3; i++ {
+		writeRaw(t, socket, "2")
+		if packet := readPacket(t, socket); packet != "3" {
+			t.Fatalf("expected pong, got %q", packet)
+		}
+	}
+}
+
+func TestClientServerPingTimeout(t *testing.T) {
+	c, _ := dialPingedClient(t, `0{"sid":"test","upgrades":[],"pingInterval":20,"pingTimeout":20}`)
+
+	waitFor(t, "ping timeout", func() bool { return !c.IsAlive() })
+	if reason := c.DisconnectReason(); reason != DisconnectPingTimeout {
+		t.Fatalf("expected ping timeout, got %v", reason)
+	}
+}
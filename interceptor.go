@@ -0,0 +1,123 @@
+package gosocketio
+
+import (
+	"errors"
+	"github.com/graarh/golang-socketio/protocol"
+)
+
+var (
+	ErrorDropped = errors.New("Dropped by interceptor")
+)
+
+/**
+Function called with event or ack packet, it returns the packet to be
+sent or processed, which could be the given one changed, nil drops the
+packet silently, error drops it and is passed to OnError handler
+as EventError with event of the packet
+*/
+type Interceptor func(msg *protocol.Message) (*protocol.Message, error)
+
+/**
+Set interceptor of outgoing event and ack packets, it is called right
+before encoding, after arguments are marshalled to msg.Args, so it runs
+in the goroutine of sender, error of it is also returned by emit. Ack
+with dropped request fails with ErrorDropped at once.
+Interceptor is set for the whole connection, including namespaces,
+nil removes it.
+*/
+func (c *Channel) SetOutboundInterceptor(f Interceptor) {
+	if c.root != nil {
+		c.root.SetOutboundInterceptor(f)
+		return
+	}
+
+	c.interceptorsLock.Lock()
+	defer c.interceptorsLock.Unlock()
+	c.outbound = f
+}
+
+/**
+Set interceptor of incoming event and ack packets, it is called by
+incoming loop after packet is decoded and its attachments are received,
+so it should not block. Interceptor is set for the whole connection,
+including namespaces, nil removes it.
+*/
+func (c *Channel) SetInboundInterceptor(f Interceptor) {
+	if c.root != nil {
+		c.root.SetInboundInterceptor(f)
+		return
+	}
+
+	c.interceptorsLock.Lock()
+	defer c.interceptorsLock.Unlock()
+	c.inbound = f
+}
+
+/**
+Check that packet type is passed to interceptors
+*/
+func intercepted(msg *protocol.Message) bool {
+	return msg.Type == protocol.MessageTypeEmit ||
+		msg.Type == protocol.MessageTypeAckRequest ||
+		msg.Type == protocol.MessageTypeAckResponse
+}
+
+/**
+Pass outgoing packet to interceptor, nil packet without error means
+that it is dropped
+*/
+func (c *Channel) interceptOutbound(msg *protocol.Message) (*protocol.Message, error) {
+	root := c
+	if c.root != nil {
+		root = c.root
+	}
+
+	root.interceptorsLock.RLock()
+	f := root.outbound
+	root.interceptorsLock.RUnlock()
+	if f == nil {
+		return msg, nil
+	}
+
+	//handlers of namespace channel are kept by its namespace
+	m := c.methods
+	if c.root != nil {
+		if n, ok := m.findNamespace(c.namespace); ok {
+			m = &n.methods
+		}
+	}
+	return c.intercept(f, m, msg)
+}
+
+/**
+Pass incoming packet to interceptor before handlers of given methods
+process it, nil means that it is dropped
+*/
+func (c *Channel) interceptInbound(m *methods, msg *protocol.Message) *protocol.Message {
+	root := c
+	if c.root != nil {
+		root = c.root
+	}
+
+	root.interceptorsLock.RLock()
+	f := root.inbound
+	root.interceptorsLock.RUnlock()
+
+	msg, _ = c.intercept(f, m, msg)
+	return msg
+}
+
+func (c *Channel) intercept(f Interceptor, m *methods, msg *protocol.Message) (*protocol.Message, error) {
+	if f == nil || !intercepted(msg) {
+		return msg, nil
+	}
+
+	event := msg.Method
+	result, err := f(msg)
+	if err != nil {
+		c.logger().Debug("packet dropped by interceptor", "sid", c.Id(), "event", event, "err", err)
+		go m.callErrorEvent(c, event, err)
+		return nil, err
+	}
+	return result, nil
+}
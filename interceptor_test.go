@@ -0,0 +1,31 @@
+package gosocketio
+
+import (
+	"testing"
+	"time"
+
+	"github.com/graarh/golang-socketio/protocol"
+	"github.com/graarh/golang-socketio/transport"
+)
+
+func TestAckDroppedByInterceptor(t *testing.T) {
+	_, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	c := dialServer(t, url)
+	c.SetOutboundInterceptor(func(msg *protocol.Message) (*protocol.Message, error) {
+		if msg.Method == "secret" {
+			return nil, nil
+		}
+		return msg, nil
+	})
+
+	start := time.Now()
+	if _, err := c.Ack("secret", nil, 5*time.Second); err != ErrorDropped {
+		t.Fatalf("expected ErrorDropped, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("dropped ack waited for %v", elapsed)
+	}
+	if err := c.Emit("secret", nil); err != nil {
+		t.Fatalf("dropped emit failed, %v", err)
+	}
+}
@@ -0,0 +1,332 @@
+package gosocketio
+
+import (
+	"errors"
+	"github.com/graarh/golang-socketio/protocol"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//seconds rejected client should wait before next connect
+	retryAfterSeconds = "1"
+
+	//event emitted to client when its event is dropped by rate limit
+	RateLimitedEvent = "rate_limited"
+
+	DefaultMaxEventNameLen = 256
+	DefaultMaxPayloadBytes = maxPayload
+	DefaultMaxDepth        = 64
+	DefaultMaxAttachments  = 100
+)
+
+var (
+	ErrorTooManyConnections = errors.New("Too many connections")
+	ErrorRateLimited        = errors.New("Rate limit exceeded")
+	ErrorEventNameTooLong   = errors.New("Event name too long")
+	ErrorPayloadTooLarge    = errors.New("Payload too large")
+	ErrorPayloadTooDeep     = errors.New("Payload nesting too deep")
+	ErrorTooManyAttachments = errors.New("Too many attachments")
+	ErrorAttachmentsPending = errors.New("Binary packet before attachments of previous one")
+	ErrorConnectTimeout     = errors.New("Connect timeout")
+)
+
+/**
+Limits of incoming packets, connection sending packet over any of them
+is closed with corresponding error, zero turns the limit off.
+Payload limit is applied to every frame, including binary attachments.
+*/
+type DecodeLimits struct {
+	MaxEventNameLen int
+	MaxPayloadBytes int
+	//max nesting of json arrays and objects in event arguments
+	MaxDepth int
+	//max number of binary attachments of one packet
+	MaxAttachments int
+}
+
+/**
+Returns decode limits with default values
+*/
+func GetDefaultDecodeLimits() DecodeLimits {
+	return DecodeLimits{
+		MaxEventNameLen: DefaultMaxEventNameLen,
+		MaxPayloadBytes: DefaultMaxPayloadBytes,
+		MaxDepth:        DefaultMaxDepth,
+		MaxAttachments:  DefaultMaxAttachments,
+	}
+}
+
+/**
+Set limits of incoming packets, applied to new connections
+*/
+func (s *Server) SetDecodeLimits(limits DecodeLimits) {
+	s.decodeLimitsLock.Lock()
+	defer s.decodeLimitsLock.Unlock()
+
+	s.decodeLimits = limits
+}
+
+/**
+Drop malformed packets instead of closing connection, applied to new
+connections, every drop is logged, passed to OnError handler as EventError
+with empty event and counted in Metrics.DecodeErrors, packets over
+decode limits still close connection
+*/
+func (s *Server) SetContinueOnDecodeError(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&s.continueOnDecodeError, value)
+}
+
+func (s *Server) getDecodeLimits() *DecodeLimits {
+	s.decodeLimitsLock.RLock()
+	defer s.decodeLimitsLock.RUnlock()
+
+	limits := s.decodeLimits
+	return &limits
+}
+
+/**
+Check size of incoming frame, called by incoming loop before decoding
+*/
+func (c *Channel) checkFrameSize(size int) error {
+	if c.decodeLimits == nil || c.decodeLimits.MaxPayloadBytes <= 0 {
+		return nil
+	}
+	if size > c.decodeLimits.MaxPayloadBytes {
+		return ErrorPayloadTooLarge
+	}
+	return nil
+}
+
+/**
+Check event name and arguments nesting of decoded packet,
+arguments are not unmarshalled yet
+*/
+func (c *Channel) checkPacket(msg *protocol.Message) error {
+	if c.decodeLimits == nil {
+		return nil
+	}
+	if max := c.decodeLimits.MaxEventNameLen; max > 0 && len(msg.Method) > max {
+		return ErrorEventNameTooLong
+	}
+	if max := c.decodeLimits.MaxDepth; max > 0 && depthExceeded(msg.Args, max) {
+		return ErrorPayloadTooDeep
+	}
+	return nil
+}
+
+/**
+Check number of attachments of binary packet, connection sending
+more of them than decode limit allows breaks the protocol
+*/
+func (c *Channel) checkAttachments(msg *protocol.Message) error {
+	if c.decodeLimits == nil {
+		return nil
+	}
+	if max := c.decodeLimits.MaxAttachments; max > 0 && msg.Attachments > max {
+		return ErrorTooManyAttachments
+	}
+	return nil
+}
+
+/**
+Check that json arrays and objects are not nested deeper than max
+*/
+func depthExceeded(data string, max int) bool {
+	depth := 0
+	inString, escaped := false, false
+	for i := 0; i < len(data); i++ {
+		ch := data[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case ch == '\\':
+				escaped = true
+			case ch == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch ch {
+		case '"':
+			inString = true
+		case '[', '{':
+			depth++
+			if depth > max {
+				return true
+			}
+		case ']', '}':
+			depth--
+		}
+	}
+	return false
+}
+
+/**
+Set max number of concurrent connections, new connections over it
+are refused with 503 before upgrade, zero means unlimited
+*/
+func (s *Server) SetMaxConnections(n int) {
+	atomic.StoreInt64(&s.maxConnections, int64(n))
+}
+
+/**
+Take connection slot, false if max connections number is reached
+*/
+func (s *Server) reserveConnection() bool {
+	max := atomic.LoadInt64(&s.maxConnections)
+	n := atomic.AddInt64(&s.connections, 1)
+	if max > 0 && n > max {
+		atomic.AddInt64(&s.connections, -1)
+		return false
+	}
+	return true
+}
+
+/**
+Free connection slot of closed or rejected connection
+*/
+func (s *Server) releaseConnection() {
+	atomic.AddInt64(&s.connections, -1)
+}
+
+/**
+Close connections that do not connect to any namespace within given
+timeout after transport is established, with ErrorConnectTimeout and
+DisconnectConnectTimeout reason. Only engine.io v4 clients send connect
+packet themselves, v3 ones are connected to root namespace by server.
+Zero turns it off, applied to new connections.
+*/
+func (s *Server) SetConnectTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&s.connectTimeout, int64(timeout))
+}
+
+/**
+Start connect timer of new connection, called before its loops start
+*/
+func (s *Server) startConnectTimer(c *Channel) {
+	timeout := time.Duration(atomic.LoadInt64(&s.connectTimeout))
+	if timeout <= 0 || c.engineIO != protocol.EngineIOv4 {
+		return
+	}
+
+	c.connectTimer = time.AfterFunc(timeout, func() {
+		select {
+		case <-c.connected:
+		default:
+			closeChannel(c, c.methods, ErrorConnectTimeout)
+		}
+	})
+}
+
+/**
+Mark server channel connected to a namespace, connect timer is stopped
+*/
+func (c *Channel) namespaceConnected() {
+	c.connectedOnce.Do(func() { close(c.connected) })
+	c.stopConnectTimer()
+}
+
+func (c *Channel) stopConnectTimer() {
+	if c.connectTimer != nil {
+		c.connectTimer.Stop()
+	}
+}
+
+/**
+Token bucket, allows burst of capacity events and refills
+with given rate, safe for concurrent use
+*/
+type tokenBucket struct {
+	capacity float64
+	//tokens per second
+	rate float64
+
+	tokens float64
+	last   time.Time
+	lock   sync.Mutex
+}
+
+func newTokenBucket(events int, per time.Duration) *tokenBucket {
+	return &tokenBucket{
+		capacity: float64(events),
+		rate:     float64(events) / per.Seconds(),
+		tokens:   float64(events),
+		last:     time.Now(),
+	}
+}
+
+/**
+Take one token, false if bucket is empty
+*/
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+/**
+Limit incoming events and acks of every connection to given number
+per duration, events over the limit are dropped and RateLimitedEvent
+is emitted to the client, connection is closed if the client keeps
+sending after as many events as the limit are dropped in a row.
+Zero events turns limit off, applied to new connections.
+*/
+func (s *Server) SetRateLimit(events int, per time.Duration) {
+	s.rateLimitLock.Lock()
+	defer s.rateLimitLock.Unlock()
+
+	s.rateLimitEvents = events
+	s.rateLimitPer = per
+}
+
+/**
+Get rate limiter for new connection, nil if limit is off
+*/
+func (s *Server) newRateLimiter() *tokenBucket {
+	s.rateLimitLock.RLock()
+	defer s.rateLimitLock.RUnlock()
+
+	if s.rateLimitEvents <= 0 || s.rateLimitPer <= 0 {
+		return nil
+	}
+	return newTokenBucket(s.rateLimitEvents, s.rateLimitPer)
+}
+
+/**
+Check incoming packet against rate limit, false if it should be dropped,
+called by incoming loop only
+*/
+func (c *Channel) checkRateLimit(m *methods) bool {
+	if c.limiter == nil || c.limiter.allow(time.Now()) {
+		c.rateLimitDrops = 0
+		return true
+	}
+
+	c.rateLimitDrops++
+	if c.rateLimitDrops == 1 {
+		c.Emit(RateLimitedEvent, nil)
+	}
+	if float64(c.rateLimitDrops) > c.limiter.capacity {
+		closeChannel(c, m, ErrorRateLimited)
+	}
+	return false
+}
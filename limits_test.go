@@ -0,0 +1,110 @@
+package gosocketio
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graarh/golang-socketio/transport"
+)
+
+func TestMaxConnections(t *testing.T) {
+	const max = 3
+	s, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	s.SetMaxConnections(max)
+
+	for i := 0; i < max; i++ {
+		readPacket(t, dialRaw(t, url))
+	}
+
+	socket, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		socket.Close()
+		t.Fatal("connection over the limit is upgraded")
+	}
+	if resp == nil {
+		t.Fatalf("no http response, %v", err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Fatal("refused connection has no Retry-After")
+	}
+	if n := s.AmountOfSids(); n != max {
+		t.Fatalf("expected %d connections, got %d", max, n)
+	}
+}
+
+/**
+Start server with given rate limit, that counts "count" events,
+and connect raw client to it
+*/
+func startRateLimitedServer(t *testing.T, events int, per time.Duration) (*websocket.Conn, *int32, chan struct{}) {
+	s, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	s.SetRateLimit(events, per)
+	var count int32
+	s.On("count", func(c *Channel) {
+		atomic.AddInt32(&count, 1)
+	})
+	closed := make(chan struct{})
+	s.On(OnDisconnection, func(c *Channel) {
+		close(closed)
+	})
+
+	socket := dialRaw(t, url)
+	readPacket(t, socket)
+	readPacket(t, socket)
+	return socket, &count, closed
+}
+
+func TestRateLimitBurst(t *testing.T) {
+	const bucket = 5
+	socket, count, closed := startRateLimitedServer(t, bucket, 200*time.Millisecond)
+
+	//burst over the bucket, excess events are dropped and client is told once
+	for i := 0; i < bucket+3; i++ {
+		writeRaw(t, socket, `42["count"]`)
+	}
+	if packet := readPacket(t, socket); packet != `42["`+RateLimitedEvent+`"]` {
+		t.Fatalf("expected rate limited event, got %s", packet)
+	}
+	waitFor(t, "events of the bucket", func() bool { return atomic.LoadInt32(count) == bucket })
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(count); n != bucket {
+		t.Fatalf("expected %d events to pass the burst, got %d", bucket, n)
+	}
+
+	//bucket is refilled after the interval
+	time.Sleep(250 * time.Millisecond)
+	for i := 0; i < bucket; i++ {
+		writeRaw(t, socket, `42["count"]`)
+	}
+	waitFor(t, "events after refill", func() bool { return atomic.LoadInt32(count) == 2*bucket })
+	select {
+	case <-closed:
+		t.Fatal("connection is closed by dropped burst")
+	default:
+	}
+}
+
+func TestRateLimitClosesFlooder(t *testing.T) {
+	const bucket = 3
+	socket, count, closed := startRateLimitedServer(t, bucket, time.Minute)
+
+	//client keeps sending after as many events as the bucket are dropped
+	for i := 0; i < 2*bucket+1; i++ {
+		writeRaw(t, socket, `42["count"]`)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("flooding connection is not closed")
+	}
+	//handlers of passed events could be skipped by closing
+	if n := atomic.LoadInt32(count); n > bucket {
+		t.Fatalf("expected at most %d events to pass, got %d", bucket, n)
+	}
+}
@@ -0,0 +1,71 @@
+package gosocketio
+
+/**
+Structured logger of library internal events, keyvals are pairs
+of field name and value, like "sid", c.Id()
+*/
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+/**
+Logger that drops everything, used by default
+*/
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, keyvals ...interface{}) {}
+func (nopLogger) Info(msg string, keyvals ...interface{})  {}
+func (nopLogger) Warn(msg string, keyvals ...interface{})  {}
+func (nopLogger) Error(msg string, keyvals ...interface{}) {}
+
+/**
+Set logger of internal events: upgrade failures, decode errors,
+disconnections and handler panics, nothing is logged by default
+*/
+func (s *Server) SetLogger(l Logger) {
+	s.setLogger(l)
+}
+
+/**
+Set logger of internal events, nothing is logged by default
+*/
+func (c *Client) SetLogger(l Logger) {
+	c.setLogger(l)
+}
+
+/**
+Set logger of internal events of every connection, nothing is logged by default
+*/
+func (rc *ReconnectingClient) SetLogger(l Logger) {
+	rc.setLogger(l)
+}
+
+func (m *methods) setLogger(l Logger) {
+	m.loggerLock.Lock()
+	defer m.loggerLock.Unlock()
+
+	m.logger = l
+}
+
+func (m *methods) getLogger() Logger {
+	m.loggerLock.RLock()
+	defer m.loggerLock.RUnlock()
+
+	if m.logger == nil {
+		return nopLogger{}
+	}
+	return m.logger
+}
+
+/**
+Get logger of connection handlers
+*/
+func (c *Channel) logger() Logger {
+	if c.methods == nil {
+		return nopLogger{}
+	}
+	return c.methods.getLogger()
+}
@@ -1,12 +1,13 @@
 package gosocketio
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"github.com/graarh/golang-socketio/protocol"
 	"github.com/graarh/golang-socketio/transport"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,6 +19,33 @@ var (
 	ErrorWrongHeader = errors.New("Wrong header")
 )
 
+/**
+State of socket.io connection, client one is connecting till server
+confirms connect to root namespace
+*/
+type ConnectionState int32
+
+const (
+	StateConnecting ConnectionState = iota
+	StateOpen
+	StateClosing
+	StateClosed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateOpen:
+		return "open"
+	case StateClosing:
+		return "closing"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
 /**
 engine.io header to send or receive
 */
@@ -26,6 +54,40 @@ type Header struct {
 	Upgrades     []string `json:"upgrades"`
 	PingInterval int      `json:"pingInterval"`
 	PingTimeout  int      `json:"pingTimeout"`
+	MaxPayload   int      `json:"maxPayload,omitempty"`
+
+	//binary codec accepted by server, see SetBinaryCodec
+	Payload string `json:"payload,omitempty"`
+
+	//token of session resume, see EnableSessionResume
+	ResumeToken string `json:"resumeToken,omitempty"`
+}
+
+/**
+Packet queued for sending, binary attachments are sent
+as separate frames right after the text one
+*/
+type outPacket struct {
+	text        string
+	attachments [][]byte
+
+	//text frame encoded to bytes, sent instead of text if set
+	data []byte
+
+	//compression override of text frame, see EmitCompressed
+	compress int
+
+	//close connection with given code after previous packets are sent,
+	//text is the close reason
+	closeCode int
+
+	//packets written one after another instead of this one, see EmitBatch,
+	//result of writing is reported to written
+	batch   []outPacket
+	written chan batchResult
+
+	//nothing is sent, closed when previous packets are written, see Flush
+	flushed chan struct{}
 }
 
 /**
@@ -40,105 +102,490 @@ ping is automatic
 type Channel struct {
 	conn transport.Connection
 
-	out    chan string
+	out    chan outPacket
 	header Header
 
-	alive     bool
-	aliveLock sync.Mutex
+	//client gets header from open packet while id could be read
+	headerLock sync.RWMutex
+
+	//engine.io protocol version, server pings v4 clients itself
+	engineIO int
+
+	//capacity of outgoing queue and policy of its overflow
+	queueSize      int
+	overflowPolicy int32
+
+	//flush markers taken from the queue by OverflowDropOldest, packets
+	//before them are taken by outgoing loop, which signals them once written
+	takenFlushes     []chan struct{}
+	takenFlushesLock sync.Mutex
+
+	//sequence numbers of sent and received events, see SetSequencing
+	sequencing int32
+	seq        uint64
+	lastSeq    uint64
+	seqLock    sync.Mutex
+
+	//sent sequenced packets kept for replay, and missed packets
+	//of resumed connection waiting for sending
+	replay        *replayBuffer
+	pendingReplay []outPacket
 
-	ack ackProcessor
+	//decides if failed write is retried, see OnWriteError
+	onWriteError     func(err error) bool
+	onWriteErrorLock sync.RWMutex
+
+	//values set by user, see Set and Get
+	data     map[string]interface{}
+	dataLock sync.RWMutex
+
+	//counters of connection, see Metrics
+	metrics *channelMetrics
+
+	//liveness of connection, see OnPong and SetIdleTimeout
+	onPong        func(latency time.Duration)
+	pingSent      time.Time
+	pong          chan struct{}
+	idleTimeout   time.Duration
+	lastActivity  time.Time
+	idleTimer     *time.Timer
+	heartbeatLock sync.Mutex
+
+	//context of connection, cancelled when it is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	//closed when client receives connect packet of root namespace,
+	//or when server connects client to any namespace
+	connected     chan struct{}
+	connectedOnce sync.Once
+	//closes server connection not connected in time, see SetConnectTimeout
+	connectTimer *time.Timer
+	//refusal of root namespace connect, set by incoming loop before closing
+	connectErr error
+
+	//incoming events limit, drops are counted by incoming loop only
+	limiter        *tokenBucket
+	rateLimitDrops int
+
+	//limits of incoming packets, nil for client
+	decodeLimits *DecodeLimits
+
+	//malformed packets are dropped instead of closing connection
+	continueOnDecodeError bool
+
+	//set atomically, 1 decodes json numbers of arguments as json.Number
+	useNumber int32
+
+	//RootNamespaceForm of sent packets, changed atomically
+	rootForm int32
+
+	//interceptors of event and ack packets, see SetOutboundInterceptor
+	outbound         Interceptor
+	inbound          Interceptor
+	interceptorsLock sync.RWMutex
+
+	//scheduling of event handlers, see SetHandlerMode
+	handlerMode  HandlerMode
+	handlerSlots chan struct{}
+	handlerQueue chan func()
+	handlerLock  sync.Mutex
+
+	//ConnectionState, changed atomically, only one caller moves it
+	//to closing, so the channel is closed once
+	state int32
+
+	//DisconnectReason, set on closing, closeSent is set when close frame
+	//is sent, so peer close frame is only an answer to it
+	disconnectReason int32
+	closeSent        int32
+
+	ack *ackProcessor
+
+	//handlers of the connection, used to close it
+	methods *methods
+
+	server *Server
+	//connection continues previous session, see EnableSessionResume
+	resumed bool
+	//session is kept for resume, so pending acks are not cancelled
+	sessionKept bool
 
-	server        *Server
 	ip            string
 	requestHeader http.Header
+	request       *http.Request
+	auth          interface{}
+
+	//namespace of channel, root channel has empty one and keeps
+	//channels of other namespaces connected over the same connection
+	namespace      string
+	root           *Channel
+	namespaces     map[string]*Channel
+	namespacesLock sync.RWMutex
+
+	//codec of arguments sent as binary attachments, requested one
+	//is offered by client and used if server accepts it
+	binaryCodec     protocol.BinaryCodec
+	requestedCodec  protocol.BinaryCodec
+	binaryCodecLock sync.RWMutex
 }
 
 /**
 create channel, map, and set active
 */
 func (c *Channel) initChannel() {
-	//TODO: queueBufferSize from constant to server or client variable
-	c.out = make(chan string, queueBufferSize)
-	c.ack.resultWaiters = make(map[int](chan string))
-	c.alive = true
+	if c.queueSize <= 0 {
+		c.queueSize = queueBufferSize
+	}
+	c.out = make(chan outPacket, c.queueSize)
+	c.ack = newAckProcessor()
+	c.namespaces = make(map[string]*Channel)
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.connected = make(chan struct{})
+	c.pong = make(chan struct{}, 1)
+	c.metrics = newChannelMetrics()
+	c.setState(StateConnecting)
+}
+
+/**
+Release state made by initChannel when connect fails, loops are not
+started yet, so there is nothing else to stop
+*/
+func (c *Channel) releaseChannel() {
+	c.cancel()
+	c.ack.close()
+	c.out = nil
+
+	//ordered worker stops with cancelled context
+	c.handlerLock.Lock()
+	c.handlerMode, c.handlerSlots, c.handlerQueue = HandlerConcurrent, nil, nil
+	c.handlerLock.Unlock()
 }
 
 /**
-Get id of current socket connection
+Get context of connection, it is cancelled when connection is closed
+*/
+func (c *Channel) Context() context.Context {
+	if c.root != nil {
+		return c.root.Context()
+	}
+	return c.ctx
+}
+
+/**
+Get id of current socket connection, engine.io sid generated by server
+in open packet, it is the same for the whole connection, and new
+one is given for every connection, including reconnects
 */
 func (c *Channel) Id() string {
+	if c.root != nil {
+		return c.root.Id()
+	}
+
+	c.headerLock.RLock()
+	defer c.headerLock.RUnlock()
 	return c.header.Sid
 }
 
 /**
-Checks that Channel is still alive
+Set header received in open packet, only first one is accepted,
+so sid is not changed during the connection
+*/
+func (c *Channel) setHeader(hdr Header) {
+	c.headerLock.Lock()
+	defer c.headerLock.Unlock()
+
+	if c.header.Sid == "" {
+		c.header = hdr
+	}
+}
+
+/**
+Get engine.io name of transport in use, like "websocket" or "polling",
+empty if transport does not tell it
+*/
+func (c *Channel) Transport() string {
+	if nc, ok := c.conn.(transport.NamedConnection); ok {
+		return nc.TransportName()
+	}
+	return ""
+}
+
+/**
+Get state of current socket connection
+*/
+func (c *Channel) State() ConnectionState {
+	if c.root != nil {
+		return c.root.State()
+	}
+	return ConnectionState(atomic.LoadInt32(&c.state))
+}
+
+func (c *Channel) setState(state ConnectionState) {
+	atomic.StoreInt32(&c.state, int32(state))
+}
+
+/**
+Checks that Channel is still alive, it is false as soon as
+connection is read or written with error, or is closed
 */
 func (c *Channel) IsAlive() bool {
-	c.aliveLock.Lock()
-	defer c.aliveLock.Unlock()
+	state := c.State()
+	return state == StateConnecting || state == StateOpen
+}
 
-	return c.alive
+/**
+Move alive channel to closing state, false if it is already closing or closed
+*/
+func (c *Channel) beginClosing() bool {
+	for {
+		state := atomic.LoadInt32(&c.state)
+		if state != int32(StateConnecting) && state != int32(StateOpen) {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&c.state, state, int32(StateClosing)) {
+			return true
+		}
+	}
 }
 
 /**
-Close channel
+Close channel, it is safe to call concurrently and repeatedly, including
+from handlers of disconnection, only the first call closes the channel,
+others return at once without waiting for it
 */
 func closeChannel(c *Channel, m *methods, args ...interface{}) error {
-	c.aliveLock.Lock()
-	defer c.aliveLock.Unlock()
+	//namespace channels share connection of the root one
+	if c.root != nil {
+		c = c.root
+	}
 
-	if !c.alive {
+	if !c.beginClosing() {
 		//already closed
 		return nil
 	}
 
-	c.conn.Close()
-	c.alive = false
+	atomic.StoreInt32(&c.disconnectReason, int32(c.getDisconnectReason(args)))
+	gc, graceful := c.conn.(transport.GracefulConnection)
+	if len(args) == 0 && graceful && atomic.CompareAndSwapInt32(&c.closeSent, 0, 1) {
+		//let peer know that connection is closed on purpose
+		go gc.CloseWithCode(transport.CloseNormal, "")
+	} else {
+		c.conn.Close()
+	}
+	c.cancel()
+	c.stopHeartbeat()
+	c.stopConnectTimer()
+
+	//clean outloop, blocked senders could refill the queue meanwhile
+	for queued := false; !queued; {
+		for len(c.out) > 0 {
+			<-c.out
+		}
+		select {
+		case c.out <- outPacket{text: protocol.CloseMessage}:
+			queued = true
+		default:
+		}
+	}
 
-	//clean outloop
-	for len(c.out) > 0 {
-		<-c.out
+	reason := c.DisconnectReason()
+	if reason == DisconnectPingTimeout {
+		c.logger().Info("ping timeout", "sid", c.Id())
 	}
-	c.out <- protocol.CloseMessage
+	c.logger().Debug("disconnected", "sid", c.Id(), "reason", reason, "err", closeError(args))
 
 	m.callLoopEvent(c, OnDisconnection)
+	closeNamespaces(c, m)
+	if !c.sessionKept {
+		c.ack.close()
+	}
+	c.clearData()
+	if c.server != nil {
+		c.server.releaseConnection()
+	}
 
 	overfloodedLock.Lock()
 	delete(overflooded, c)
 	overfloodedLock.Unlock()
 
+	c.setState(StateClosed)
+
 	return nil
 }
 
+/**
+Receive next frame, binary ones only if connection supports them
+*/
+func getFrame(conn transport.Connection) (text string, data []byte, binary bool, err error) {
+	bc, ok := conn.(transport.BinaryConnection)
+	if !ok {
+		text, err = conn.GetMessage()
+		return text, nil, false, err
+	}
+
+	data, frameType, err := bc.GetFrame()
+	if err != nil {
+		return "", nil, false, err
+	}
+	if frameType == transport.FrameBinary {
+		return "", data, true, nil
+	}
+	return string(data), nil, false, nil
+}
+
 //incoming messages loop, puts incoming messages to In channel
 func inLoop(c *Channel, m *methods) error {
+	//binary packet waiting for its attachments
+	var pending *protocol.Message
+
 	for {
-		pkg, err := c.conn.GetMessage()
+		pkg, data, binary, err := getFrame(c.conn)
 		if err != nil {
 			return closeChannel(c, m, err)
 		}
+		if st := c.stats(); st != nil {
+			st.received(len(pkg) + len(data))
+		}
+		c.metrics.received(len(pkg) + len(data))
+		c.receivedFrame()
+		if err := c.checkFrameSize(len(pkg) + len(data)); err != nil {
+			return closeChannel(c, m, err)
+		}
+		if binary {
+			//attachment without packet is dropped
+			if pending == nil {
+				continue
+			}
+			pending.Buffers = append(pending.Buffers, data)
+			if len(pending.Buffers) == pending.Attachments {
+				dispatchMessage(c, m, pending)
+				pending = nil
+			}
+			continue
+		}
+
 		msg, err := protocol.Decode(pkg)
 		if err != nil {
+			c.logger().Warn("decode failed", "sid", c.Id(), "err", err)
+			if c.continueOnDecodeError {
+				c.metrics.decodeError()
+				go m.callErrorEvent(c, "", err)
+				continue
+			}
 			closeChannel(c, m, protocol.ErrorWrongPacket)
 			return err
 		}
 
 		switch msg.Type {
 		case protocol.MessageTypeOpen:
-			if err := json.Unmarshal([]byte(msg.Source[1:]), &c.header); err != nil {
+			var hdr Header
+			if err := protocol.Unmarshal([]byte(msg.Source[1:]), &hdr); err != nil {
 				closeChannel(c, m, ErrorWrongHeader)
 			}
+			c.setHeader(hdr)
+			c.negotiateBinaryCodec()
+			if c.server == nil && c.engineIO == protocol.EngineIOv4 {
+				//v4 server pings, and waits for connect to root namespace
+				c.watchServerPings(hdr)
+				c.out <- outPacket{text: protocol.MustEncode(&protocol.Message{
+					Type:         protocol.MessageTypeEmpty,
+					ExplicitRoot: c.explicitRoot(),
+				})}
+			}
 			m.callLoopEvent(c, OnConnection)
 		case protocol.MessageTypePing:
-			c.out <- protocol.PongMessage
+			//probe of upgrade is answered with the same data
+			c.out <- outPacket{text: protocol.PongMessage + msg.Args}
 		case protocol.MessageTypePong:
+			c.receivedPong()
+		case protocol.MessageTypeClose:
+			return closeChannel(c, m, transport.ErrorConnectionClosed)
+		case protocol.MessageTypeNoop, protocol.MessageTypeUpgrade:
+			//connection is already on its final transport, nothing to do
+			continue
 		default:
-			go m.processIncomingMessage(c, msg)
+			if err := c.checkPacket(msg); err != nil {
+				return closeChannel(c, m, err)
+			}
+			if !c.checkRateLimit(m) {
+				continue
+			}
+			if msg.Attachments > 0 {
+				//attachments of packets can't interleave
+				if pending != nil {
+					return abortChannel(c, m, ErrorAttachmentsPending)
+				}
+				if err := c.checkAttachments(msg); err != nil {
+					return abortChannel(c, m, err)
+				}
+				pending = msg
+				continue
+			}
+			dispatchMessage(c, m, msg)
 		}
 	}
-	return nil
 }
 
+/**
+Close connection of peer breaking the protocol, with protocol error
+close frame if connection supports it
+*/
+func abortChannel(c *Channel, m *methods, err error) error {
+	if ac, ok := c.conn.(transport.AbortConnection); ok {
+		ac.CloseAfterWrite(transport.CloseProtocolError, err.Error())
+	}
+	return closeChannel(c, m, err)
+}
+
+/**
+Route socket.io packet to handlers of its namespace
+*/
+func dispatchMessage(c *Channel, m *methods, msg *protocol.Message) {
+	c.unwrapSequence(msg)
+	c.detectRootNamespace(msg)
+
+	if msg.Type == protocol.MessageTypeEmpty && c.server != nil &&
+		(msg.Namespace == "" || msg.Namespace == protocol.RootNamespace) {
+		//v4 clients connect to root namespace explicitly
+		if c.engineIO == protocol.EngineIOv4 {
+			c.out <- outPacket{text: connectPacket(c, "")}
+			c.flushReplay()
+		}
+		c.namespaceConnected()
+		return
+	}
+	if msg.Type == protocol.MessageTypeEmpty && c.server == nil &&
+		(msg.Namespace == "" || msg.Namespace == protocol.RootNamespace) {
+		atomic.CompareAndSwapInt32(&c.state, int32(StateConnecting), int32(StateOpen))
+		c.connectedOnce.Do(func() { close(c.connected) })
+		return
+	}
+	if msg.Type == protocol.MessageTypeConnectError && c.server == nil &&
+		(msg.Namespace == "" || msg.Namespace == protocol.RootNamespace) {
+		rejectedByServer(c, m, msg)
+		return
+	}
+	if msg.Type == protocol.MessageTypeDisconnect &&
+		(msg.Namespace == "" || msg.Namespace == protocol.RootNamespace) {
+		//peer leaves root namespace, like socket.disconnect() of socket.io client
+		closeChannel(c, m, transport.ErrorConnectionClosed)
+		return
+	}
+
+	if msg.Namespace != "" && msg.Namespace != protocol.RootNamespace {
+		processNamespaceMessage(c, m, msg)
+		return
+	}
+	if msg = c.interceptInbound(m, msg); msg == nil {
+		return
+	}
+	c.dispatchHandler(msg, func() { m.processIncomingMessage(c, msg) })
+}
+
+//limit of packets sent with one coalesced write
+const maxCoalescedPackets = 256
+
 var overflooded map[*Channel]struct{} = make(map[*Channel]struct{})
 var overfloodedLock sync.Mutex
 
@@ -153,11 +600,13 @@ func AmountOfOverflooded() int64 {
 outgoing messages loop, sends messages from channel to socket
 */
 func outLoop(c *Channel, m *methods) error {
+	//packet taken from the queue while coalescing, it goes next
+	var next *outPacket
 	for {
 		outBufferLen := len(c.out)
-		if outBufferLen >= queueBufferSize-1 {
+		if outBufferLen >= cap(c.out)-1 && c.getOverflowPolicy() == OverflowClose {
 			return closeChannel(c, m, ErrorSocketOverflood)
-		} else if outBufferLen > int(queueBufferSize/2) {
+		} else if outBufferLen > cap(c.out)/2 {
 			overfloodedLock.Lock()
 			overflooded[c] = struct{}{}
 			overfloodedLock.Unlock()
@@ -167,30 +616,203 @@ func outLoop(c *Channel, m *methods) error {
 			overfloodedLock.Unlock()
 		}
 
-		msg := <-c.out
-		if msg == protocol.CloseMessage {
+		var msg outPacket
+		if next != nil {
+			msg, next = *next, nil
+		} else {
+			//every packet taken so far is written
+			c.signalTakenFlushes()
+			msg = <-c.out
+		}
+		if msg.text == protocol.CloseMessage {
 			return nil
 		}
+		if msg.closeCode != 0 {
+			if gc, ok := c.conn.(transport.GracefulConnection); ok &&
+				atomic.CompareAndSwapInt32(&c.closeSent, 0, 1) {
+				gc.CloseWithCode(msg.closeCode, msg.text)
+			}
+			return closeChannel(c, m)
+		}
 
-		err := c.conn.WriteMessage(msg)
-		if err != nil {
+		if msg.flushed != nil {
+			close(msg.flushed)
+			continue
+		}
+		if msg.batch != nil {
+			if err := writeBatch(c, msg); err != nil {
+				return closeChannel(c, m, err)
+			}
+			continue
+		}
+		if cc, interval := coalescingConnection(c.conn); cc != nil && plainPacket(msg) {
+			var err error
+			if next, err = writeCoalesced(c, cc, msg, interval); err != nil {
+				return closeChannel(c, m, err)
+			}
+			continue
+		}
+		if err := writeOutPacket(c, msg); err != nil {
 			return closeChannel(c, m, err)
 		}
 	}
+}
+
+/**
+Get connection, if it coalesces writes, with its coalescing interval
+*/
+func coalescingConnection(conn transport.Connection) (transport.CoalescingConnection, time.Duration) {
+	cc, ok := conn.(transport.CoalescingConnection)
+	if !ok {
+		return nil, 0
+	}
+	interval := cc.CoalescingInterval()
+	if interval <= 0 {
+		return nil, 0
+	}
+	return cc, interval
+}
+
+/**
+Check that packet is just a text frame, without attachments,
+compression override or control meaning, so it could be coalesced
+*/
+func plainPacket(msg outPacket) bool {
+	return msg.text != protocol.CloseMessage && msg.closeCode == 0 &&
+		msg.batch == nil && msg.flushed == nil &&
+		len(msg.attachments) == 0 && msg.compress == compressDefault
+}
+
+/**
+Gather plain packets queued within coalescing interval after the first one,
+up to maxCoalescedPackets, and write them with one socket write. Packet of
+other kind stops gathering and is returned to be processed next. Called by
+outgoing loop.
+*/
+func writeCoalesced(c *Channel, cc transport.CoalescingConnection, first outPacket,
+	interval time.Duration) (next *outPacket, err error) {
+
+	packets := []outPacket{first}
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+gather:
+	for len(packets) < maxCoalescedPackets {
+		select {
+		case msg := <-c.out:
+			if !plainPacket(msg) {
+				next = &msg
+				break gather
+			}
+			packets = append(packets, msg)
+		case <-timer.C:
+			break gather
+		}
+	}
+
+	return next, writePackets(c, cc, packets)
+}
+
+/**
+Write plain packets with one socket write, it is not retried, as the
+write could fail after some of the packets are sent
+*/
+func writePackets(c *Channel, cc transport.CoalescingConnection, packets []outPacket) error {
+	messages := make([][]byte, len(packets))
+	for i, p := range packets {
+		messages[i] = p.data
+		if p.data == nil {
+			messages[i] = []byte(p.text)
+		}
+	}
+	if err := cc.WriteMessages(messages); err != nil {
+		return err
+	}
+
+	st := c.stats()
+	for _, message := range messages {
+		if st != nil {
+			st.sent(len(message))
+		}
+		c.metrics.sent(len(message))
+	}
 	return nil
 }
 
 /**
-Pinger sends ping messages for keeping connection alive
+Write packet with its attachments, called by outgoing loop
+*/
+func writeOutPacket(c *Channel, msg outPacket) error {
+	err := c.retryWrite(func() error {
+		return writePacket(c.conn, msg)
+	})
+	if err != nil {
+		return err
+	}
+	st := c.stats()
+	if st != nil {
+		st.sent(len(msg.text) + len(msg.data))
+	}
+	c.metrics.sent(len(msg.text) + len(msg.data))
+
+	for _, attachment := range msg.attachments {
+		bc, ok := c.conn.(transport.BinaryConnection)
+		if !ok {
+			return ErrorBinaryNotSupported
+		}
+		err := c.retryWrite(func() error {
+			return bc.WriteBinary(attachment)
+		})
+		if err != nil {
+			return err
+		}
+		if st != nil {
+			st.sent(len(attachment))
+		}
+		c.metrics.sent(len(attachment))
+	}
+	return nil
+}
+
+/**
+Write text frame of packet, with its compression override if
+connection supports it
+*/
+func writePacket(conn transport.Connection, msg outPacket) error {
+	if msg.data != nil {
+		if bc, ok := conn.(transport.BytesConnection); ok && msg.compress == compressDefault {
+			return bc.WriteBytes(msg.data)
+		}
+		msg.text = string(msg.data)
+	}
+
+	if cc, ok := conn.(transport.CompressionConnection); ok && msg.compress != compressDefault {
+		return cc.WriteMessageCompressed(msg.text, msg.compress == compressOn)
+	}
+	return conn.WriteMessage(msg.text)
+}
+
+/**
+Pinger sends ping messages for keeping connection alive, it is run
+by engine.io v3 clients and by server for v4 ones, connection is closed
+with DisconnectPingTimeout if pong is not received within ping timeout
 */
 func pinger(c *Channel) {
 	for {
-		interval, _ := c.conn.PingParams()
+		interval, timeout := c.conn.PingParams()
+		if jc, ok := c.conn.(transport.JitterConnection); ok {
+			interval = transport.JitterInterval(interval, timeout, jc.PingJitter())
+		}
 		time.Sleep(interval)
 		if !c.IsAlive() {
 			return
 		}
 
-		c.out <- protocol.PingMessage
+		c.sentPing()
+		c.out <- outPacket{text: protocol.PingMessage}
+		if !c.waitPong(timeout) {
+			closeChannel(c, c.methods, DisconnectPingTimeout)
+			return
+		}
 	}
 }
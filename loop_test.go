@@ -0,0 +1,198 @@
+package gosocketio
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graarh/golang-socketio/transport"
+)
+
+func TestOutLoopCoalescing(t *testing.T) {
+	const events = 100
+
+	tr := transport.GetDefaultWebsocketTransport()
+	tr.WriteCoalescing = 20 * time.Millisecond
+	s, url := startServer(t, tr)
+	s.On("start", func(c *Channel) {
+		for i := 0; i < events; i++ {
+			c.Emit("number", i)
+		}
+	})
+
+	var lock sync.Mutex
+	var received []int
+	c := dialServer(t, url)
+	c.SetHandlerMode(HandlerOrdered, 0)
+	c.On("number", func(c *Channel, n int) {
+		lock.Lock()
+		defer lock.Unlock()
+		received = append(received, n)
+	})
+
+	if err := c.Emit("start", nil); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "coalesced events", func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(received) == events
+	})
+	for i, n := range received {
+		if n != i {
+			t.Fatalf("event %d received as %d", i, n)
+		}
+	}
+}
+
+/**
+Read raw socket till it is closed, returns code of server close frame
+*/
+func readCloseCode(t *testing.T, socket *websocket.Conn) int {
+	socket.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		_, _, err := socket.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if ce, ok := err.(*websocket.CloseError); ok {
+			return ce.Code
+		}
+		t.Fatalf("connection closed without close frame, %v", err)
+	}
+}
+
+func TestBinaryHeaderWhileAttachmentsPending(t *testing.T) {
+	_, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	socket := dialRaw(t, url)
+	readPacket(t, socket)
+	readPacket(t, socket)
+
+	writeRaw(t, socket, `451-["first",{"_placeholder":true,"num":0}]`)
+	writeRaw(t, socket, `451-["second",{"_placeholder":true,"num":0}]`)
+	if code := readCloseCode(t, socket); code != transport.CloseProtocolError {
+		t.Fatalf("expected protocol error close, got %d", code)
+	}
+}
+
+func TestTooManyAttachments(t *testing.T) {
+	_, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	socket := dialRaw(t, url)
+	readPacket(t, socket)
+	readPacket(t, socket)
+
+	writeRaw(t, socket, fmt.Sprintf(`45%d-["many"]`, DefaultMaxAttachments+1))
+	if code := readCloseCode(t, socket); code != transport.CloseProtocolError {
+		t.Fatalf("expected protocol error close, got %d", code)
+	}
+}
+
+/**
+Start server, that collects numbers sent by "number" event, and decode
+errors of its connections
+*/
+func startDecodeErrorServer(t *testing.T, continueOnError bool) (chan int, chan error, *websocket.Conn) {
+	s, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	s.SetContinueOnDecodeError(continueOnError)
+	s.SetHandlerMode(HandlerOrdered, 0)
+	numbers := make(chan int, 10)
+	errs := make(chan error, 10)
+	s.On("number", func(c *Channel, n int) {
+		numbers <- n
+	})
+	s.On(OnError, func(c *Channel, e EventError) {
+		errs <- e.Err
+	})
+
+	socket := dialRaw(t, url)
+	readPacket(t, socket)
+	readPacket(t, socket)
+	writeRaw(t, socket, `42["number",1]`)
+	writeRaw(t, socket, `4x`)
+	writeRaw(t, socket, `42["number",2]`)
+	return numbers, errs, socket
+}
+
+func TestContinueOnDecodeError(t *testing.T) {
+	numbers, errs, socket := startDecodeErrorServer(t, true)
+
+	for i := 1; i <= 2; i++ {
+		select {
+		case n := <-numbers:
+			if n != i {
+				t.Fatalf("expected %d, got %d", i, n)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d is not received", i)
+		}
+	}
+	select {
+	case <-errs:
+	case <-time.After(time.Second):
+		t.Fatal("decode error is not reported")
+	}
+
+	//connection is still alive
+	writeRaw(t, socket, "2")
+	if packet := readPacket(t, socket); packet != "3" {
+		t.Fatalf("expected pong, got %s", packet)
+	}
+}
+
+func TestCloseOnDecodeError(t *testing.T) {
+	numbers, _, socket := startDecodeErrorServer(t, false)
+
+	select {
+	case <-numbers:
+	case <-time.After(time.Second):
+		t.Fatal("event before bad packet is not received")
+	}
+	socket.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, _, err := socket.ReadMessage(); err != nil {
+			break
+		}
+	}
+	select {
+	case n := <-numbers:
+		t.Fatalf("event %d after bad packet is received", n)
+	default:
+	}
+}
+
+func TestEngineIOControlPackets(t *testing.T) {
+	s, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	reasons := make(chan DisconnectReason, 1)
+	s.OnDisconnect(func(c *Channel, reason DisconnectReason) {
+		reasons <- reason
+	})
+
+	socket := dialRaw(t, url)
+	readPacket(t, socket)
+	readPacket(t, socket)
+
+	//noop and upgrade are ignored, connection stays open
+	writeRaw(t, socket, "6")
+	writeRaw(t, socket, "5")
+	writeRaw(t, socket, "2")
+	if packet := readPacket(t, socket); packet != "3" {
+		t.Fatalf("expected pong, got %s", packet)
+	}
+	select {
+	case reason := <-reasons:
+		t.Fatalf("connection is closed by control packet, %v", reason)
+	default:
+	}
+
+	writeRaw(t, socket, "1")
+	select {
+	case reason := <-reasons:
+		if reason != DisconnectClientClose {
+			t.Fatalf("expected client close, got %v", reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("close packet does not close connection")
+	}
+}
@@ -0,0 +1,93 @@
+package gosocketio
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+/**
+Snapshot of connection diagnostics
+*/
+type Metrics struct {
+	//round trip time of last ping, zero if no pong received yet
+	LastPong time.Duration
+
+	MessagesReceived int64
+	MessagesSent     int64
+	BytesReceived    int64
+	BytesSent        int64
+
+	//malformed packets dropped, see Server.SetContinueOnDecodeError
+	DecodeErrors int64
+
+	Uptime time.Duration
+}
+
+func (m Metrics) String() string {
+	return fmt.Sprintf("rtt=%s received=%d/%dB sent=%d/%dB decode_errors=%d uptime=%s",
+		m.LastPong, m.MessagesReceived, m.BytesReceived,
+		m.MessagesSent, m.BytesSent, m.DecodeErrors, m.Uptime.Truncate(time.Second))
+}
+
+/**
+Connection counters, updated atomically by connection loops,
+allocated separately to keep 64-bit alignment
+*/
+type channelMetrics struct {
+	messagesReceived int64
+	messagesSent     int64
+	bytesReceived    int64
+	bytesSent        int64
+	decodeErrors     int64
+
+	//nanoseconds
+	lastPong int64
+
+	started time.Time
+}
+
+func newChannelMetrics() *channelMetrics {
+	return &channelMetrics{started: time.Now()}
+}
+
+func (cm *channelMetrics) received(size int) {
+	atomic.AddInt64(&cm.messagesReceived, 1)
+	atomic.AddInt64(&cm.bytesReceived, int64(size))
+}
+
+func (cm *channelMetrics) sent(size int) {
+	atomic.AddInt64(&cm.messagesSent, 1)
+	atomic.AddInt64(&cm.bytesSent, int64(size))
+}
+
+func (cm *channelMetrics) decodeError() {
+	atomic.AddInt64(&cm.decodeErrors, 1)
+}
+
+func (cm *channelMetrics) pong(latency time.Duration) {
+	atomic.StoreInt64(&cm.lastPong, int64(latency))
+}
+
+/**
+Get snapshot of connection diagnostics, it never blocks connection loops
+*/
+func (c *Channel) Metrics() Metrics {
+	if c.root != nil {
+		return c.root.Metrics()
+	}
+
+	cm := c.metrics
+	if cm == nil {
+		return Metrics{}
+	}
+	return Metrics{
+		LastPong:         time.Duration(atomic.LoadInt64(&cm.lastPong)),
+		MessagesReceived: atomic.LoadInt64(&cm.messagesReceived),
+		MessagesSent:     atomic.LoadInt64(&cm.messagesSent),
+		BytesReceived:    atomic.LoadInt64(&cm.bytesReceived),
+		BytesSent:        atomic.LoadInt64(&cm.bytesSent),
+		DecodeErrors:     atomic.LoadInt64(&cm.decodeErrors),
+		Uptime:           time.Since(cm.started),
+	}
+}
@@ -0,0 +1,126 @@
+package gosocketio
+
+import (
+	"github.com/graarh/golang-socketio/transport"
+	"net/http"
+)
+
+/**
+Connection middleware, it should call next to pass the channel further
+along the chain, returned error rejects the channel
+*/
+type Middleware func(c *Channel, next func() error) error
+
+/**
+Add middleware to the end of the chain, middlewares are called in order
+of adding for every new connection, before OnConnection event occurs
+*/
+func (s *Server) Use(mw Middleware) {
+	s.middlewaresLock.Lock()
+	defer s.middlewaresLock.Unlock()
+
+	s.middlewares = append(s.middlewares, mw)
+}
+
+/**
+Add middleware of namespace, it is called when client connects to the
+namespace, returned error refuses the connect with error packet to the
+namespace, connection itself and its other namespaces stay alive
+*/
+func (n *Namespace) Use(mw Middleware) {
+	n.middlewaresLock.Lock()
+	defer n.middlewaresLock.Unlock()
+
+	n.middlewares = append(n.middlewares, mw)
+}
+
+/**
+Run namespace middleware chain for given namespace channel
+*/
+func (n *Namespace) runMiddlewares(c *Channel) error {
+	n.middlewaresLock.RLock()
+	chain := n.middlewares
+	n.middlewaresLock.RUnlock()
+
+	return runChain(chain, c)
+}
+
+/**
+Run middleware chain for given channel
+*/
+func (s *Server) runMiddlewares(c *Channel) error {
+	s.middlewaresLock.RLock()
+	chain := s.middlewares
+	s.middlewaresLock.RUnlock()
+
+	return runChain(chain, c)
+}
+
+/**
+Call first middleware of the chain, passing the rest as next
+*/
+func runChain(chain []Middleware, c *Channel) error {
+	if len(chain) == 0 {
+		return nil
+	}
+
+	return chain[0](c, func() error {
+		return runChain(chain[1:], c)
+	})
+}
+
+/**
+Handshake authentication handler, it is called for every handshake request
+before upgrade, following polling requests of the connection are not checked, returned value is available as Channel.Auth, error rejects
+the request with 401 and no channel is created, use middleware to
+refuse the connect with error packet the client is able to handle
+*/
+type AuthHandler func(r *http.Request) (interface{}, error)
+
+/**
+Set handshake authentication handler, should be set before serving
+*/
+func (s *Server) SetAuthHandler(h AuthHandler) {
+	s.authHandler = h
+}
+
+/**
+Run auth handler for given request, if set
+*/
+func (s *Server) authenticate(r *http.Request) (interface{}, error) {
+	if s.authHandler == nil {
+		return nil, nil
+	}
+
+	return s.authHandler(r)
+}
+
+/**
+Get value returned by auth handler for this connection
+*/
+func (c *Channel) Auth() interface{} {
+	return c.auth
+}
+
+/**
+Close channel, rejected by middleware, before its loops are started,
+the peer gets open packet and connect error with the reason, so socket.io
+clients fire connect_error, the reason is also given as close reason
+if transport supports it. Incoming loop is not started, so close handshake
+is not waited for, polling connection is closed once client gets the packets.
+*/
+func rejectChannel(c *Channel, reason error) {
+	c.setState(StateClosed)
+	c.cancel()
+	c.server.releaseConnection()
+
+	if err := c.conn.WriteMessage(openPacket(c)); err == nil {
+		c.conn.WriteMessage(connectErrorPacket(c, "", reason))
+	}
+
+	if ac, ok := c.conn.(transport.AbortConnection); ok {
+		ac.CloseAfterWrite(transport.ClosePolicyViolation, reason.Error())
+		return
+	}
+	c.conn.Close()
+}
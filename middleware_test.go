@@ -0,0 +1,137 @@
+package gosocketio
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graarh/golang-socketio/transport"
+)
+
+func TestRejectedWebsocketClosesAtOnce(t *testing.T) {
+	s := NewServer(transport.GetDefaultWebsocketTransport())
+	s.Use(func(c *Channel, next func() error) error {
+		return errors.New("not allowed")
+	})
+
+	served := make(chan time.Duration, 1)
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		s.ServeHTTP(w, r)
+		served <- time.Since(start)
+	}))
+	defer httpServer.Close()
+
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http") + socketioUrl
+	socket, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+
+	for _, prefix := range []string{"0{", `44"not allowed"`} {
+		_, message, err := socket.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(message), prefix) {
+			t.Fatalf("expected %s packet, got %s", prefix, message)
+		}
+	}
+	_, _, err = socket.ReadMessage()
+	if !websocket.IsCloseError(err, transport.ClosePolicyViolation) {
+		t.Fatalf("expected policy violation close, got %v", err)
+	}
+
+	if elapsed := <-served; elapsed >= transport.WsCloseWaitTimeout/2 {
+		t.Fatalf("rejection waited for close handshake for %v", elapsed)
+	}
+}
+
+func TestRejectedPolling(t *testing.T) {
+	s := NewServer(transport.GetDefaultPollingTransport())
+	s.Use(func(c *Channel, next func() error) error {
+		return errors.New("nope")
+	})
+	httpServer := httptest.NewServer(s)
+	defer httpServer.Close()
+
+	for _, version := range []string{"3", "4"} {
+		resp, err := http.Get(httpServer.URL + "/socket.io/?EIO=" + version + "&transport=polling")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		payload := string(body)
+
+		//open packet is followed by connect error and close packet
+		expected := `8:44"nope"1:1`
+		if version == "4" {
+			expected = "\x1e" + `44{"message":"nope"}` + "\x1e1"
+		}
+		if !strings.HasSuffix(payload, expected) {
+			t.Fatalf("v%s handshake is answered with %q", version, payload)
+		}
+	}
+}
+
+func TestAuthHandlerOnHandshakeOnly(t *testing.T) {
+	s, url := startServer(t, transport.GetDefaultPollingTransport())
+	var calls int32
+	s.SetAuthHandler(func(r *http.Request) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return r.URL.Query().Get("token"), nil
+	})
+	s.On("whoami", func(c *Channel) string {
+		return c.Auth().(string)
+	})
+
+	c, err := DialAndWait(url+"&token=secret", transport.GetDefaultPollingTransport(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		result, err := c.Ack("whoami", nil, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != `"secret"` {
+			t.Fatalf("unexpected auth value %s", result)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("auth handler is called %d times", n)
+	}
+}
+
+func TestAuthHandlerRejects(t *testing.T) {
+	s, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	s.SetAuthHandler(func(r *http.Request) (interface{}, error) {
+		return nil, errors.New("bad token")
+	})
+	var connected int32
+	s.On(OnConnection, func(c *Channel) {
+		atomic.AddInt32(&connected, 1)
+	})
+
+	tr := transport.GetDefaultWebsocketTransport()
+	_, resp, err := tr.ConnectWithResponse(url)
+	if err == nil {
+		t.Fatal("connection is not refused")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 response, got %v", resp)
+	}
+	if atomic.LoadInt32(&connected) != 0 || s.AmountOfSids() != 0 {
+		t.Fatal("channel is created for failed auth")
+	}
+}
@@ -0,0 +1,276 @@
+package gosocketio
+
+import (
+	"github.com/graarh/golang-socketio/protocol"
+	"sync"
+	"sync/atomic"
+)
+
+/**
+Form of root namespace in sent packets
+*/
+type RootNamespaceForm int32
+
+const (
+	//omitted, like "40", as engine.io v3 and v4 peers expect, until peer
+	//sends root packet in explicit form
+	RootNamespaceAuto RootNamespaceForm = iota
+	//always omitted, like "40"
+	RootNamespaceOmitted
+	//always written, like "40/,"
+	RootNamespaceExplicit
+)
+
+/**
+Handlers of socket.io namespace, like /admin
+
+Handlers get channel of the namespace, so Emit and Ack
+of that channel are scoped to the namespace too
+*/
+type Namespace struct {
+	methods
+
+	name string
+
+	middlewares     []Middleware
+	middlewaresLock sync.RWMutex
+}
+
+/**
+Get namespace name
+*/
+func (n *Namespace) Name() string {
+	return n.name
+}
+
+/**
+Get namespace of this channel, empty for root one
+*/
+func (c *Channel) Namespace() string {
+	return c.namespace
+}
+
+/**
+Get channel of given namespace over the same connection
+
+On client side the namespace is connected if it is not yet, and
+gets handlers if it has none, so its acks are answered, on server
+side nil is returned if client is not connected to it
+*/
+func (c *Channel) Of(namespace string) *Channel {
+	root := c
+	if c.root != nil {
+		root = c.root
+	}
+	if namespace == "" || namespace == protocol.RootNamespace {
+		return root
+	}
+
+	root.namespacesLock.Lock()
+	defer root.namespacesLock.Unlock()
+
+	if nc, ok := root.namespaces[namespace]; ok {
+		return nc
+	}
+	if root.server != nil {
+		return nil
+	}
+
+	//packets of namespace without handlers are dropped, acks as well
+	if root.methods != nil {
+		root.methods.of(namespace, nil)
+	}
+	nc := newNamespaceChannel(root, namespace)
+	root.namespaces[namespace] = nc
+	root.out <- outPacket{text: protocol.MustEncode(&protocol.Message{
+		Type:      protocol.MessageTypeEmpty,
+		Namespace: namespace,
+	})}
+
+	return nc
+}
+
+/**
+Set form of root namespace in packets of this connection, engine.io v3
+and v4 clients omit it, some other peers expect it explicit
+*/
+func (c *Channel) SetRootNamespaceForm(form RootNamespaceForm) {
+	if c.root != nil {
+		c.root.SetRootNamespaceForm(form)
+		return
+	}
+	atomic.StoreInt32(&c.rootForm, int32(form))
+}
+
+/**
+Set form of root namespace for new connections, see Channel.SetRootNamespaceForm
+*/
+func (s *Server) SetRootNamespaceForm(form RootNamespaceForm) {
+	atomic.StoreInt32(&s.rootForm, int32(form))
+}
+
+/**
+Check that root namespace is written to packets explicitly, as the peer
+expects, engine.io v3 and v4 peers omit it unless they show otherwise
+*/
+func (c *Channel) explicitRoot() bool {
+	root := c
+	if c.root != nil {
+		root = c.root
+	}
+	return RootNamespaceForm(atomic.LoadInt32(&root.rootForm)) == RootNamespaceExplicit
+}
+
+/**
+Switch connection in auto form to explicit root namespace,
+if peer sent root packet in that form, called by incoming loop
+*/
+func (c *Channel) detectRootNamespace(msg *protocol.Message) {
+	if msg.Namespace != protocol.RootNamespace {
+		return
+	}
+	atomic.CompareAndSwapInt32(&c.rootForm, int32(RootNamespaceAuto), int32(RootNamespaceExplicit))
+}
+
+/**
+Create channel of given namespace, that shares connection with root one
+*/
+func newNamespaceChannel(root *Channel, namespace string) *Channel {
+	return &Channel{
+		conn:          root.conn,
+		out:           root.out,
+		ack:           root.ack,
+		methods:       root.methods,
+		server:        root.server,
+		ip:            root.ip,
+		requestHeader: root.requestHeader,
+		request:       root.request,
+		auth:          root.auth,
+		namespace:     namespace,
+		root:          root,
+	}
+}
+
+/**
+Server answer to namespace connect, socket.io v3+ clients get sid in it
+*/
+func connectPacket(c *Channel, namespace string) string {
+	msg := &protocol.Message{
+		Type:         protocol.MessageTypeEmpty,
+		Namespace:    namespace,
+		ExplicitRoot: c.explicitRoot(),
+	}
+	if c.engineIO == protocol.EngineIOv4 {
+		msg.Args = `{"sid":"` + c.Id() + `"}`
+	}
+	return protocol.MustEncode(msg)
+}
+
+/**
+Server refusal of namespace connect, socket.io v3+ clients get
+reason as message of error object, older ones as string
+*/
+func connectErrorPacket(c *Channel, namespace string, reason error) string {
+	var data interface{} = reason.Error()
+	if c.engineIO == protocol.EngineIOv4 {
+		data = map[string]string{"message": reason.Error()}
+	}
+
+	args, err := protocol.Marshal(&data)
+	if err != nil {
+		args = []byte(`""`)
+	}
+
+	return protocol.MustEncode(&protocol.Message{
+		Type:         protocol.MessageTypeConnectError,
+		Namespace:    namespace,
+		ExplicitRoot: c.explicitRoot(),
+		Args:         string(args),
+	})
+}
+
+/**
+Route namespace packet to its channel and handlers,
+packets of unknown namespaces are dropped
+*/
+func processNamespaceMessage(c *Channel, m *methods, msg *protocol.Message) {
+	n, ok := m.findNamespace(msg.Namespace)
+	if !ok {
+		return
+	}
+
+	c.namespacesLock.RLock()
+	nc, connected := c.namespaces[msg.Namespace]
+	c.namespacesLock.RUnlock()
+
+	if msg.Type == protocol.MessageTypeDisconnect {
+		if connected {
+			leaveNamespace(c, n, msg.Namespace)
+		}
+		return
+	}
+
+	//packets are routed by incoming loop only, so connect is not raced
+	if !connected && msg.Type == protocol.MessageTypeEmpty && c.server != nil {
+		//client connects to namespace, authorize and confirm it
+		nc = newNamespaceChannel(c, msg.Namespace)
+		if err := n.runMiddlewares(nc); err != nil {
+			c.out <- outPacket{text: connectErrorPacket(c, msg.Namespace, err)}
+			return
+		}
+
+		c.namespacesLock.Lock()
+		c.namespaces[msg.Namespace] = nc
+		c.namespacesLock.Unlock()
+		c.out <- outPacket{text: connectPacket(c, msg.Namespace)}
+		c.namespaceConnected()
+	}
+
+	if connected && msg.Type == protocol.MessageTypeConnectError && c.server == nil {
+		rejectedNamespace(c, n, msg)
+		return
+	}
+	if nc == nil {
+		return
+	}
+	if msg.Type == protocol.MessageTypeEmpty {
+		n.callLoopEvent(nc, OnConnection)
+		return
+	}
+
+	if msg = nc.interceptInbound(&n.methods, msg); msg == nil {
+		return
+	}
+	nc.dispatchHandler(msg, func() { n.processIncomingMessage(nc, msg) })
+}
+
+/**
+Close channel of namespace left by peer and call its disconnection
+handlers, connection and its other namespaces stay alive
+*/
+func leaveNamespace(c *Channel, n *Namespace, namespace string) {
+	c.namespacesLock.Lock()
+	nc, ok := c.namespaces[namespace]
+	delete(c.namespaces, namespace)
+	c.namespacesLock.Unlock()
+
+	if ok {
+		n.callLoopEvent(nc, OnDisconnection)
+	}
+}
+
+/**
+Call disconnection handlers of all namespaces of closed channel
+*/
+func closeNamespaces(c *Channel, m *methods) {
+	c.namespacesLock.Lock()
+	namespaces := c.namespaces
+	c.namespaces = make(map[string]*Channel)
+	c.namespacesLock.Unlock()
+
+	for name, nc := range namespaces {
+		if n, ok := m.findNamespace(name); ok {
+			n.callLoopEvent(nc, OnDisconnection)
+		}
+	}
+}
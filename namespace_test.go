@@ -0,0 +1,104 @@
+package gosocketio
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graarh/golang-socketio/transport"
+)
+
+func TestChannelOfAck(t *testing.T) {
+	s, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	s.Of("/admin").On("hello", func(c *Channel, name string) string {
+		return "admin " + name
+	})
+
+	c := dialServer(t, url)
+	//namespace is connected without Client.Of
+	result, err := c.Channel.Of("/admin").Ack("hello", "x", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != `"admin x"` {
+		t.Fatalf("unexpected ack result %s", result)
+	}
+}
+
+func TestRootNamespaceOfClientVersions(t *testing.T) {
+	_, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	v4 := strings.Replace(url, "EIO=3", "EIO=4", 1)
+
+	//socket.io v2 client is connected by server
+	socket := dialRaw(t, url)
+	readPacket(t, socket)
+	if packet := readPacket(t, socket); packet != "40" {
+		t.Fatalf("v2 client got connect %s", packet)
+	}
+
+	//socket.io v3 and v4 clients connect themselves, v4 ones with auth payload
+	for name, connect := range map[string]string{"v3": "40", "v4": `40{"token":"x"}`, "explicit": "40/,"} {
+		socket := dialRaw(t, v4)
+		hdr := openHeader(t, readPacket(t, socket))
+		writeRaw(t, socket, connect)
+
+		expected := `40{"sid":"` + hdr["sid"].(string) + `"}`
+		if name == "explicit" {
+			expected = `40/,{"sid":"` + hdr["sid"].(string) + `"}`
+		}
+		if packet := readPacket(t, socket); packet != expected {
+			t.Fatalf("%s client got connect %s", name, packet)
+		}
+	}
+}
+
+func TestNamespaceDisconnectPacket(t *testing.T) {
+	s, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	left := make(chan string, 2)
+	s.Of("/admin").On(OnDisconnection, func(c *Channel) {
+		left <- c.Namespace()
+	})
+	s.On(OnDisconnection, func(c *Channel) {
+		left <- "/"
+	})
+	s.On("hello", func(c *Channel) string {
+		return "root"
+	})
+
+	socket := dialRaw(t, url)
+	readPacket(t, socket)
+	readPacket(t, socket)
+	writeRaw(t, socket, "40/admin,")
+	if packet := readPacket(t, socket); packet != "40/admin," {
+		t.Fatalf("unexpected namespace connect %s", packet)
+	}
+
+	//leaving namespace closes only its channel
+	writeRaw(t, socket, "41/admin,")
+	select {
+	case namespace := <-left:
+		if namespace != "/admin" {
+			t.Fatalf("expected /admin to be left, got %s", namespace)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("disconnection handler of namespace is not called")
+	}
+	writeRaw(t, socket, `421["hello"]`)
+	if packet := readPacket(t, socket); packet != `431["root"]` {
+		t.Fatalf("root namespace is not alive, got %s", packet)
+	}
+	if s.AmountOfSids() != 1 {
+		t.Fatalf("connection is closed with namespace")
+	}
+
+	//leaving root namespace closes connection
+	writeRaw(t, socket, "41")
+	select {
+	case namespace := <-left:
+		if namespace != "/" {
+			t.Fatalf("expected root to be left, got %s", namespace)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection is not closed by root disconnect")
+	}
+}
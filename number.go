@@ -0,0 +1,53 @@
+package gosocketio
+
+import (
+	"github.com/graarh/golang-socketio/protocol"
+	"sync/atomic"
+)
+
+func useNumberValue(enabled bool) int32 {
+	if enabled {
+		return 1
+	}
+	return 0
+}
+
+/**
+Decode json numbers of event arguments as json.Number instead of float64,
+so large integer ids survive, applied to new connections, only arguments
+decoded into interface{} values are affected, current codec should be
+protocol.NumberCodec, the default one is
+*/
+func (s *Server) SetUseNumber(enabled bool) {
+	atomic.StoreInt32(&s.useNumber, useNumberValue(enabled))
+}
+
+/**
+Decode json numbers of event arguments as json.Number, see Server.SetUseNumber
+*/
+func (c *Client) SetUseNumber(enabled bool) {
+	atomic.StoreInt32(&c.useNumber, useNumberValue(enabled))
+}
+
+/**
+Decode json numbers of event arguments as json.Number, it is kept
+for new connections, see Server.SetUseNumber
+*/
+func (rc *ReconnectingClient) SetUseNumber(enabled bool) {
+	atomic.StoreInt32(&rc.useNumber, useNumberValue(enabled))
+	atomic.StoreInt32(&rc.Channel().useNumber, useNumberValue(enabled))
+}
+
+/**
+Unmarshal json arguments with number setting of the connection
+*/
+func (c *Channel) unmarshalArgs(data []byte, v interface{}) error {
+	root := c
+	if c.root != nil {
+		root = c.root
+	}
+	if atomic.LoadInt32(&root.useNumber) == 1 {
+		return protocol.UnmarshalUseNumber(data, v)
+	}
+	return protocol.Unmarshal(data, v)
+}
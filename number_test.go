@@ -0,0 +1,32 @@
+package gosocketio
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/graarh/golang-socketio/transport"
+)
+
+func TestUseNumberRoundTrip(t *testing.T) {
+	s, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	s.SetUseNumber(true)
+	ids := make(chan interface{}, 1)
+	s.On("echo", func(c *Channel, args map[string]interface{}) map[string]interface{} {
+		ids <- args["id"]
+		return args
+	})
+
+	c := dialServer(t, url)
+	result, err := c.Ack("echo", json.RawMessage(`{"id":9007199254740993}`), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := <-ids
+	if number, ok := id.(json.Number); !ok || number.String() != "9007199254740993" {
+		t.Fatalf("expected json.Number 9007199254740993, got %#v", id)
+	}
+	if result != `{"id":9007199254740993}` {
+		t.Fatalf("id lost precision in round trip, got %s", result)
+	}
+}
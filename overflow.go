@@ -0,0 +1,199 @@
+package gosocketio
+
+import (
+	"github.com/graarh/golang-socketio/protocol"
+	"sync/atomic"
+)
+
+const (
+	//local event, occurs when outgoing packet is dropped by overflow policy
+	OnOverflow = "overflow"
+)
+
+/**
+What to do with packet sent to channel with full outgoing queue
+*/
+type OverflowPolicy int32
+
+const (
+	//packet is refused with ErrorSocketOverflood, and connection
+	//is closed when outgoing loop finds the queue full
+	OverflowClose OverflowPolicy = iota
+	//packet is refused with ErrorSocketOverflood
+	OverflowDropNewest
+	//the oldest queued packet is dropped to free place for new one, new
+	//packet is refused instead if the oldest one closes connection,
+	//so Flush and CloseGracefully do not wait for dropped packets
+	OverflowDropOldest
+	//sender waits for free place or connection close, broadcasts
+	//drop the packet as OverflowDropNewest instead of waiting
+	OverflowBlock
+)
+
+/**
+Set policy of outgoing queue overflow, OverflowClose is used by default,
+OnOverflow handler is called on every dropped packet
+*/
+func (c *Channel) SetOverflowPolicy(policy OverflowPolicy) {
+	if c.root != nil {
+		c.root.SetOverflowPolicy(policy)
+		return
+	}
+	atomic.StoreInt32(&c.overflowPolicy, int32(policy))
+}
+
+func (c *Channel) getOverflowPolicy() OverflowPolicy {
+	if c.root != nil {
+		return c.root.getOverflowPolicy()
+	}
+	return OverflowPolicy(atomic.LoadInt32(&c.overflowPolicy))
+}
+
+/**
+Set capacity of outgoing queue of new connections
+*/
+func (s *Server) SetQueueSize(size int) {
+	s.queueSize = size
+}
+
+/**
+Call OnOverflow handler of dropped packet
+*/
+func (c *Channel) overflow() {
+	if c.root != nil {
+		c = c.root
+	}
+	if c.methods != nil {
+		go c.methods.callLoopEvent(c, OnOverflow)
+	}
+}
+
+/**
+Put packet to outgoing queue according to overflow policy
+*/
+func (c *Channel) enqueue(packet outPacket) error {
+	return c.enqueueWith(packet, c.getOverflowPolicy())
+}
+
+/**
+Overflow policy of packet sent with given options, broadcasted packet is
+dropped instead of blocking, so one slow channel can't stall the others
+*/
+func (c *Channel) overflowPolicyOf(opts emitOptions) OverflowPolicy {
+	policy := c.getOverflowPolicy()
+	if opts.broadcast && policy == OverflowBlock {
+		return OverflowDropNewest
+	}
+	return policy
+}
+
+/**
+Check that packet closes connection, so it is never dropped
+*/
+func closingPacket(packet outPacket) bool {
+	return packet.closeCode != 0 || packet.text == protocol.CloseMessage
+}
+
+/**
+Put packet taken from the queue back, waiting for free place, as other
+senders could take it meanwhile
+*/
+func (c *Channel) requeue(packet outPacket) {
+	select {
+	case c.out <- packet:
+	case <-c.Context().Done():
+	}
+}
+
+/**
+Keep flush marker taken from the queue till outgoing loop writes
+packets it has taken, markers of namespaces are kept by the root
+channel, as it runs the outgoing loop
+*/
+func (c *Channel) takeFlush(flushed chan struct{}) {
+	if c.root != nil {
+		c.root.takeFlush(flushed)
+		return
+	}
+
+	c.takenFlushesLock.Lock()
+	defer c.takenFlushesLock.Unlock()
+
+	c.takenFlushes = append(c.takenFlushes, flushed)
+}
+
+/**
+Signal flush markers taken from the queue, called by outgoing loop
+when packets it has taken are written
+*/
+func (c *Channel) signalTakenFlushes() {
+	if c.root != nil {
+		c.root.signalTakenFlushes()
+		return
+	}
+
+	c.takenFlushesLock.Lock()
+	defer c.takenFlushesLock.Unlock()
+
+	for _, flushed := range c.takenFlushes {
+		close(flushed)
+	}
+	c.takenFlushes = nil
+}
+
+/**
+Put packet to outgoing queue according to given overflow policy
+*/
+func (c *Channel) enqueueWith(packet outPacket, policy OverflowPolicy) error {
+	select {
+	case c.out <- packet:
+		return nil
+	default:
+	}
+
+	switch policy {
+	case OverflowDropOldest:
+		for {
+			if c.Context().Err() != nil {
+				//closing connection keeps its close packet
+				return ErrorNotConnected
+			}
+			select {
+			case dropped := <-c.out:
+				if dropped.flushed != nil {
+					//packets before the marker are taken, but could be still written
+					c.takeFlush(dropped.flushed)
+					continue
+				}
+				if closingPacket(dropped) {
+					//connection is closing, new packet is dropped instead
+					c.requeue(dropped)
+					c.overflow()
+					return ErrorSocketOverflood
+				}
+				if dropped.written != nil {
+					dropped.written <- batchResult{sent: 0, err: ErrorSocketOverflood}
+				}
+				c.overflow()
+			default:
+			}
+
+			select {
+			case c.out <- packet:
+				return nil
+			default:
+			}
+		}
+	case OverflowBlock:
+		select {
+		case c.out <- packet:
+			return nil
+		case <-c.Context().Done():
+			return ErrorNotConnected
+		}
+	case OverflowDropNewest:
+		c.overflow()
+	}
+
+	return ErrorSocketOverflood
+}
@@ -0,0 +1,186 @@
+package gosocketio
+
+import (
+	"testing"
+	"time"
+
+	"github.com/graarh/golang-socketio/transport"
+)
+
+/**
+Open channel without connection, its outgoing queue is not read
+*/
+func newQueueChannel(sid string, size int, policy OverflowPolicy) *Channel {
+	c := &Channel{queueSize: size}
+	c.initChannel()
+	c.header.Sid = sid
+	c.setState(StateOpen)
+	c.SetOverflowPolicy(policy)
+	return c
+}
+
+func TestBroadcastDoesNotBlock(t *testing.T) {
+	broadcasts := map[string]func(s *Server){
+		"BroadcastToAll": func(s *Server) { s.BroadcastToAll("news", nil) },
+		"BroadcastAll":   func(s *Server) { s.BroadcastAll("news", nil, "other") },
+		"BroadcastWhere": func(s *Server) {
+			s.BroadcastWhere(func(c *Channel) bool { return true }, "news", nil)
+		},
+	}
+
+	for name, broadcast := range broadcasts {
+		s := NewServer(transport.GetDefaultWebsocketTransport())
+		full := newQueueChannel("full", 1, OverflowBlock)
+		free := newQueueChannel("free", 1, OverflowBlock)
+		if err := full.Emit("fill", nil); err != nil {
+			t.Fatal(err)
+		}
+		s.sids[full.Id()] = full
+		s.sids[free.Id()] = free
+
+		done := make(chan struct{})
+		go func() {
+			broadcast(s)
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("%s is blocked by channel with full queue", name)
+		}
+		if len(free.out) != 1 {
+			t.Fatalf("%s is not queued to channel with free place", name)
+		}
+	}
+}
+
+func TestDropOldestSignalsDroppedBatch(t *testing.T) {
+	c := newQueueChannel("sid", 1, OverflowDropOldest)
+	written := make(chan batchResult, 1)
+	c.out <- outPacket{batch: []outPacket{{text: "42"}}, written: written}
+
+	if err := c.Emit("news", nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case result := <-written:
+		if result.sent != 0 || result.err != ErrorSocketOverflood {
+			t.Fatalf("unexpected result of dropped batch %+v", result)
+		}
+	default:
+		t.Fatal("dropped batch is not signalled")
+	}
+}
+
+func TestDropOldestKeepsClosePacket(t *testing.T) {
+	c := newQueueChannel("sid", 1, OverflowDropOldest)
+	c.out <- outPacket{text: "bye", closeCode: transport.CloseGoingAway}
+
+	if err := c.Emit("news", nil); err != ErrorSocketOverflood {
+		t.Fatalf("expected ErrorSocketOverflood, got %v", err)
+	}
+	if packet := <-c.out; packet.closeCode != transport.CloseGoingAway {
+		t.Fatalf("close packet is dropped, %+v", packet)
+	}
+}
+
+/**
+Connection, which write blocks till gate is opened, started writes
+are reported
+*/
+type gatedConnection struct {
+	*silentConnection
+	started chan string
+	gate    chan struct{}
+}
+
+func (gc *gatedConnection) WriteMessage(message string) error {
+	gc.started <- message
+	<-gc.gate
+	return gc.silentConnection.WriteMessage(message)
+}
+
+func TestDropOldestFlushWaitsForWrite(t *testing.T) {
+	conn := &gatedConnection{
+		silentConnection: &silentConnection{closed: make(chan struct{})},
+		started:          make(chan string, 10),
+		gate:             make(chan struct{}),
+	}
+	c := newLoopChannel(conn, 2)
+	defer c.Close()
+	c.SetOverflowPolicy(OverflowDropOldest)
+
+	if err := c.Emit("first", nil); err != nil {
+		t.Fatal(err)
+	}
+	<-conn.started
+
+	flushed := make(chan error, 1)
+	go func() {
+		flushed <- c.Flush(time.Second)
+	}()
+	waitFor(t, "flush marker", func() bool { return len(c.out) == 1 })
+
+	//the marker is the oldest queued packet, so it is taken for the last one
+	for _, method := range []string{"second", "third"} {
+		if err := c.Emit(method, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case err := <-flushed:
+		t.Fatalf("flush returned while the first packet is written, %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(conn.gate)
+	select {
+	case err := <-flushed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("flush is not signalled after write")
+	}
+}
+
+func TestDropOldestNamespaceFlush(t *testing.T) {
+	conn := &gatedConnection{
+		silentConnection: &silentConnection{closed: make(chan struct{})},
+		started:          make(chan string, 10),
+		gate:             make(chan struct{}),
+	}
+	c := newLoopChannel(conn, 2)
+	defer c.Close()
+	c.SetOverflowPolicy(OverflowDropOldest)
+
+	if err := c.Emit("first", nil); err != nil {
+		t.Fatal(err)
+	}
+	<-conn.started
+	nc := c.Of("/admin")
+
+	flushed := make(chan error, 1)
+	go func() {
+		flushed <- nc.Flush(time.Second)
+	}()
+	waitFor(t, "flush marker", func() bool { return len(c.out) == 2 })
+
+	//namespace flood drops its connect packet and then the flush marker
+	for _, method := range []string{"second", "third"} {
+		if err := nc.Emit(method, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	close(conn.gate)
+	select {
+	case err := <-flushed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("flush of namespace is not signalled after write")
+	}
+}
@@ -0,0 +1,75 @@
+package protocol
+
+import (
+	"strconv"
+	"strings"
+)
+
+/**
+Reference to binary attachment in packet arguments
+*/
+type Placeholder struct {
+	Placeholder bool `json:"_placeholder"`
+	Num         int  `json:"num"`
+}
+
+/**
+Get placeholder of attachment with given number
+*/
+func NewPlaceholder(num int) *Placeholder {
+	return &Placeholder{Placeholder: true, Num: num}
+}
+
+/**
+Parse placeholder of packet arguments, ok is false if args are not a placeholder
+*/
+func GetPlaceholder(args string) (placeholder Placeholder, ok bool) {
+	if !strings.Contains(args, "_placeholder") {
+		return placeholder, false
+	}
+	if err := Unmarshal([]byte(args), &placeholder); err != nil {
+		return placeholder, false
+	}
+	return placeholder, placeholder.Placeholder
+}
+
+/**
+Check that packet carries binary attachments
+*/
+func isBinary(data string) bool {
+	return strings.HasPrefix(data, binaryMessage) ||
+		strings.HasPrefix(data, binaryAckMessage)
+}
+
+/**
+Binary packet type with attachments count
+*/
+func encodeAttachments(msg *Message) (string, error) {
+	var result string
+	switch msg.Type {
+	case MessageTypeEmit, MessageTypeAckRequest:
+		result = binaryMessage
+	case MessageTypeAckResponse:
+		result = binaryAckMessage
+	default:
+		return "", ErrorWrongMessageType
+	}
+	return result + strconv.Itoa(msg.Attachments) + "-", nil
+}
+
+/**
+Get attachments count of binary packet, text should go after the packet type
+*/
+func getAttachments(text string) (attachments int, restText string, err error) {
+	pos := strings.IndexByte(text, '-')
+	if pos == -1 {
+		return 0, "", ErrorWrongPacket
+	}
+
+	attachments, err = strconv.Atoi(text[0:pos])
+	if err != nil || attachments < 0 {
+		return 0, "", ErrorWrongPacket
+	}
+
+	return attachments, text[pos+1:], nil
+}
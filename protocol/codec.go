@@ -0,0 +1,111 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+var (
+	ErrorTrailingData = errors.New("Data after top-level value")
+)
+
+/**
+Marshaller of event arguments and packet data, encoding/json is used
+by default, could be replaced by compatible one, like jsoniter
+*/
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+/**
+Codec of event arguments carried as binary attachments, like MessagePack,
+its name is used for negotiation at connect time
+*/
+type BinaryCodec interface {
+	Codec
+	Name() string
+}
+
+/**
+Codec that is able to decode numbers into interface{} values as json.Number
+instead of float64, so large integers keep their precision
+*/
+type NumberCodec interface {
+	Codec
+	UnmarshalUseNumber(data []byte, v interface{}) error
+}
+
+/**
+Default codec based on encoding/json
+*/
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) UnmarshalUseNumber(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+
+	//json.Unmarshal refuses anything after the value, so decoder does too
+	if _, err := dec.Token(); err != io.EOF {
+		return ErrorTrailingData
+	}
+	return nil
+}
+
+var codec Codec = jsonCodec{}
+
+/**
+Set codec used for all packets, should be called before any
+connection is made, nil restores the default one
+*/
+func SetCodec(c Codec) {
+	if c == nil {
+		c = jsonCodec{}
+	}
+	codec = c
+}
+
+/**
+Get codec used for all packets
+*/
+func GetCodec() Codec {
+	return codec
+}
+
+/**
+Marshal v using current codec
+*/
+func Marshal(v interface{}) ([]byte, error) {
+	return codec.Marshal(v)
+}
+
+/**
+Unmarshal data into v using current codec
+*/
+func Unmarshal(data []byte, v interface{}) error {
+	return codec.Unmarshal(data, v)
+}
+
+/**
+Unmarshal data into v using current codec, numbers are decoded
+as json.Number if codec is NumberCodec, as usual otherwise
+*/
+func UnmarshalUseNumber(data []byte, v interface{}) error {
+	if nc, ok := codec.(NumberCodec); ok {
+		return nc.UnmarshalUseNumber(data, v)
+	}
+	return codec.Unmarshal(data, v)
+}
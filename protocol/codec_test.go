@@ -0,0 +1,176 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+/**
+Codec that counts calls, to check that packets are routed through it
+*/
+type countingCodec struct {
+	marshals   int
+	unmarshals int
+}
+
+func (cc *countingCodec) Marshal(v interface{}) ([]byte, error) {
+	cc.marshals++
+	return jsonCodec{}.Marshal(v)
+}
+
+func (cc *countingCodec) Unmarshal(data []byte, v interface{}) error {
+	cc.unmarshals++
+	return jsonCodec{}.Unmarshal(data, v)
+}
+
+/**
+Codec with fast path for plain ascii strings, like event names
+*/
+type stringCodec struct {
+	jsonCodec
+}
+
+func (stringCodec) Marshal(v interface{}) ([]byte, error) {
+	if s, ok := v.(*string); ok {
+		plain := true
+		for i := 0; i < len(*s) && plain; i++ {
+			plain = (*s)[i] >= 0x20 && (*s)[i] < 0x7f && (*s)[i] != '"' && (*s)[i] != '\\' &&
+				(*s)[i] != '<' && (*s)[i] != '>' && (*s)[i] != '&'
+		}
+		if plain {
+			buf := make([]byte, 0, len(*s)+2)
+			buf = append(buf, '"')
+			buf = append(buf, *s...)
+			return append(buf, '"'), nil
+		}
+	}
+	return jsonCodec{}.Marshal(v)
+}
+
+func TestSetCodec(t *testing.T) {
+	cc := &countingCodec{}
+	SetCodec(cc)
+	defer SetCodec(nil)
+
+	if GetCodec() != cc {
+		t.Fatal("codec is not set")
+	}
+	command, err := Encode(&Message{Type: MessageTypeEmit, Method: "event", Args: `{"a":1}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if command != `42["event",{"a":1}]` {
+		t.Fatalf("unexpected packet %s", command)
+	}
+	if cc.marshals != 1 {
+		t.Fatalf("encode made %d marshals with codec", cc.marshals)
+	}
+
+	//codec is not NumberCodec, so it is used as is
+	var value map[string]int
+	if err := UnmarshalUseNumber([]byte(`{"a":1}`), &value); err != nil {
+		t.Fatal(err)
+	}
+	if err := Unmarshal([]byte(`{"a":1}`), &value); err != nil {
+		t.Fatal(err)
+	}
+	if cc.unmarshals != 2 {
+		t.Fatalf("made %d unmarshals with codec", cc.unmarshals)
+	}
+
+	SetCodec(nil)
+	if _, ok := GetCodec().(jsonCodec); !ok {
+		t.Fatal("default codec is not restored")
+	}
+}
+
+func TestUnmarshalUseNumber(t *testing.T) {
+	//above 2^53, float64 rounds it to 9007199254740992
+	data := []byte(`{"id":9007199254740993}`)
+
+	var value map[string]interface{}
+	if err := UnmarshalUseNumber(data, &value); err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := value["id"].(json.Number); !ok || id.String() != "9007199254740993" {
+		t.Fatalf("expected json.Number 9007199254740993, got %#v", value["id"])
+	}
+	encoded, err := Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != string(data) {
+		t.Fatalf("expected %s after round trip, got %s", data, encoded)
+	}
+
+	if err := Unmarshal(data, &value); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := value["id"].(float64); !ok {
+		t.Fatalf("expected float64 without UseNumber, got %#v", value["id"])
+	}
+}
+
+func TestUnmarshalUseNumberTrailingData(t *testing.T) {
+	var value interface{}
+	for _, data := range []string{`{"a":1} {"b":2}`, `1 2`, `[1]]`, `"a"x`} {
+		if err := UnmarshalUseNumber([]byte(data), &value); err != ErrorTrailingData {
+			t.Fatalf("expected ErrorTrailingData for %s, got %v", data, err)
+		}
+	}
+	if err := UnmarshalUseNumber([]byte("{\"a\":1} \n"), &value); err != nil {
+		t.Fatalf("trailing whitespace is refused, %v", err)
+	}
+}
+
+type benchmarkArgs struct {
+	Id   int      `json:"id"`
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+/**
+Encode event with arguments, as emit does
+*/
+func benchmarkEncode(b *testing.B, c Codec) {
+	SetCodec(c)
+	defer SetCodec(nil)
+	args := &benchmarkArgs{Id: 1, Name: "event", Tags: []string{"a", "b"}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data, err := Marshal(args)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := EncodeBytes(&Message{Type: MessageTypeEmit, Method: "event", Args: string(data)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeDefaultCodec(b *testing.B) {
+	benchmarkEncode(b, nil)
+}
+
+func BenchmarkEncodeCustomCodec(b *testing.B) {
+	benchmarkEncode(b, stringCodec{})
+}
+
+func BenchmarkDecodeDefaultCodec(b *testing.B) {
+	packet := `42["event",{"id":1,"name":"event","tags":["a","b"]}]`
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		msg, err := Decode(packet)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var args benchmarkArgs
+		if err := Unmarshal([]byte(msg.Args), &args); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package protocol
+
+const (
+	EngineIOv3 = 3
+	EngineIOv4 = 4
+)
+
+/**
+Get engine.io protocol version by EIO query parameter, v3 is used by default
+*/
+func GetEngineIOVersion(eio string) int {
+	if eio == "4" {
+		return EngineIOv4
+	}
+	return EngineIOv3
+}
@@ -33,13 +33,39 @@ const (
 	ack response
 	*/
 	MessageTypeAckResponse = iota
+	/**
+	Namespace connect is refused, args carry the reason
+	*/
+	MessageTypeConnectError = iota
+	/**
+	Engine.io transport upgrade is finished by client
+	*/
+	MessageTypeUpgrade = iota
+	/**
+	Engine.io packet without data, like empty polling answer
+	*/
+	MessageTypeNoop = iota
+	/**
+	Namespace is left by peer, or peer is disconnected from it
+	*/
+	MessageTypeDisconnect = iota
 )
 
 type Message struct {
-	Type   int
-	AckId  int
-	Method string
-	Args   string
-	Source string
+	Type      int
+	AckId     int
+	Namespace string
+	Method    string
+	Args      string
+	Source    string
+
+	//root namespace is written as "/," instead of being omitted,
+	//set by decoding for packets with explicit root
+	ExplicitRoot bool
+
+	//number of binary attachments, sent as separate frames after the packet,
+	//Args refer to them by placeholders
+	Attachments int
+	Buffers     [][]byte
 }
 
@@ -0,0 +1,358 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+)
+
+const (
+	//nesting of decoded arrays and maps, deeper data is refused
+	msgpackMaxDepth = 1000
+)
+
+var (
+	ErrorMsgpackWrong       = errors.New("Wrong msgpack data")
+	ErrorMsgpackUnsupported = errors.New("Unsupported msgpack type")
+)
+
+/**
+MessagePack codec of event arguments, values are converted like json
+does it, so json tags and Marshaler implementations are respected.
+Byte slices are sent as base64 strings, as json sends them, and msgpack
+bin values are decoded to byte slices. Map keys should be strings,
+extension types are not supported.
+*/
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := msgpackEncode(&buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	d := &msgpackDecoder{data: data}
+	value, err := d.decode(0)
+	if err != nil {
+		return err
+	}
+	if d.pos != len(d.data) {
+		return ErrorTrailingData
+	}
+
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonData, v)
+}
+
+/**
+Write value of json tree to buffer
+*/
+func msgpackEncode(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		return msgpackEncodeNumber(buf, v)
+	case string:
+		msgpackEncodeHeader(buf, len(v), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		buf.WriteString(v)
+	case []interface{}:
+		msgpackEncodeHeader(buf, len(v), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, item := range v {
+			if err := msgpackEncode(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		msgpackEncodeHeader(buf, len(v), 0x80, 16, 0, 0xde, 0xdf)
+		//keys are sorted, so equal values are encoded equally
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			msgpackEncodeHeader(buf, len(key), 0xa0, 32, 0xd9, 0xda, 0xdb)
+			buf.WriteString(key)
+			if err := msgpackEncode(buf, v[key]); err != nil {
+				return err
+			}
+		}
+	default:
+		return ErrorMsgpackUnsupported
+	}
+	return nil
+}
+
+/**
+Write integer in the shortest form, other numbers as float64
+*/
+func msgpackEncodeNumber(buf *bytes.Buffer, number json.Number) error {
+	var b [9]byte
+	if n, err := strconv.ParseInt(string(number), 10, 64); err == nil {
+		switch {
+		case n >= 0 && n <= 0x7f:
+			buf.WriteByte(byte(n))
+		case n < 0 && n >= -32:
+			buf.WriteByte(byte(int8(n)))
+		case n >= math.MinInt8 && n <= math.MaxInt8:
+			buf.Write([]byte{0xd0, byte(int8(n))})
+		case n >= math.MinInt16 && n <= math.MaxInt16:
+			b[0] = 0xd1
+			binary.BigEndian.PutUint16(b[1:], uint16(int16(n)))
+			buf.Write(b[:3])
+		case n >= math.MinInt32 && n <= math.MaxInt32:
+			b[0] = 0xd2
+			binary.BigEndian.PutUint32(b[1:], uint32(int32(n)))
+			buf.Write(b[:5])
+		default:
+			b[0] = 0xd3
+			binary.BigEndian.PutUint64(b[1:], uint64(n))
+			buf.Write(b[:9])
+		}
+		return nil
+	}
+	if n, err := strconv.ParseUint(string(number), 10, 64); err == nil {
+		b[0] = 0xcf
+		binary.BigEndian.PutUint64(b[1:], n)
+		buf.Write(b[:9])
+		return nil
+	}
+
+	f, err := number.Float64()
+	if err != nil {
+		return err
+	}
+	b[0] = 0xcb
+	binary.BigEndian.PutUint64(b[1:], math.Float64bits(f))
+	buf.Write(b[:9])
+	return nil
+}
+
+/**
+Write header of string, array or map of given length, fix form is used
+for lengths below fixLimit, zero code means there is no 8 bit form
+*/
+func msgpackEncodeHeader(buf *bytes.Buffer, length int, fix byte, fixLimit int,
+	code8, code16, code32 byte) {
+
+	var b [5]byte
+	switch {
+	case length < fixLimit:
+		buf.WriteByte(fix | byte(length))
+	case code8 != 0 && length <= math.MaxUint8:
+		buf.Write([]byte{code8, byte(length)})
+	case length <= math.MaxUint16:
+		b[0] = code16
+		binary.BigEndian.PutUint16(b[1:], uint16(length))
+		buf.Write(b[:3])
+	default:
+		b[0] = code32
+		binary.BigEndian.PutUint32(b[1:], uint32(length))
+		buf.Write(b[:5])
+	}
+}
+
+/**
+Reader of msgpack data, values are decoded to json compatible ones
+*/
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+/**
+Take next n bytes
+*/
+func (d *msgpackDecoder) read(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.pos {
+		return nil, ErrorMsgpackWrong
+	}
+	result := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return result, nil
+}
+
+/**
+Read big endian unsigned integer of n bytes
+*/
+func (d *msgpackDecoder) readUint(n int) (uint64, error) {
+	b, err := d.read(n)
+	if err != nil {
+		return 0, err
+	}
+	var result uint64
+	for _, c := range b {
+		result = result<<8 | uint64(c)
+	}
+	return result, nil
+}
+
+/**
+Read length of n bytes, it could not be greater than rest of data,
+as every item takes at least a byte
+*/
+func (d *msgpackDecoder) readLength(n int) (int, error) {
+	length, err := d.readUint(n)
+	if err != nil {
+		return 0, err
+	}
+	if length > uint64(len(d.data)-d.pos) {
+		return 0, ErrorMsgpackWrong
+	}
+	return int(length), nil
+}
+
+func (d *msgpackDecoder) decode(depth int) (interface{}, error) {
+	if depth > msgpackMaxDepth {
+		return nil, ErrorMsgpackWrong
+	}
+	b, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+
+	code := b[0]
+	switch {
+	case code <= 0x7f:
+		return int64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code&0xf0 == 0x80:
+		return d.decodeMap(int(code&0x0f), depth)
+	case code&0xf0 == 0x90:
+		return d.decodeArray(int(code&0x0f), depth)
+	case code&0xe0 == 0xa0:
+		return d.decodeString(int(code & 0x1f))
+	}
+
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		length, err := d.readLength(1 << (code - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		data, err := d.read(length)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), data...), nil
+	case 0xca:
+		n, err := d.readUint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := d.readUint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return d.readUint(1 << (code - 0xcc))
+	case 0xd0:
+		n, err := d.readUint(1)
+		return int64(int8(n)), err
+	case 0xd1:
+		n, err := d.readUint(2)
+		return int64(int16(n)), err
+	case 0xd2:
+		n, err := d.readUint(4)
+		return int64(int32(n)), err
+	case 0xd3:
+		n, err := d.readUint(8)
+		return int64(n), err
+	case 0xd9, 0xda, 0xdb:
+		length, err := d.readLength(1 << (code - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeString(length)
+	case 0xdc, 0xdd:
+		length, err := d.readLength(2 << (code - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(length, depth)
+	case 0xde, 0xdf:
+		length, err := d.readLength(2 << (code - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(length, depth)
+	}
+	return nil, ErrorMsgpackUnsupported
+}
+
+func (d *msgpackDecoder) decodeString(length int) (interface{}, error) {
+	data, err := d.read(length)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (d *msgpackDecoder) decodeArray(length, depth int) (interface{}, error) {
+	result := make([]interface{}, length)
+	for i := range result {
+		value, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = value
+	}
+	return result, nil
+}
+
+func (d *msgpackDecoder) decodeMap(length, depth int) (interface{}, error) {
+	result := make(map[string]interface{}, length)
+	for i := 0; i < length; i++ {
+		key, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, ErrorMsgpackUnsupported
+		}
+		value, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		result[name] = value
+	}
+	return result, nil
+}
@@ -0,0 +1,134 @@
+package protocol
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type msgpackPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+type msgpackShape struct {
+	Name   string            `json:"name"`
+	Points []msgpackPoint    `json:"points"`
+	Center *msgpackPoint     `json:"center"`
+	Tags   map[string]string `json:"tags"`
+	Data   []byte            `json:"data"`
+}
+
+type msgpackScene struct {
+	Id       uint64                  `json:"id"`
+	Offset   int64                   `json:"offset"`
+	Visible  bool                    `json:"visible"`
+	Shapes   []msgpackShape          `json:"shapes"`
+	Layers   map[string]msgpackShape `json:"layers"`
+	Parent   *msgpackScene           `json:"parent"`
+	Comment  string                  `json:"comment,omitempty"`
+	Counters []int                   `json:"counters"`
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	scene := msgpackScene{
+		Id:      math.MaxUint64,
+		Offset:  math.MinInt64,
+		Visible: true,
+		Shapes: []msgpackShape{
+			{
+				Name:   "triangle",
+				Points: []msgpackPoint{{0, 0}, {1.5, 0}, {0, -2.25}},
+				Center: &msgpackPoint{0.5, -0.75},
+				Tags:   map[string]string{"color": "red", "": "empty key"},
+				Data:   []byte{0, 1, 2, 255},
+			},
+			{Name: strings.Repeat("long name ", 40)},
+		},
+		Layers: map[string]msgpackShape{
+			"background": {Name: "rect", Points: make([]msgpackPoint, 20)},
+		},
+		Parent:   &msgpackScene{Id: 1, Counters: []int{-1, -33, 127, 128, -129, 70000, -70000, 1 << 40}},
+		Counters: []int{},
+	}
+
+	codec := MsgpackCodec{}
+	data, err := codec.Marshal(&scene)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded msgpackScene
+	if err := codec.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(scene, decoded) {
+		t.Fatalf("round trip changed value:\n%+v\n%+v", scene, decoded)
+	}
+}
+
+func TestMsgpackEncoding(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected []byte
+	}{
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{1, []byte{0x01}},
+		{-1, []byte{0xff}},
+		{200, []byte{0xd1, 0x00, 0xc8}},
+		{1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"ab", []byte{0xa2, 'a', 'b'}},
+		{[]int{1, 2}, []byte{0x92, 0x01, 0x02}},
+		{map[string]int{"b": 2, "a": 1}, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02}},
+	}
+
+	for _, test := range tests {
+		data, err := MsgpackCodec{}.Marshal(test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, test.expected) {
+			t.Fatalf("%v encoded as % x, expected % x", test.value, data, test.expected)
+		}
+	}
+}
+
+func TestMsgpackDecodeOtherForms(t *testing.T) {
+	//forms other encoders use: uint8, bin8, str8 and float32
+	data := []byte{0x84,
+		0xa1, 'a', 0xcc, 0xc8,
+		0xa1, 'b', 0xc4, 0x02, 0x01, 0x02,
+		0xa1, 'c', 0xd9, 0x01, 'x',
+		0xa1, 'd', 0xca, 0x3f, 0xc0, 0x00, 0x00,
+	}
+	var value struct {
+		A int     `json:"a"`
+		B []byte  `json:"b"`
+		C string  `json:"c"`
+		D float32 `json:"d"`
+	}
+	if err := (MsgpackCodec{}).Unmarshal(data, &value); err != nil {
+		t.Fatal(err)
+	}
+	if value.A != 200 || !bytes.Equal(value.B, []byte{1, 2}) || value.C != "x" || value.D != 1.5 {
+		t.Fatalf("unexpected value %+v", value)
+	}
+}
+
+func TestMsgpackWrongData(t *testing.T) {
+	var value interface{}
+	for _, data := range [][]byte{
+		{},
+		{0x92, 0x01},
+		{0xdd, 0xff, 0xff, 0xff, 0xff},
+		{0x81, 0x01, 0x01},
+		{0xc1},
+		{0x01, 0x02},
+	} {
+		if err := (MsgpackCodec{}).Unmarshal(data, &value); err == nil {
+			t.Fatalf("% x is decoded", data)
+		}
+	}
+}
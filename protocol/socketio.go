@@ -1,22 +1,30 @@
 package protocol
 
 import (
-	"encoding/json"
 	"errors"
 	"strconv"
 	"strings"
 )
 
 const (
-	open          = "0"
-	msg           = "4"
-	emptyMessage  = "40"
-	commonMessage = "42"
-	ackMessage    = "43"
+	open              = "0"
+	msg               = "4"
+	emptyMessage      = "40"
+	disconnectMessage = "41"
+	commonMessage     = "42"
+	ackMessage        = "43"
+	errorMessage      = "44"
+
+	binaryMessage    = "45"
+	binaryAckMessage = "46"
+
+	RootNamespace = "/"
 
 	CloseMessage = "1"
 	PingMessage = "2"
 	PongMessage = "3"
+	UpgradeMessage = "5"
+	NoopMessage = "6"
 )
 
 var (
@@ -36,10 +44,18 @@ func typeToText(msgType int) (string, error) {
 		return PongMessage, nil
 	case MessageTypeEmpty:
 		return emptyMessage, nil
+	case MessageTypeDisconnect:
+		return disconnectMessage, nil
 	case MessageTypeEmit, MessageTypeAckRequest:
 		return commonMessage, nil
 	case MessageTypeAckResponse:
 		return ackMessage, nil
+	case MessageTypeConnectError:
+		return errorMessage, nil
+	case MessageTypeUpgrade:
+		return UpgradeMessage, nil
+	case MessageTypeNoop:
+		return NoopMessage, nil
 	}
 	return "", ErrorWrongMessageType
 }
@@ -50,8 +66,32 @@ func Encode(msg *Message) (string, error) {
 		return "", err
 	}
 
-	if msg.Type == MessageTypeEmpty || msg.Type == MessageTypePing ||
-		msg.Type == MessageTypePong {
+	if isEngineIOPacket(msg.Type) {
+		//ping and pong could carry data, like probe of upgrade
+		if msg.Type == MessageTypePing || msg.Type == MessageTypePong {
+			return result + msg.Args, nil
+		}
+		return result, nil
+	}
+
+	if msg.Attachments > 0 {
+		result, err = encodeAttachments(msg)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if msg.Type == MessageTypeEmpty || msg.Type == MessageTypeEmit ||
+		msg.Type == MessageTypeAckRequest || msg.Type == MessageTypeAckResponse ||
+		msg.Type == MessageTypeConnectError || msg.Type == MessageTypeDisconnect {
+		result += encodeNamespace(msg)
+	}
+
+	//connect packet could carry payload, like sid in socket.io v3+
+	if msg.Type == MessageTypeEmpty || msg.Type == MessageTypeConnectError {
+		return result + msg.Args, nil
+	}
+	if msg.Type == MessageTypeDisconnect {
 		return result, nil
 	}
 
@@ -67,14 +107,67 @@ func Encode(msg *Message) (string, error) {
 		return result + "[" + msg.Args + "]", nil
 	}
 
-	jsonMethod, err := json.Marshal(&msg.Method)
+	jsonMethod, err := Marshal(&msg.Method)
 	if err != nil {
 		return "", err
 	}
 
+	//event without arguments
+	if msg.Args == "" {
+		return result + "[" + string(jsonMethod) + "]", nil
+	}
+
 	return result + "[" + string(jsonMethod) + "," + msg.Args + "]", nil
 }
 
+/**
+Encode message to bytes, event and ack packets are built in one buffer
+without intermediate strings, so they could be written without conversion
+*/
+func EncodeBytes(msg *Message) ([]byte, error) {
+	if msg.Type != MessageTypeEmit && msg.Type != MessageTypeAckRequest &&
+		msg.Type != MessageTypeAckResponse {
+		result, err := Encode(msg)
+		return []byte(result), err
+	}
+
+	prefix, err := typeToText(msg.Type)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Attachments > 0 {
+		prefix, err = encodeAttachments(msg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var jsonMethod []byte
+	if msg.Type != MessageTypeAckResponse {
+		jsonMethod, err = Marshal(&msg.Method)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	namespace := encodeNamespace(msg)
+	//room for ack id and brackets
+	buf := make([]byte, 0, len(prefix)+len(namespace)+len(jsonMethod)+len(msg.Args)+24)
+	buf = append(buf, prefix...)
+	buf = append(buf, namespace...)
+	if msg.Type != MessageTypeEmit {
+		buf = strconv.AppendInt(buf, int64(msg.AckId), 10)
+	}
+
+	buf = append(buf, '[')
+	buf = append(buf, jsonMethod...)
+	if len(jsonMethod) > 0 && msg.Args != "" {
+		buf = append(buf, ',')
+	}
+	buf = append(buf, msg.Args...)
+	return append(buf, ']'), nil
+}
+
 func MustEncode(msg *Message) string {
 	result, err := Encode(msg)
 	if err != nil {
@@ -97,6 +190,10 @@ func getMessageType(data string) (int, error) {
 		return MessageTypePing, nil
 	case PongMessage:
 		return MessageTypePong, nil
+	case UpgradeMessage:
+		return MessageTypeUpgrade, nil
+	case NoopMessage:
+		return MessageTypeNoop, nil
 	case msg:
 		if len(data) == 1 {
 			return 0, ErrorWrongMessageType
@@ -104,24 +201,69 @@ func getMessageType(data string) (int, error) {
 		switch data[0:2] {
 		case emptyMessage:
 			return MessageTypeEmpty, nil
+		case disconnectMessage:
+			return MessageTypeDisconnect, nil
 		case commonMessage:
 			return MessageTypeAckRequest, nil
-		case ackMessage:
+		case ackMessage, binaryAckMessage:
 			return MessageTypeAckResponse, nil
+		case errorMessage:
+			return MessageTypeConnectError, nil
+		case binaryMessage:
+			return MessageTypeAckRequest, nil
 		}
 	}
 	return 0, ErrorWrongMessageType
 }
 
 /**
-Get ack id of current packet, if present
+Engine.io control packet, that is not socket.io one
 */
-func getAck(text string) (ackId int, restText string, err error) {
-	if len(text) < 4 {
-		return 0, "", ErrorWrongPacket
+func isEngineIOPacket(msgType int) bool {
+	switch msgType {
+	case MessageTypeClose, MessageTypePing, MessageTypePong,
+		MessageTypeUpgrade, MessageTypeNoop:
+		return true
+	}
+	return false
+}
+
+/**
+Namespace prefix of packet, root namespace is omitted unless it
+should be explicit
+*/
+func encodeNamespace(msg *Message) string {
+	if msg.Namespace == "" || msg.Namespace == RootNamespace {
+		if msg.ExplicitRoot {
+			return RootNamespace + ","
+		}
+		return ""
+	}
+	return msg.Namespace + ","
+}
+
+/**
+Get namespace of current packet, if present, text should go
+after the packet type
+*/
+func getNamespace(text string) (namespace, restText string) {
+	if len(text) == 0 || text[0] != '/' {
+		return "", text
+	}
+
+	pos := strings.IndexByte(text, ',')
+	if pos == -1 {
+		return text, ""
 	}
-	text = text[2:]
 
+	return text[0:pos], text[pos+1:]
+}
+
+/**
+Get ack id of current packet, if present, text should go
+after the packet type and namespace
+*/
+func getAck(text string) (ackId int, restText string, err error) {
 	pos := strings.IndexByte(text, '[')
 	if pos == -1 {
 		return 0, "", ErrorWrongPacket
@@ -185,24 +327,46 @@ func Decode(data string) (*Message, error) {
 		return msg, nil
 	}
 
-	if msg.Type == MessageTypeClose || msg.Type == MessageTypePing ||
-		msg.Type == MessageTypePong || msg.Type == MessageTypeEmpty {
+	if isEngineIOPacket(msg.Type) {
+		msg.Args = data[1:]
+		return msg, nil
+	}
+
+	body := data[2:]
+	if isBinary(data) {
+		msg.Attachments, body, err = getAttachments(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	msg.Namespace, body = getNamespace(body)
+	msg.ExplicitRoot = msg.Namespace == RootNamespace
+	if msg.Type == MessageTypeEmpty || msg.Type == MessageTypeConnectError {
+		msg.Args = body
+		return msg, nil
+	}
+	//disconnect carries nothing but namespace
+	if msg.Type == MessageTypeDisconnect {
 		return msg, nil
 	}
 
-	ack, rest, err := getAck(data)
+	ack, rest, err := getAck(body)
 	msg.AckId = ack
 	if msg.Type == MessageTypeAckResponse {
 		if err != nil {
 			return nil, err
 		}
+		if len(rest) < 2 {
+			return nil, ErrorWrongPacket
+		}
 		msg.Args = rest[1 : len(rest)-1]
 		return msg, nil
 	}
 
 	if err != nil {
 		msg.Type = MessageTypeEmit
-		rest = data[2:]
+		rest = body
 	}
 
 	msg.Method, msg.Args, err = getMethod(rest)
@@ -0,0 +1,119 @@
+package protocol
+
+import (
+	"testing"
+)
+
+func TestEncodeRootNamespace(t *testing.T) {
+	messages := map[string]*Message{
+		"40":            {Type: MessageTypeEmpty},
+		"40/,":          {Type: MessageTypeEmpty, ExplicitRoot: true},
+		`42["x"]`:       {Type: MessageTypeEmit, Namespace: RootNamespace, Method: "x"},
+		`42/,["x"]`:     {Type: MessageTypeEmit, Namespace: RootNamespace, Method: "x", ExplicitRoot: true},
+		`42/chat,["x"]`: {Type: MessageTypeEmit, Namespace: "/chat", Method: "x", ExplicitRoot: true},
+	}
+
+	for expected, msg := range messages {
+		if result := MustEncode(msg); result != expected {
+			t.Fatalf("expected %s, got %s", expected, result)
+		}
+		if result, _ := EncodeBytes(msg); string(result) != expected {
+			t.Fatalf("expected bytes %s, got %s", expected, result)
+		}
+	}
+}
+
+func TestDecodeRootNamespace(t *testing.T) {
+	for _, packet := range []string{"40", "40/,", `42["x"]`, `42/,["x"]`} {
+		msg, err := Decode(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := MustEncode(msg); result != packet {
+			t.Fatalf("%s is encoded back as %s", packet, result)
+		}
+	}
+}
+
+func TestDisconnectPacket(t *testing.T) {
+	messages := map[string]*Message{
+		"41":        {Type: MessageTypeDisconnect},
+		"41/,":      {Type: MessageTypeDisconnect, ExplicitRoot: true},
+		"41/admin,": {Type: MessageTypeDisconnect, Namespace: "/admin"},
+	}
+
+	for packet, expected := range messages {
+		if result := MustEncode(expected); result != packet {
+			t.Fatalf("expected %s, got %s", packet, result)
+		}
+
+		msg, err := Decode(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type != MessageTypeDisconnect {
+			t.Fatalf("%s is decoded as type %d", packet, msg.Type)
+		}
+		namespace := expected.Namespace
+		if expected.ExplicitRoot {
+			namespace = RootNamespace
+		}
+		if msg.Namespace != namespace || msg.Args != "" {
+			t.Fatalf("%s is decoded with namespace %q and args %q", packet, msg.Namespace, msg.Args)
+		}
+	}
+}
+
+func TestEncodeBytesMatchesEncode(t *testing.T) {
+	messages := []*Message{
+		{Type: MessageTypeEmit, Method: "x", Args: `{"a":1}`},
+		{Type: MessageTypeEmit, Method: "x"},
+		{Type: MessageTypeAckRequest, AckId: 12, Method: "x", Args: `[1,2]`},
+		{Type: MessageTypeAckResponse, AckId: 3, Args: `"ok"`},
+		{Type: MessageTypeEmit, Namespace: "/chat", Method: "x", Args: `1`},
+		{Type: MessageTypeEmit, Namespace: RootNamespace, Method: "x", ExplicitRoot: true},
+		{Type: MessageTypeEmit, Method: "x", Args: `{"_placeholder":true,"num":0}`, Attachments: 1},
+		{Type: MessageTypeEmpty, Namespace: "/chat"},
+	}
+	for _, msg := range messages {
+		text, err := Encode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := EncodeBytes(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != text {
+			t.Fatalf("expected %q, got %q", text, data)
+		}
+	}
+}
+
+var benchmarkAck = &Message{
+	Type:   MessageTypeAckRequest,
+	AckId:  1,
+	Method: "event",
+	Args:   `{"id":1,"name":"event","tags":["a","b"]}`,
+}
+
+func BenchmarkEncodeBytes(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := EncodeBytes(benchmarkAck); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+//encoded to string and converted, as emit did before bytes path, for comparison
+func BenchmarkEncodeString(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		text, err := Encode(benchmarkAck)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = []byte(text)
+	}
+}
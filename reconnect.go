@@ -0,0 +1,317 @@
+package gosocketio
+
+import (
+	"context"
+	"github.com/graarh/golang-socketio/transport"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	OnReconnection    = "reconnection"
+	OnReconnectFailed = "reconnect_failed"
+
+	DefaultReconnectInitialInterval = 500 * time.Millisecond
+	DefaultReconnectMaxInterval     = 30 * time.Second
+	DefaultReconnectJitter          = 0.2
+
+	//smaller intervals are raised to it, so failing attempts do not spin
+	MinReconnectInterval = 10 * time.Millisecond
+)
+
+/**
+Reconnection backoff params, interval is doubled after every failed
+attempt up to max interval, jitter is random fraction added or
+subtracted from each interval, zero max attempts means unlimited,
+intervals are at least MinReconnectInterval
+*/
+type ReconnectParams struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Jitter          float64
+	MaxAttempts     int
+}
+
+/**
+Returns reconnect params with default values and unlimited attempts
+*/
+func GetDefaultReconnectParams() ReconnectParams {
+	return ReconnectParams{
+		InitialInterval: DefaultReconnectInitialInterval,
+		MaxInterval:     DefaultReconnectMaxInterval,
+		Jitter:          DefaultReconnectJitter,
+	}
+}
+
+/**
+Socket.io client, that reconnects with exponential backoff on connection loss
+
+Handlers are registered once and kept for every new connection,
+namespaces connected with Of are connected again as well,
+OnReconnection event occurs when connection is established again,
+OnReconnectFailed when max attempts are made without success
+*/
+type ReconnectingClient struct {
+	methods
+
+	url    string
+	tr     transport.Transport
+	params ReconnectParams
+
+	channel     *Channel
+	channelLock sync.RWMutex
+
+	//nanoseconds, default ack timeout applied to every connection
+	ackTimeout int64
+	//limit of pending acks applied to every connection
+	maxPendingAcks int64
+	//1 if numbers of every connection are decoded as json.Number
+	useNumber int32
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+/**
+Connect to host with automatic reconnection, initial connection
+is not retried, its error is returned as is
+*/
+func DialReconnecting(url string, tr transport.Transport,
+	params ReconnectParams) (*ReconnectingClient, error) {
+
+	rc := &ReconnectingClient{
+		url:    url,
+		tr:     tr,
+		params: params,
+		closed: make(chan struct{}),
+	}
+	rc.initMethods()
+	rc.onDisconnection = rc.onDisconnect
+
+	if err := rc.connect(); err != nil {
+		return nil, err
+	}
+
+	return rc, nil
+}
+
+/**
+Create new channel and connect it
+*/
+func (rc *ReconnectingClient) connect() error {
+	c := &Channel{}
+	url := rc.url
+
+	//sequenced connection is continued, server replays missed packets
+	//if it keeps them
+	if prev := rc.Channel(); prev != nil && prev.isSequenced() {
+		var err error
+		url, err = getReplayUrl(rc.url, prev)
+		if err != nil {
+			return err
+		}
+		c.sequencing = 1
+		c.lastSeq = prev.LastSeq()
+	}
+	//server restores session of previous connection if it keeps it
+	tr := rc.tr
+	if prev := rc.Channel(); prev != nil && prev.resumeToken() != "" {
+		tr = resumeTransport(tr, prev.resumeToken())
+	}
+
+	if err := connectChannel(context.Background(), c, &rc.methods, url, tr); err != nil {
+		return err
+	}
+	for _, namespace := range rc.namespaceNames() {
+		c.Of(namespace)
+	}
+	c.SetDefaultAckTimeout(time.Duration(atomic.LoadInt64(&rc.ackTimeout)))
+	c.SetMaxPendingAcks(int(atomic.LoadInt64(&rc.maxPendingAcks)))
+	atomic.StoreInt32(&c.useNumber, atomic.LoadInt32(&rc.useNumber))
+
+	rc.channelLock.Lock()
+	rc.channel = c
+	rc.channelLock.Unlock()
+
+	return nil
+}
+
+/**
+Get channel of current connection
+*/
+func (rc *ReconnectingClient) Channel() *Channel {
+	rc.channelLock.RLock()
+	defer rc.channelLock.RUnlock()
+
+	return rc.channel
+}
+
+/**
+Id of current connection, it changes on reconnect
+*/
+func (rc *ReconnectingClient) Id() string {
+	return rc.Channel().Id()
+}
+
+/**
+Checks that client is connected at the moment
+*/
+func (rc *ReconnectingClient) IsAlive() bool {
+	return rc.Channel().IsAlive()
+}
+
+/**
+Get handlers of given namespace and connect to it, the namespace
+is connected again after every reconnect
+*/
+func (rc *ReconnectingClient) Of(namespace string) *Namespace {
+	n := rc.of(namespace, nil)
+	rc.Channel().Of(namespace)
+
+	return n
+}
+
+/**
+Emit using current connection
+*/
+func (rc *ReconnectingClient) Emit(method string, args interface{}) error {
+	c := rc.Channel()
+	if !c.IsAlive() {
+		return ErrorNotConnected
+	}
+
+	return c.Emit(method, args)
+}
+
+/**
+Emit with given options using current connection, see Channel.EmitWithOptions
+*/
+func (rc *ReconnectingClient) EmitWithOptions(method string, args interface{}, opts ...EmitOpt) error {
+	c := rc.Channel()
+	if !c.IsAlive() {
+		return ErrorNotConnected
+	}
+
+	return c.EmitWithOptions(method, args, opts...)
+}
+
+/**
+Set timeout of AckDefault, it is kept for new connections
+*/
+func (rc *ReconnectingClient) SetDefaultAckTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&rc.ackTimeout, int64(timeout))
+	rc.Channel().SetDefaultAckTimeout(timeout)
+}
+
+/**
+Limit number of pending acks, it is kept for new connections
+*/
+func (rc *ReconnectingClient) SetMaxPendingAcks(n int) {
+	atomic.StoreInt64(&rc.maxPendingAcks, int64(n))
+	rc.Channel().SetMaxPendingAcks(n)
+}
+
+/**
+Ack using current connection, see Channel.Ack
+*/
+func (rc *ReconnectingClient) Ack(method string, args interface{}, timeout time.Duration) (string, error) {
+	c := rc.Channel()
+	if !c.IsAlive() {
+		return "", ErrorNotConnected
+	}
+
+	return c.Ack(method, args, timeout)
+}
+
+/**
+Ack using current connection with its default timeout, see Channel.AckDefault
+*/
+func (rc *ReconnectingClient) AckDefault(method string, args interface{}) (string, error) {
+	c := rc.Channel()
+	if !c.IsAlive() {
+		return "", ErrorNotConnected
+	}
+
+	return c.AckDefault(method, args)
+}
+
+/**
+Close connection and stop reconnecting
+*/
+func (rc *ReconnectingClient) Close() {
+	rc.closeOnce.Do(func() {
+		close(rc.closed)
+	})
+
+	closeChannel(rc.Channel(), &rc.methods)
+}
+
+/**
+On disconnection system handler, start reconnecting unless closed
+*/
+func (rc *ReconnectingClient) onDisconnect(c *Channel) {
+	select {
+	case <-rc.closed:
+	default:
+		go rc.reconnect()
+	}
+}
+
+/**
+Try to connect again with exponential backoff
+*/
+func (rc *ReconnectingClient) reconnect() {
+	interval := rc.params.InitialInterval
+	if interval < MinReconnectInterval {
+		interval = MinReconnectInterval
+	}
+	for attempt := 1; rc.params.MaxAttempts == 0 || attempt <= rc.params.MaxAttempts; attempt++ {
+		select {
+		case <-rc.closed:
+			return
+		case <-time.After(jitter(interval, rc.params.Jitter)):
+		}
+
+		if err := rc.connect(); err == nil {
+			c := rc.Channel()
+			select {
+			case <-rc.closed:
+				//closed while connecting
+				closeChannel(c, &rc.methods)
+			case <-c.Context().Done():
+				//closed before server confirmed connect, disconnection
+				//handler reconnects again
+			case <-c.connected:
+				rc.callLoopEvent(c, OnReconnection)
+			}
+			return
+		}
+
+		interval *= 2
+		if rc.params.MaxInterval > 0 && interval > rc.params.MaxInterval {
+			interval = rc.params.MaxInterval
+		}
+		if interval < MinReconnectInterval {
+			interval = MinReconnectInterval
+		}
+	}
+
+	select {
+	case <-rc.closed:
+	default:
+		rc.callLoopEvent(rc.Channel(), OnReconnectFailed)
+	}
+}
+
+/**
+Randomly change interval by given fraction of it
+*/
+func jitter(interval time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return interval
+	}
+
+	return interval + time.Duration((rand.Float64()*2-1)*fraction*float64(interval))
+}
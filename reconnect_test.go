@@ -0,0 +1,105 @@
+package gosocketio
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/graarh/golang-socketio/transport"
+)
+
+func TestReconnectRejoinsNamespaces(t *testing.T) {
+	s, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	var joined int32
+	s.Of("/chat").On(OnConnection, func(c *Channel) {
+		atomic.AddInt32(&joined, 1)
+	})
+
+	params := GetDefaultReconnectParams()
+	params.InitialInterval = 0
+	rc, err := DialReconnecting(url, transport.GetDefaultWebsocketTransport(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	var reconnected int32
+	rc.On(OnReconnection, func(c *Channel) {
+		atomic.AddInt32(&reconnected, 1)
+	})
+	rc.Of("/chat")
+	waitFor(t, "namespace connect", func() bool { return atomic.LoadInt32(&joined) == 1 })
+
+	first := rc.Channel()
+	for _, c := range s.listAll() {
+		c.Close()
+	}
+	waitFor(t, "reconnect", func() bool { return atomic.LoadInt32(&reconnected) == 1 })
+	if rc.Channel() == first {
+		t.Fatal("channel is not replaced")
+	}
+	waitFor(t, "namespace rejoin", func() bool { return atomic.LoadInt32(&joined) == 2 })
+}
+
+func TestReconnectFailed(t *testing.T) {
+	s := NewServer(transport.GetDefaultWebsocketTransport())
+	httpServer := httptest.NewServer(s)
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http") + socketioUrl
+
+	params := ReconnectParams{MaxAttempts: 3}
+	rc, err := DialReconnecting(url, transport.GetDefaultWebsocketTransport(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	failed := make(chan struct{})
+	rc.On(OnReconnectFailed, func(c *Channel) {
+		close(failed)
+	})
+
+	httpServer.Close()
+	for _, c := range s.listAll() {
+		c.Close()
+	}
+
+	//zero intervals are raised to the minimal one, attempts do not spin
+	start := time.Now()
+	select {
+	case <-failed:
+	case <-time.After(time.Second):
+		t.Fatal("reconnect failure is not reported")
+	}
+	if elapsed := time.Since(start); elapsed < 3*MinReconnectInterval {
+		t.Fatalf("attempts are made too fast, in %v", elapsed)
+	}
+}
+
+func TestReconnectResumesSession(t *testing.T) {
+	s, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	s.EnableSessionResume([]byte("secret"))
+	var resumed int32
+	s.On(OnConnection, func(c *Channel) {
+		if c.Resumed() {
+			atomic.AddInt32(&resumed, 1)
+		}
+	})
+
+	params := GetDefaultReconnectParams()
+	params.InitialInterval = 0
+	rc, err := DialReconnecting(url, transport.GetDefaultWebsocketTransport(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	first := rc.Channel()
+	waitFor(t, "open packet", func() bool { return first.resumeToken() != "" })
+	sid := first.Id()
+	//connection is lost, not closed by server, so its session is kept
+	first.conn.Close()
+	waitFor(t, "resumed session", func() bool { return atomic.LoadInt32(&resumed) == 1 })
+	if id := rc.Channel().Id(); id != sid {
+		t.Fatalf("expected resumed sid %q, got %q", sid, id)
+	}
+}
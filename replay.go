@@ -0,0 +1,288 @@
+package gosocketio
+
+import (
+	neturl "net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	//handshake query of reconnecting client, last sequence number received
+	//over previous connection, which is proved by its resume token
+	replaySeqParam = "replay_seq"
+
+	//buffer of closed connection is kept for reconnect during this time
+	DefaultReplayRetention = time.Minute
+)
+
+/**
+Option of emitted event, see Channel.EmitWithOptions
+*/
+type EmitOpt func(o *emitOptions)
+
+type emitOptions struct {
+	//packet is not kept in replay buffer
+	noReplay bool
+
+	//packet is evicted from replay buffer after this time, zero keeps
+	//it till newer packets evict it
+	replayTTL time.Duration
+
+	//packet is broadcasted, so it never waits for free place in queue
+	broadcast bool
+
+	//compression override of packet, see Compression
+	compress int
+}
+
+/**
+Do not keep event for replay, like ephemeral typing indicator,
+it is still sequenced
+*/
+func NoReplay() EmitOpt {
+	return func(o *emitOptions) {
+		o.noReplay = true
+	}
+}
+
+/**
+Evict event from replay buffer after given time, even if the buffer
+is not full, so stale events are not replayed
+*/
+func ReplayTTL(ttl time.Duration) EmitOpt {
+	return func(o *emitOptions) {
+		o.replayTTL = ttl
+	}
+}
+
+/**
+Send event compressed or not regardless of transport threshold, it is
+sent as usual if compression is not negotiated
+*/
+func Compression(on bool) EmitOpt {
+	return func(o *emitOptions) {
+		if on {
+			o.compress = compressOn
+		} else {
+			o.compress = compressOff
+		}
+	}
+}
+
+func getEmitOptions(opts []EmitOpt) emitOptions {
+	var o emitOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+/**
+Sequenced packet kept for replay, zero expiry time means no expiry
+*/
+type replayedPacket struct {
+	seq     uint64
+	packet  outPacket
+	expires time.Time
+}
+
+/**
+Last sent sequenced packets of connection, oldest ones are evicted,
+so at most size packets are kept
+*/
+type replayBuffer struct {
+	size int
+
+	//ring of kept packets, count of them starting from the oldest one
+	packets []replayedPacket
+	start   int
+	count   int
+
+	//last sequence number sent, set when connection is closed
+	seq uint64
+
+	lock sync.Mutex
+}
+
+func (b *replayBuffer) add(seq uint64, packet outPacket, ttl time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	if b.packets == nil {
+		b.packets = make([]replayedPacket, b.size)
+	}
+	//expired packets behind the oldest one are skipped by since
+	for b.count > 0 && b.at(0).expired(now) {
+		b.evictOldest()
+	}
+	if b.count == b.size {
+		b.evictOldest()
+	}
+
+	p := replayedPacket{seq: seq, packet: packet}
+	if ttl > 0 {
+		p.expires = now.Add(ttl)
+	}
+	*b.at(b.count) = p
+	b.count++
+}
+
+/**
+Get i-th kept packet counting from the oldest one, called under lock
+*/
+func (b *replayBuffer) at(i int) *replayedPacket {
+	return &b.packets[(b.start+i)%len(b.packets)]
+}
+
+/**
+Remove the oldest kept packet, called under lock
+*/
+func (b *replayBuffer) evictOldest() {
+	*b.at(0) = replayedPacket{}
+	b.start = (b.start + 1) % len(b.packets)
+	b.count--
+}
+
+func (p *replayedPacket) expired(now time.Time) bool {
+	return !p.expires.IsZero() && !now.Before(p.expires)
+}
+
+/**
+Get kept packets with sequence numbers after given one, packets
+already evicted or expired are lost
+*/
+func (b *replayBuffer) since(seq uint64) []outPacket {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	var packets []outPacket
+	for i := 0; i < b.count; i++ {
+		if p := b.at(i); p.seq > seq && !p.expired(now) {
+			packets = append(packets, p.packet)
+		}
+	}
+	return packets
+}
+
+/**
+Keep last size sequenced packets of every connection, so reconnecting
+client could get packets it missed, zero turns it off, applied to new
+connections. Buffer of closed connection is kept for DefaultReplayRetention.
+ReconnectingClient with sequencing on requests replay itself. Client proves
+previous connection by its resume token, so replay needs session resume
+turned on by EnableSessionResume.
+*/
+func (s *Server) EnableReplayBuffer(size int) {
+	s.replaysLock.Lock()
+	defer s.replaysLock.Unlock()
+
+	s.replaySize = size
+}
+
+/**
+Get replay buffer of new connection, nil if replay is off
+*/
+func (s *Server) newReplayBuffer() *replayBuffer {
+	s.replaysLock.Lock()
+	defer s.replaysLock.Unlock()
+
+	if s.replaySize <= 0 {
+		return nil
+	}
+	return &replayBuffer{size: s.replaySize}
+}
+
+/**
+Keep replay buffer of closed connection for retention time
+*/
+func (s *Server) keepReplay(c *Channel) {
+	if c.replay == nil || !c.isSequenced() {
+		return
+	}
+
+	c.seqLock.Lock()
+	c.replay.seq = c.seq
+	c.seqLock.Unlock()
+
+	sid := c.Id()
+	s.replaysLock.Lock()
+	s.replays[sid] = c.replay
+	s.replaysLock.Unlock()
+
+	time.AfterFunc(DefaultReplayRetention, func() {
+		s.replaysLock.Lock()
+		defer s.replaysLock.Unlock()
+
+		if s.replays[sid] == c.replay {
+			delete(s.replays, sid)
+		}
+	})
+}
+
+/**
+Continue previous connection of client if it requested replay with resume
+token of it and its buffer is kept: sequencing is turned on, numbering is
+continued and missed packets are prepared for sending
+*/
+func (s *Server) resumeReplay(c *Channel, query neturl.Values, token string) {
+	secret := s.getResumeSecret()
+	if secret == nil || c.replay == nil {
+		return
+	}
+	sid, ok := checkResumeToken(secret, token)
+	if !ok {
+		return
+	}
+	seq, err := strconv.ParseUint(query.Get(replaySeqParam), 10, 64)
+	if err != nil {
+		return
+	}
+
+	s.replaysLock.Lock()
+	buffer, ok := s.replays[sid]
+	delete(s.replays, sid)
+	s.replaysLock.Unlock()
+	if !ok {
+		return
+	}
+
+	c.replay = buffer
+	c.seq = buffer.seq
+	c.sequencing = 1
+	c.pendingReplay = buffer.since(seq)
+}
+
+/**
+Send missed packets of resumed connection, called once root namespace
+is connected, so before any new packet of it
+*/
+func (c *Channel) flushReplay() {
+	c.seqLock.Lock()
+	packets := c.pendingReplay
+	c.pendingReplay = nil
+	c.seqLock.Unlock()
+
+	for _, packet := range packets {
+		c.enqueue(packet)
+	}
+}
+
+/**
+Add replay request of previous connection to client url, the connection
+is identified by its resume token, see resumeTransport
+*/
+func getReplayUrl(url string, prev *Channel) (string, error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set(replaySeqParam, strconv.FormatUint(prev.LastSeq(), 10))
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
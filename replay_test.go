@@ -0,0 +1,134 @@
+package gosocketio
+
+import (
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graarh/golang-socketio/transport"
+)
+
+func replaySeqs(packets []outPacket) []string {
+	var seqs []string
+	for _, p := range packets {
+		seqs = append(seqs, string(p.data))
+	}
+	return seqs
+}
+
+func fillReplayBuffer(b *replayBuffer, from, to int, ttl time.Duration) {
+	for i := from; i <= to; i++ {
+		b.add(uint64(i), outPacket{data: []byte(strconv.Itoa(i))}, ttl)
+	}
+}
+
+func TestReplayBufferEvictsOldest(t *testing.T) {
+	b := &replayBuffer{size: 3}
+	fillReplayBuffer(b, 1, 5, 0)
+
+	if got := replaySeqs(b.since(0)); len(got) != 3 || got[0] != "3" || got[2] != "5" {
+		t.Fatalf("expected packets 3..5, got %v", got)
+	}
+	if got := replaySeqs(b.since(4)); len(got) != 1 || got[0] != "5" {
+		t.Fatalf("expected packet 5, got %v", got)
+	}
+}
+
+func TestReplayBufferExpires(t *testing.T) {
+	b := &replayBuffer{size: 4}
+	fillReplayBuffer(b, 1, 1, 0)
+	fillReplayBuffer(b, 2, 3, time.Millisecond)
+	fillReplayBuffer(b, 4, 4, 0)
+	time.Sleep(5 * time.Millisecond)
+
+	//expired packets are skipped
+	if got := replaySeqs(b.since(0)); len(got) != 2 || got[0] != "1" || got[1] != "4" {
+		t.Fatalf("expected packets 1 and 4, got %v", got)
+	}
+
+	//expired packets behind the oldest one still take place
+	fillReplayBuffer(b, 5, 5, 0)
+	if got := replaySeqs(b.since(0)); len(got) != 2 || got[0] != "4" || got[1] != "5" {
+		t.Fatalf("expected packets 4 and 5, got %v", got)
+	}
+
+	//and are evicted once they are the oldest ones
+	fillReplayBuffer(b, 6, 6, 0)
+	if b.count != 3 {
+		t.Fatalf("expected expired packets to be evicted, %d are kept", b.count)
+	}
+	if got := replaySeqs(b.since(0)); len(got) != 3 || got[0] != "4" || got[2] != "6" {
+		t.Fatalf("expected packets 4..6, got %v", got)
+	}
+}
+
+func TestResumeReplayNeedsToken(t *testing.T) {
+	secret := []byte("secret")
+	s := NewServer(transport.GetDefaultWebsocketTransport())
+	s.EnableSessionResume(secret)
+
+	kept := &replayBuffer{size: 3, seq: 2}
+	fillReplayBuffer(kept, 1, 2, 0)
+	s.replays["prev"] = kept
+
+	expires := time.Now().Add(time.Minute)
+	tokens := []string{"", "prev", "prev.forged", resumeToken([]byte("other"), "prev", expires),
+		resumeToken(secret, "prev", time.Now().Add(-time.Minute))}
+	for _, token := range tokens {
+		c := &Channel{replay: &replayBuffer{size: 3}}
+		s.resumeReplay(c, neturl.Values{replaySeqParam: {"0"}}, token)
+		if c.replay == kept || len(c.pendingReplay) != 0 {
+			t.Fatalf("replay is resumed with token %q", token)
+		}
+	}
+
+	c := &Channel{replay: &replayBuffer{size: 3}}
+	token := resumeToken(secret, "prev", expires)
+	s.resumeReplay(c, neturl.Values{replaySeqParam: {"1"}}, token)
+	if c.replay != kept || c.seq != 2 {
+		t.Fatal("replay is not resumed with valid token")
+	}
+	if got := replaySeqs(c.pendingReplay); len(got) != 1 || got[0] != "2" {
+		t.Fatalf("expected packet 2 to be replayed, got %v", got)
+	}
+}
+
+func TestEmitWithOptions(t *testing.T) {
+	s := NewServer(transport.GetDefaultWebsocketTransport())
+	c := newQueueChannel("sid", 10, OverflowDropNewest)
+	c.replay = &replayBuffer{size: 10}
+	c.SetSequencing(true)
+	s.sids[c.Id()] = c
+
+	//plain Emit keeps its method value form
+	var emit func(method string, args interface{}) error = c.Emit
+	if err := emit("kept", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.EmitWithOptions("typing", nil, NoReplay()); err != nil {
+		t.Fatal(err)
+	}
+	s.BroadcastToAllWithOptions("news", nil, NoReplay(), Compression(true))
+	s.BroadcastToAll("broadcast", nil)
+
+	if n := len(c.out); n != 4 {
+		t.Fatalf("expected 4 queued packets, got %d", n)
+	}
+	got := c.replay.since(0)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 packets kept for replay, got %d", len(got))
+	}
+	for i, method := range []string{"kept", "broadcast"} {
+		if !strings.Contains(string(got[i].data), method) {
+			t.Fatalf("expected %s to be kept, got %s", method, got[i].data)
+		}
+	}
+
+	<-c.out
+	<-c.out
+	if news := <-c.out; news.compress != compressOn {
+		t.Fatal("broadcast option is not applied")
+	}
+}
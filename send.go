@@ -1,48 +1,136 @@
 package gosocketio
 
 import (
-	"encoding/json"
 	"errors"
 	"github.com/graarh/golang-socketio/protocol"
-	"log"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	//compression of packet, by transport settings or forced
+	compressDefault = iota
+	compressOn
+	compressOff
+)
+
 var (
 	ErrorSendTimeout     = errors.New("Timeout")
 	ErrorSocketOverflood = errors.New("Socket overflood")
+	ErrorNotConnected    = errors.New("Not connected")
 )
 
+/**
+Set function called on failed write of packet, returning true retries
+the write once, with write deadline set anew, connection is closed if the
+function returns false or the retry fails too. Note that websocket
+connection is unusable after timed out write, so retry is mostly useful
+for transports like polling. Writes are not retried while connection
+coalesces them, as failed write could belong to frames of other packets.
+*/
+func (c *Channel) OnWriteError(f func(err error) bool) {
+	if c.root != nil {
+		c.root.OnWriteError(f)
+		return
+	}
+
+	c.onWriteErrorLock.Lock()
+	defer c.onWriteErrorLock.Unlock()
+	c.onWriteError = f
+}
+
+/**
+Call write, and retry it once if write error handler allows it,
+called by outgoing loop
+*/
+func (c *Channel) retryWrite(write func() error) error {
+	err := write()
+	if err == nil {
+		return nil
+	}
+	if cc, _ := coalescingConnection(c.conn); cc != nil {
+		//error of coalesced write is shared by the whole batch
+		return err
+	}
+
+	c.onWriteErrorLock.RLock()
+	f := c.onWriteError
+	c.onWriteErrorLock.RUnlock()
+
+	if f == nil || !f(err) {
+		return err
+	}
+	c.logger().Debug("write retried", "sid", c.Id(), "err", err)
+	return write()
+}
+
 /**
 Send message packet to socket
 */
 func send(msg *protocol.Message, c *Channel, args interface{}) error {
+	return sendPacket(msg, c, args, compressDefault, emitOptions{})
+}
+
+/**
+Send message packet to socket with given compression and emit options
+*/
+func sendPacket(msg *protocol.Message, c *Channel, args interface{}, compress int, opts emitOptions) error {
 	//preventing json/encoding "index out of range" panic
 	defer func() {
 		if r := recover(); r != nil {
-			log.Println("socket.io send panic: ", r)
+			c.logger().Error("send panic", "sid", c.Id(), "panic", r)
 		}
 	}()
 
-	if args != nil {
-		json, err := json.Marshal(&args)
-		if err != nil {
-			return err
+	//fail fast instead of queueing to connection which is not open
+	if c.State() != StateOpen {
+		return ErrorNotConnected
+	}
+
+	if err := encodeArgs(msg, c, args); err != nil {
+		return err
+	}
+	result, err := c.interceptOutbound(msg)
+	if result == nil {
+		if err == nil && msg.Type == protocol.MessageTypeAckRequest {
+			//nobody answers dropped request
+			return ErrorDropped
 		}
+		return err
+	}
+	msg = result
 
-		msg.Args = string(json)
+	if c.isSequenced() && (msg.Type == protocol.MessageTypeEmit ||
+		msg.Type == protocol.MessageTypeAckRequest) {
+		return sendSequenced(msg, c, compress, opts)
 	}
 
-	command, err := protocol.Encode(msg)
+	command, err := protocol.EncodeBytes(msg)
 	if err != nil {
 		return err
 	}
 
-	if len(c.out) == queueBufferSize {
-		return ErrorSocketOverflood
+	packet := outPacket{data: command, attachments: msg.Buffers, compress: compress}
+	return c.enqueueWith(packet, c.overflowPolicyOf(opts))
+}
+
+/**
+Set message arguments, as json or binary attachment if codec is negotiated
+*/
+func encodeArgs(msg *protocol.Message, c *Channel, args interface{}) error {
+	if args == nil {
+		return nil
 	}
 
-	c.out <- command
+	if codec := c.getBinaryCodec(); codec != nil {
+		return encodeBinaryArgs(msg, codec, args)
+	}
+
+	json, err := protocol.Marshal(&args)
+	if err != nil {
+		return err
+	}
+	msg.Args = string(json)
 
 	return nil
 }
@@ -51,37 +139,200 @@ func send(msg *protocol.Message, c *Channel, args interface{}) error {
 Create packet based on given data and send it
 */
 func (c *Channel) Emit(method string, args interface{}) error {
+	return c.emitWith(method, args, emitOptions{})
+}
+
+/**
+Create packet and send it with given options, like how the event is kept
+for replay or compressed, see NoReplay, ReplayTTL and Compression
+*/
+func (c *Channel) EmitWithOptions(method string, args interface{}, opts ...EmitOpt) error {
+	return c.emitWith(method, args, getEmitOptions(opts))
+}
+
+func (c *Channel) emitWith(method string, args interface{}, opts emitOptions) error {
 	msg := &protocol.Message{
-		Type:   protocol.MessageTypeEmit,
-		Method: method,
+		Type:         protocol.MessageTypeEmit,
+		Namespace:    c.namespace,
+		ExplicitRoot: c.explicitRoot(),
+		Method:       method,
 	}
 
-	return send(msg, c, args)
+	return sendPacket(msg, c, args, opts.compress, opts)
+}
+
+/**
+Create packet and send it compressed regardless of transport threshold,
+it is sent as usual if compression is not negotiated
+*/
+func (c *Channel) EmitCompressed(method string, args interface{}) error {
+	return c.EmitWithOptions(method, args, Compression(true))
+}
+
+/**
+Create packet and send it without compression regardless of transport threshold
+*/
+func (c *Channel) EmitUncompressed(method string, args interface{}) error {
+	return c.EmitWithOptions(method, args, Compression(false))
+}
+
+/**
+Create packet and send it only if connection is writable right now,
+unlike Emit it never blocks or fails, packet is silently dropped if
+connection is not open or previous packets are still queued,
+volatile packets are not sequenced and do not trigger overflow
+*/
+func (c *Channel) EmitVolatile(method string, args interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.logger().Error("send panic", "sid", c.Id(), "panic", r)
+		}
+	}()
+
+	if c.State() != StateOpen || len(c.out) > 0 {
+		return
+	}
+
+	msg := &protocol.Message{
+		Type:         protocol.MessageTypeEmit,
+		Namespace:    c.namespace,
+		ExplicitRoot: c.explicitRoot(),
+		Method:       method,
+	}
+	if err := encodeArgs(msg, c, args); err != nil {
+		return
+	}
+	msg, _ = c.interceptOutbound(msg)
+	if msg == nil {
+		return
+	}
+	command, err := protocol.EncodeBytes(msg)
+	if err != nil {
+		return
+	}
+
+	select {
+	case c.out <- outPacket{data: command, attachments: msg.Buffers}:
+	default:
+	}
 }
 
 /**
 Create ack packet based on given data and send it and receive response
+within given timeout, ErrorSendTimeout is returned at once for zero one,
+see AckTimeout to wait till connection is closed
 */
 func (c *Channel) Ack(method string, args interface{}, timeout time.Duration) (string, error) {
+	return c.AckTimeout(method, args, exactTimeout(timeout))
+}
+
+/**
+Create ack packet and receive response within timeout set by
+SetDefaultAckTimeout, zero default means waiting till connection is closed
+*/
+func (c *Channel) AckDefault(method string, args interface{}) (string, error) {
+	return c.AckTimeout(method, args, c.ack.getDefaultTimeout())
+}
+
+/**
+Create ack packet and receive response within given timeout,
+zero one means waiting till connection is closed
+*/
+func (c *Channel) AckTimeout(method string, args interface{}, timeout time.Duration) (string, error) {
+	response, err := c.ackResponse(method, args, timeout)
+	if err != nil {
+		return "", err
+	}
+	return c.ackResult(response), nil
+}
+
+/**
+Send ack packet and receive response packet within given timeout
+*/
+func (c *Channel) ackResponse(method string, args interface{}, timeout time.Duration) (*protocol.Message, error) {
 	msg := &protocol.Message{
-		Type:   protocol.MessageTypeAckRequest,
-		AckId:  c.ack.getNextId(),
-		Method: method,
+		Type:         protocol.MessageTypeAckRequest,
+		AckId:        c.ack.getNextId(),
+		Namespace:    c.namespace,
+		ExplicitRoot: c.explicitRoot(),
+		Method:       method,
 	}
 
-	waiter := make(chan string)
-	c.ack.addWaiter(msg.AckId, waiter)
+	//buffered, so late response is not blocked on removed waiter
+	waiter := make(chan *protocol.Message, 1)
+	if err := c.ack.addWaiter(msg.AckId, waiter); err != nil {
+		return nil, err
+	}
+	defer c.ack.removeWaiter(msg.AckId)
+
+	if st := c.stats(); st != nil {
+		atomic.AddInt64(&st.acksPending, 1)
+		defer atomic.AddInt64(&st.acksPending, -1)
+	}
 
 	err := send(msg, c, args)
 	if err != nil {
-		c.ack.removeWaiter(msg.AckId)
+		return nil, err
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case response := <-waiter:
+		return response, nil
+	case <-expired:
+		return nil, ErrorSendTimeout
+	case <-c.ack.closed:
+		return nil, ErrorNotConnected
+	}
+}
+
+/**
+Wait until packets queued so far are written to connection by outgoing loop,
+ErrorSendTimeout is returned if it takes longer than given timeout, zero one
+means waiting till connection is closed, ErrorNotConnected is returned if
+connection is closed before. Packets queued during the wait are not waited for,
+neither are packets dropped by OverflowDropOldest before they are written.
+*/
+func (c *Channel) Flush(timeout time.Duration) error {
+	if c.State() != StateOpen {
+		return ErrorNotConnected
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	//marker goes through the queue in order, so it is not subject to overflow policy
+	flushed := make(chan struct{})
+	select {
+	case c.out <- outPacket{flushed: flushed}:
+	case <-expired:
+		return ErrorSendTimeout
+	case <-c.Context().Done():
+		return ErrorNotConnected
 	}
 
 	select {
-	case result := <-waiter:
-		return result, nil
-	case <-time.After(timeout):
-		c.ack.removeWaiter(msg.AckId)
-		return "", ErrorSendTimeout
+	case <-flushed:
+		return nil
+	case <-expired:
+		return ErrorSendTimeout
+	case <-c.Context().Done():
+		//queue could be written right before closing
+		select {
+		case <-flushed:
+			return nil
+		default:
+			return ErrorNotConnected
+		}
 	}
 }
@@ -0,0 +1,129 @@
+package gosocketio
+
+import (
+	"encoding/json"
+	"github.com/graarh/golang-socketio/protocol"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	sequencePrefix = `{"seq":`
+)
+
+/**
+Envelope of sequenced event arguments
+*/
+type sequenced struct {
+	Seq  uint64          `json:"seq"`
+	Data json.RawMessage `json:"data"`
+}
+
+/**
+Turn sequence numbers of events on or off, both peers should agree on it.
+Outgoing events and acks requests get increasing sequence number,
+incoming ones are unwrapped and LastSeq is updated.
+*/
+func (c *Channel) SetSequencing(enabled bool) {
+	if c.root != nil {
+		c.root.SetSequencing(enabled)
+		return
+	}
+
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&c.sequencing, value)
+}
+
+func (c *Channel) isSequenced() bool {
+	if c.root != nil {
+		return c.root.isSequenced()
+	}
+	return atomic.LoadInt32(&c.sequencing) == 1
+}
+
+/**
+Get sequence number of last received event, zero if none
+*/
+func (c *Channel) LastSeq() uint64 {
+	if c.root != nil {
+		return c.root.LastSeq()
+	}
+
+	c.seqLock.Lock()
+	defer c.seqLock.Unlock()
+	return c.lastSeq
+}
+
+/**
+Number and queue message, under lock so packets are queued in order of numbers
+*/
+func sendSequenced(msg *protocol.Message, c *Channel, compress int, opts emitOptions) error {
+	root := c
+	if c.root != nil {
+		root = c.root
+	}
+
+	root.seqLock.Lock()
+	defer root.seqLock.Unlock()
+
+	seq := root.seq + 1
+	wrapSequence(msg, seq)
+
+	command, err := protocol.EncodeBytes(msg)
+	if err != nil {
+		return err
+	}
+
+	packet := outPacket{data: command, attachments: msg.Buffers, compress: compress}
+	if err := c.enqueueWith(packet, c.overflowPolicyOf(opts)); err != nil {
+		return err
+	}
+	root.seq = seq
+	if root.replay != nil && !opts.noReplay {
+		root.replay.add(seq, packet, opts.replayTTL)
+	}
+
+	return nil
+}
+
+/**
+Put arguments of message to envelope with given sequence number
+*/
+func wrapSequence(msg *protocol.Message, seq uint64) {
+	args := sequencePrefix + strconv.FormatUint(seq, 10)
+	if msg.Args != "" {
+		args += `,"data":` + msg.Args
+	}
+	msg.Args = args + "}"
+}
+
+/**
+Take sequence number from incoming event and restore its arguments,
+called by incoming loop, so numbers are seen in order of receiving
+*/
+func (c *Channel) unwrapSequence(msg *protocol.Message) {
+	if msg.Type != protocol.MessageTypeEmit && msg.Type != protocol.MessageTypeAckRequest {
+		return
+	}
+	if !c.isSequenced() || !strings.HasPrefix(msg.Args, sequencePrefix) {
+		return
+	}
+
+	var envelope sequenced
+	if err := protocol.Unmarshal([]byte(msg.Args), &envelope); err != nil {
+		return
+	}
+	msg.Args = string(envelope.Data)
+
+	root := c
+	if c.root != nil {
+		root = c.root
+	}
+	root.seqLock.Lock()
+	root.lastSeq = envelope.Seq
+	root.seqLock.Unlock()
+}
@@ -2,21 +2,27 @@ package gosocketio
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/graarh/golang-socketio/protocol"
 	"github.com/graarh/golang-socketio/transport"
 	"math/rand"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	HeaderForward = "X-Forwarded-For"
+
+	//max payload announced to engine.io v4 clients, same as socket.io default
+	maxPayload = 1000000
 )
 
 var (
@@ -29,6 +35,9 @@ socket.io server instance
 */
 type Server struct {
 	methods
+
+	//Deprecated: not used, Server implements http.Handler itself, the field
+	//is kept for code setting it
 	http.Handler
 
 	channels     map[string]map[*Channel]struct{}
@@ -38,15 +47,76 @@ type Server struct {
 	sids     map[string]*Channel
 	sidsLock sync.RWMutex
 
+	middlewares     []Middleware
+	middlewaresLock sync.RWMutex
+
+	authHandler  AuthHandler
+	panicHandler PanicHandler
+
+	disconnectHandlers     []func(c *Channel, reason DisconnectReason)
+	disconnectHandlersLock sync.RWMutex
+
+	sidCookie sidCookieConfig
+
+	binaryCodec protocol.BinaryCodec
+
+	//new connections are refused after shutdown is started
+	shutdown int32
+
+	stats *serverStats
+
+	//connections being set up or running, and limit of them
+	connections    int64
+	maxConnections int64
+
+	//nanoseconds, see SetConnectTimeout
+	connectTimeout int64
+
+	rateLimitEvents int
+	rateLimitPer    time.Duration
+	rateLimitLock   sync.RWMutex
+
+	allowedOrigins []string
+	originsLock    sync.RWMutex
+
+	queueSize int
+
+	decodeLimits     DecodeLimits
+	decodeLimitsLock sync.RWMutex
+
+	//set atomically, 1 keeps connections after malformed packets
+	continueOnDecodeError int32
+
+	//set atomically, 1 decodes numbers of new connections as json.Number
+	useNumber int32
+
+	//RootNamespaceForm of new connections, set atomically
+	rootForm int32
+
+	handlerMode           HandlerMode
+	maxConcurrentHandlers int
+	handlerModeLock       sync.RWMutex
+
+	//sessions of closed connections by their sid, kept for resume
+	resumeSecret        []byte
+	resumeTokenLifetime time.Duration
+	sessions            map[string]*session
+	sessionsLock        sync.Mutex
+
+	//replay buffers of closed connections by their sid
+	replaySize  int
+	replays     map[string]*replayBuffer
+	replaysLock sync.Mutex
+
 	tr transport.Transport
 }
 
 /**
-Close current channel
+Close current channel, channel of namespace closes the whole connection
  */
 func (c *Channel) Close() {
-	if c.server != nil {
-		closeChannel(c, &c.server.methods)
+	if c.methods != nil {
+		closeChannel(c, c.methods)
 	}
 }
 
@@ -68,6 +138,27 @@ func (c *Channel) RequestHeader() http.Header {
 	return c.requestHeader
 }
 
+/**
+Get handshake request of this connection, with headers, cookies, url,
+remote address and tls state, its body is not kept and its context is
+not the one of http server, nil on client side and for connections
+set up without http request
+*/
+func (c *Channel) Request() *http.Request {
+	return c.request
+}
+
+/**
+Copy of handshake request, that is safe to keep for connection lifetime
+*/
+func handshakeRequest(r *http.Request) *http.Request {
+	hr := r.Clone(context.Background())
+	hr.Body = http.NoBody
+	hr.GetBody = nil
+	hr.MultipartForm = nil
+	return hr
+}
+
 /**
 Get channel by it's sid
 */
@@ -83,6 +174,19 @@ func (s *Server) GetChannel(sid string) (*Channel, error) {
 	return c, nil
 }
 
+/**
+Emit to connection with given sid, ErrorConnectionNotFound is returned
+for unknown sid, ErrorNotConnected if connection is already closed
+*/
+func (s *Server) Emit(sid, method string, args interface{}) error {
+	c, err := s.GetChannel(sid)
+	if err != nil {
+		return err
+	}
+
+	return c.Emit(method, args)
+}
+
 /**
 Join this channel to given room
 */
@@ -194,41 +298,229 @@ func (s *Server) List(room string) []*Channel {
 }
 
 func (c *Channel) BroadcastTo(room, method string, args interface{}) {
+	c.BroadcastToWithOptions(room, method, args)
+}
+
+/**
+Broadcast message to all room channels with given options, see EmitWithOptions
+*/
+func (c *Channel) BroadcastToWithOptions(room, method string, args interface{}, opts ...EmitOpt) {
 	if c.server == nil {
 		return
 	}
-	c.server.BroadcastTo(room, method, args)
+	c.server.BroadcastToWithOptions(room, method, args, opts...)
+}
+
+/**
+Emit broadcasted message, with OverflowBlock policy it is dropped
+for channel with full queue instead of waiting for it
+*/
+func (c *Channel) emitBroadcast(method string, args interface{}, opts emitOptions) error {
+	opts.broadcast = true
+	return c.emitWith(method, args, opts)
 }
 
 /**
 Broadcast message to all room channels
+
+Room is copied under lock and sent after it is released, so joins and leaves
+during broadcast do not race, and closing channel, that takes its own lock
+before the rooms one, can't deadlock with broadcast
 */
 func (s *Server) BroadcastTo(room, method string, args interface{}) {
+	s.BroadcastToWithOptions(room, method, args)
+}
+
+/**
+Broadcast message to all room channels with given options, see EmitWithOptions
+*/
+func (s *Server) BroadcastToWithOptions(room, method string, args interface{}, opts ...EmitOpt) {
+	o := getEmitOptions(opts)
+	for _, cn := range s.List(room) {
+		if cn.IsAlive() {
+			cn.emitBroadcast(method, args, o)
+		}
+	}
+}
+
+/**
+Broadcast message to channels of all given rooms, channel joined to
+several of them gets the message once, rooms are copied under lock
+as in BroadcastTo
+*/
+func (s *Server) BroadcastToMany(rooms []string, method string, args interface{}) {
+	s.BroadcastToManyWithOptions(rooms, method, args)
+}
+
+/**
+Broadcast message to channels of all given rooms with given options,
+see BroadcastToMany and EmitWithOptions
+*/
+func (s *Server) BroadcastToManyWithOptions(rooms []string, method string, args interface{},
+	opts ...EmitOpt) {
+
+	o := getEmitOptions(opts)
+	for _, cn := range s.listMany(rooms) {
+		if cn.IsAlive() {
+			cn.emitBroadcast(method, args, o)
+		}
+	}
+}
+
+/**
+Get union of channels of given rooms, without duplicates
+*/
+func (s *Server) listMany(rooms []string) []*Channel {
 	s.channelsLock.RLock()
 	defer s.channelsLock.RUnlock()
 
-	roomChannels, ok := s.channels[room]
-	if !ok {
+	seen := make(map[*Channel]struct{})
+	channels := make([]*Channel, 0)
+	for _, room := range rooms {
+		for cn := range s.channels[room] {
+			if _, ok := seen[cn]; ok {
+				continue
+			}
+			seen[cn] = struct{}{}
+			channels = append(channels, cn)
+		}
+	}
+	return channels
+}
+
+/**
+Broadcast message to all channels in rooms of this channel, except
+channels of its own connection, channel in several of these rooms
+gets the message once
+*/
+func (c *Channel) Broadcast(method string, args interface{}) {
+	c.BroadcastWithOptions(method, args)
+}
+
+/**
+Broadcast message to room peers with given options, see Broadcast
+and EmitWithOptions
+*/
+func (c *Channel) BroadcastWithOptions(method string, args interface{}, opts ...EmitOpt) {
+	if c.server == nil {
 		return
 	}
 
-	for cn := range roomChannels {
+	o := getEmitOptions(opts)
+	for _, cn := range c.server.roomPeers(c) {
 		if cn.IsAlive() {
-			go cn.Emit(method, args)
+			cn.emitBroadcast(method, args, o)
 		}
 	}
 }
 
 /**
-Broadcast to all clients
+Get channels sharing any room with given one, except channels of its
+connection, rooms are copied under lock, so sending goes without it
 */
-func (s *Server) BroadcastToAll(method string, args interface{}) {
+func (s *Server) roomPeers(c *Channel) []*Channel {
+	s.channelsLock.RLock()
+	defer s.channelsLock.RUnlock()
+
+	sid := c.Id()
+	seen := make(map[*Channel]struct{})
+	peers := make([]*Channel, 0)
+	for room := range s.rooms[c] {
+		for cn := range s.channels[room] {
+			if _, ok := seen[cn]; ok || cn.Id() == sid {
+				continue
+			}
+			seen[cn] = struct{}{}
+			peers = append(peers, cn)
+		}
+	}
+	return peers
+}
+
+/**
+Get list of all connected channels
+*/
+func (s *Server) listAll() []*Channel {
 	s.sidsLock.RLock()
 	defer s.sidsLock.RUnlock()
 
+	channels := make([]*Channel, 0, len(s.sids))
 	for _, cn := range s.sids {
+		channels = append(channels, cn)
+	}
+	return channels
+}
+
+/**
+Broadcast to all clients
+*/
+func (s *Server) BroadcastToAll(method string, args interface{}) {
+	s.BroadcastToAllWithOptions(method, args)
+}
+
+/**
+Broadcast to all clients with given options, see EmitWithOptions
+*/
+func (s *Server) BroadcastToAllWithOptions(method string, args interface{}, opts ...EmitOpt) {
+	o := getEmitOptions(opts)
+	for _, cn := range s.listAll() {
+		if cn.IsAlive() {
+			cn.emitBroadcast(method, args, o)
+		}
+	}
+}
+
+/**
+Broadcast to all clients, except connections with given sids
+*/
+func (s *Server) BroadcastAll(method string, args interface{}, except ...string) {
+	s.BroadcastAllWithOptions(method, args, except)
+}
+
+/**
+Broadcast to all clients, except connections with given sids, with given
+options, see EmitWithOptions
+*/
+func (s *Server) BroadcastAllWithOptions(method string, args interface{}, except []string,
+	opts ...EmitOpt) {
+
+	o := getEmitOptions(opts)
+	skip := make(map[string]struct{}, len(except))
+	for _, sid := range except {
+		skip[sid] = struct{}{}
+	}
+
+	for _, cn := range s.listAll() {
+		if _, ok := skip[cn.Id()]; ok {
+			continue
+		}
 		if cn.IsAlive() {
-			go cn.Emit(method, args)
+			cn.emitBroadcast(method, args, o)
+		}
+	}
+}
+
+/**
+Broadcast to connections matching given predicate, like ones with some
+value set by Channel.Set, connections are copied under lock and predicate
+is called after it is released, so it is free to use channel and server
+methods, including rooms ones
+*/
+func (s *Server) BroadcastWhere(pred func(c *Channel) bool, method string, args interface{}) {
+	s.BroadcastWhereWithOptions(pred, method, args)
+}
+
+/**
+Broadcast to connections matching given predicate with given options,
+see BroadcastWhere and EmitWithOptions
+*/
+func (s *Server) BroadcastWhereWithOptions(pred func(c *Channel) bool, method string,
+	args interface{}, opts ...EmitOpt) {
+
+	o := getEmitOptions(opts)
+	for _, cn := range s.listAll() {
+		if cn.IsAlive() && pred(cn) {
+			cn.emitBroadcast(method, args, o)
 		}
 	}
 }
@@ -237,7 +529,7 @@ func (s *Server) BroadcastToAll(method string, args interface{}) {
 Generate new id for socket.io connection
 */
 func generateNewId(custom string) string {
-	hash := fmt.Sprintf("%s %s %n %n", custom, time.Now(), rand.Uint32(), rand.Uint32())
+	hash := fmt.Sprintf("%s %s %d %d", custom, time.Now(), rand.Uint32(), rand.Uint32())
 	buf := bytes.NewBuffer(nil)
 	sum := md5.Sum([]byte(hash))
 	encoder := base64.NewEncoder(base64.URLEncoding, buf)
@@ -254,12 +546,29 @@ func onConnectStore(c *Channel) {
 	defer c.server.sidsLock.Unlock()
 
 	c.server.sids[c.Id()] = c
+	atomic.AddInt64(&c.server.stats.activeConnections, 1)
 }
 
 /**
 On disconnection system handler, clean joins and sid
 */
 func onDisconnectCleanup(c *Channel) {
+	c.server.callDisconnectHandlers(c)
+	c.sessionKept = c.server.keepSession(c)
+	onLeaveRoomsCleanup(c)
+
+	c.server.sidsLock.Lock()
+	defer c.server.sidsLock.Unlock()
+
+	delete(c.server.sids, c.Id())
+	atomic.AddInt64(&c.server.stats.activeConnections, -1)
+	c.server.keepReplay(c)
+}
+
+/**
+Namespace disconnection system handler, clean joins
+*/
+func onLeaveRoomsCleanup(c *Channel) {
 	c.server.channelsLock.Lock()
 	defer c.server.channelsLock.Unlock()
 
@@ -277,27 +586,131 @@ func onDisconnectCleanup(c *Channel) {
 
 		delete(c.server.rooms, c)
 	}
+}
 
-	c.server.sidsLock.Lock()
-	defer c.server.sidsLock.Unlock()
-
-	delete(c.server.sids, c.Id())
+/**
+Get handlers of given namespace, clients connecting to it are handled by them,
+rooms are left automatically on disconnection as for root namespace
+*/
+func (s *Server) Of(namespace string) *Namespace {
+	return s.of(namespace, func(n *Namespace) {
+		n.onDisconnection = onLeaveRoomsCleanup
+	})
 }
 
 func (s *Server) SendOpenSequence(c *Channel) {
-	jsonHdr, err := json.Marshal(&c.header)
+	c.out <- outPacket{text: openPacket(c)}
+
+	//v4 clients connect to root namespace themselves
+	if c.engineIO != protocol.EngineIOv4 {
+		c.out <- outPacket{text: protocol.MustEncode(&protocol.Message{
+			Type:         protocol.MessageTypeEmpty,
+			ExplicitRoot: c.explicitRoot(),
+		})}
+	}
+}
+
+/**
+Engine.io open packet with header of given channel
+*/
+func openPacket(c *Channel) string {
+	jsonHdr, err := protocol.Marshal(&c.header)
 	if err != nil {
 		panic(err)
 	}
 
-	c.out <- protocol.MustEncode(
+	return protocol.MustEncode(
 		&protocol.Message{
 			Type: protocol.MessageTypeOpen,
 			Args: string(jsonHdr),
 		},
 	)
+}
 
-	c.out <- protocol.MustEncode(&protocol.Message{Type: protocol.MessageTypeEmpty})
+/**
+Handler of panic occurred in event handler, connection is closed after it
+*/
+type PanicHandler func(c *Channel, event string, r interface{})
+
+/**
+Set handler of panics in event handlers, panics are logged if it is not set
+*/
+func (s *Server) SetPanicHandler(h PanicHandler) {
+	s.panicHandler = h
+}
+
+/**
+Add handler called for every connection after middlewares accept it,
+the same as OnConnection handler, called for root namespace only
+*/
+func (s *Server) OnConnect(f func(c *Channel)) {
+	s.addCaller(OnConnection, &caller{
+		direct: func(c *Channel, msg *protocol.Message) error {
+			f(c)
+			return nil
+		},
+	})
+}
+
+/**
+Add handler called for every closed connection with reason of it, unlike
+OnDisconnection handlers it is called before cleanup, so connection is
+still in its rooms and is found by GetChannel
+*/
+func (s *Server) OnDisconnect(f func(c *Channel, reason DisconnectReason)) {
+	s.disconnectHandlersLock.Lock()
+	defer s.disconnectHandlersLock.Unlock()
+
+	handlers := s.disconnectHandlers
+	s.disconnectHandlers = append(handlers[:len(handlers):len(handlers)], f)
+}
+
+/**
+Call OnDisconnect handlers, panic of one is reported and the rest
+are still called
+*/
+func (s *Server) callDisconnectHandlers(c *Channel) {
+	s.disconnectHandlersLock.RLock()
+	handlers := s.disconnectHandlers
+	s.disconnectHandlersLock.RUnlock()
+
+	reason := c.DisconnectReason()
+	for _, f := range handlers {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					recoverHandler(c, OnDisconnection, r)
+				}
+			}()
+			f(c, reason)
+		}()
+	}
+}
+
+/**
+Accept connections of given in-memory transport, query of client url
+is applied as for http requests, auth handler is not called as there
+is no http request, middlewares are
+*/
+func (s *Server) ServeMemory(tr *transport.MemoryTransport) {
+	tr.Handler = func(conn transport.Connection, rawUrl string) error {
+		var query url.Values
+		if u, err := url.Parse(rawUrl); err == nil {
+			query = u.Query()
+		}
+
+		if s.isShutdown() {
+			return ErrorServerShutdown
+		}
+		if !s.reserveConnection() {
+			return ErrorTooManyConnections
+		}
+
+		//pipe write blocks till client reads, and client loops are
+		//started only after connect returns
+		go s.setupEventLoop(conn, conn.RemoteAddr().String(), http.Header{}, nil, query, nil, "", nil)
+		return nil
+	}
 }
 
 /**
@@ -306,41 +719,168 @@ Setup event loop for given connection
 func (s *Server) SetupEventLoop(conn transport.Connection, remoteAddr string,
 	requestHeader http.Header) {
 
+	atomic.AddInt64(&s.connections, 1)
+	s.setupEventLoop(conn, remoteAddr, requestHeader, nil, nil, nil, "", nil)
+}
+
+/**
+Setup event loop for given connection with result of auth handler,
+engine.io version and binary codec are taken from request query,
+request is nil if connection is set up without http one, sid is
+generated if empty one is given, false if middlewares reject connection
+*/
+func (s *Server) setupEventLoop(conn transport.Connection, remoteAddr string,
+	requestHeader http.Header, auth interface{}, query url.Values, request *http.Request,
+	sid string, sess *session) bool {
+
+	if sid == "" {
+		sid = generateNewId(remoteAddr)
+	}
 	interval, timeout := conn.PingParams()
 	hdr := Header{
-		Sid:          generateNewId(remoteAddr),
+		Sid:          sid,
 		Upgrades:     []string{},
 		PingInterval: int(interval / time.Millisecond),
 		PingTimeout:  int(timeout / time.Millisecond),
 	}
 
+	engineIO := protocol.GetEngineIOVersion(query.Get("EIO"))
+	if engineIO == protocol.EngineIOv4 {
+		hdr.MaxPayload = maxPayload
+	}
+
 	c := &Channel{}
 	c.conn = conn
 	c.ip = remoteAddr
 	c.requestHeader = requestHeader
+	c.request = request
+	c.auth = auth
+	c.methods = &s.methods
+	c.queueSize = s.queueSize
 	c.initChannel()
 
 	c.server = s
 	c.header = hdr
+	c.engineIO = engineIO
+	c.limiter = s.newRateLimiter()
+	c.decodeLimits = s.getDecodeLimits()
+	c.continueOnDecodeError = atomic.LoadInt32(&s.continueOnDecodeError) == 1
+	c.useNumber = atomic.LoadInt32(&s.useNumber)
+	s.applyHandlerMode(c)
+	c.rootForm = atomic.LoadInt32(&s.rootForm)
+	c.replay = s.newReplayBuffer()
+	s.acceptBinaryCodec(c, query.Get(payloadParam))
+	s.resumeReplay(c, query, requestHeader.Get(resumeTokenHeader))
+
+	if err := s.runMiddlewares(c); err != nil {
+		s.returnSession(sess)
+		rejectChannel(c, err)
+		return false
+	}
+	s.resumeSession(c, sess)
 
 	s.SendOpenSequence(c)
+	c.setState(StateOpen)
+	if engineIO != protocol.EngineIOv4 {
+		c.flushReplay()
+	}
 
+	s.startConnectTimer(c)
 	go inLoop(c, &s.methods)
 	go outLoop(c, &s.methods)
+	if engineIO == protocol.EngineIOv4 {
+		go pinger(c)
+	}
 
 	s.callLoopEvent(c, OnConnection)
+	return true
+}
+
+var _ http.Handler = (*Server)(nil)
+
+/**
+Register server on given mux at path prefix, like "/api/realtime/",
+slashes are added if missing, so every engine.io request under the prefix
+is served, whatever its query, nil mux means http.DefaultServeMux.
+Server does not depend on request path, so it could be also registered
+under http.StripPrefix or any router.
+*/
+func (s *Server) Mount(mux *http.ServeMux, path string) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+
+	mux.Handle(path, s)
 }
 
 /**
 implements ServeHTTP function from http.Handler
 */
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.handleCORS(w, r) {
+		return
+	}
+
+	//requests of established polling connections are still served
+	r = s.withCookieSid(r)
+	handshake := r.URL.Query().Get("sid") == ""
+	if s.isShutdown() && handshake {
+		http.Error(w, ErrorServerShutdown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	//following polling requests belong to authenticated connection
+	var auth interface{}
+	if handshake {
+		var err error
+		if auth, err = s.authenticate(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if handshake && !s.reserveConnection() {
+		w.Header().Set("Retry-After", retryAfterSeconds)
+		http.Error(w, ErrorTooManyConnections.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	//sid is known before upgrade, so websocket sends its cookie with
+	//upgrade response, resumed connection gets sid of its session
+	var sid string
+	var sess *session
+	if handshake {
+		sess = s.takeSession(r.Header)
+		if sess != nil {
+			sid = sess.sid
+		} else {
+			sid = generateNewId(r.RemoteAddr)
+		}
+		r = s.withSidCookie(r, sid)
+	}
+
 	conn, err := s.tr.HandleConnection(w, r)
+	if conn == nil && handshake {
+		s.releaseConnection()
+		s.returnSession(sess)
+	}
 	if err != nil {
+		s.getLogger().Warn("upgrade failed", "remote", r.RemoteAddr, "err", err)
 		return
 	}
 
-	s.SetupEventLoop(conn, r.RemoteAddr, r.Header)
+	if conn != nil {
+		hr := handshakeRequest(r)
+		if s.setupEventLoop(conn, r.RemoteAddr, hr.Header, auth, hr.URL.Query(), hr, sid, sess) {
+			s.setSidCookie(w, sid)
+		}
+	}
 	s.tr.Serve(w, r)
 }
 
@@ -374,6 +914,10 @@ func NewServer(tr transport.Transport) *Server {
 	s.channels = make(map[string]map[*Channel]struct{})
 	s.rooms = make(map[*Channel]map[string]struct{})
 	s.sids = make(map[string]*Channel)
+	s.replays = make(map[string]*replayBuffer)
+	s.sessions = make(map[string]*session)
+	s.stats = &serverStats{}
+	s.decodeLimits = GetDefaultDecodeLimits()
 	s.onConnection = onConnectStore
 	s.onDisconnection = onDisconnectCleanup
 
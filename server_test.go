@@ -0,0 +1,273 @@
+package gosocketio
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graarh/golang-socketio/transport"
+)
+
+/**
+Start server with given transport, returns it with its websocket url
+*/
+func startServer(t *testing.T, tr transport.Transport) (*Server, string) {
+	s := NewServer(tr)
+	httpServer := httptest.NewServer(s)
+	t.Cleanup(httpServer.Close)
+
+	return s, "ws" + strings.TrimPrefix(httpServer.URL, "http") + socketioUrl
+}
+
+/**
+Dial server with default websocket transport and wait till it confirms
+connect, client is closed by cleanup
+*/
+func dialServer(t *testing.T, url string) *Client {
+	c, err := DialAndWait(url, transport.GetDefaultWebsocketTransport(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+/**
+Wait till condition is met, fail if it is not met within a second
+*/
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+/**
+Connect to server with plain websocket, so packets could be checked as is
+*/
+func dialRaw(t *testing.T, url string) *websocket.Conn {
+	socket, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { socket.Close() })
+	return socket
+}
+
+func readPacket(t *testing.T, socket *websocket.Conn) string {
+	socket.SetReadDeadline(time.Now().Add(time.Second))
+	_, message, err := socket.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(message)
+}
+
+func writeRaw(t *testing.T, socket *websocket.Conn, packet string) {
+	if err := socket.WriteMessage(websocket.TextMessage, []byte(packet)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/**
+Decode header of open packet
+*/
+func openHeader(t *testing.T, packet string) map[string]interface{} {
+	if !strings.HasPrefix(packet, "0{") {
+		t.Fatalf("expected open packet, got %s", packet)
+	}
+	var hdr map[string]interface{}
+	if err := json.Unmarshal([]byte(packet[1:]), &hdr); err != nil {
+		t.Fatal(err)
+	}
+	return hdr
+}
+
+func TestOpenPacketV3(t *testing.T) {
+	_, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	socket := dialRaw(t, url)
+
+	hdr := openHeader(t, readPacket(t, socket))
+	for _, field := range []string{"sid", "upgrades", "pingInterval", "pingTimeout"} {
+		if _, ok := hdr[field]; !ok {
+			t.Fatalf("open packet has no %s", field)
+		}
+	}
+	if _, ok := hdr["maxPayload"]; ok {
+		t.Fatal("v3 open packet has maxPayload")
+	}
+
+	//v3 client is connected to root namespace by server
+	if packet := readPacket(t, socket); packet != "40" {
+		t.Fatalf("expected root connect, got %s", packet)
+	}
+}
+
+func TestOpenPacketV4(t *testing.T) {
+	_, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	socket := dialRaw(t, strings.Replace(url, "EIO=3", "EIO=4", 1))
+
+	hdr := openHeader(t, readPacket(t, socket))
+	if hdr["maxPayload"] != float64(maxPayload) {
+		t.Fatalf("unexpected maxPayload %v", hdr["maxPayload"])
+	}
+
+	//v4 client connects to root namespace itself, and gets sid in answer
+	writeRaw(t, socket, "40")
+	packet := readPacket(t, socket)
+	if packet != `40{"sid":"`+hdr["sid"].(string)+`"}` {
+		t.Fatalf("unexpected connect answer %s", packet)
+	}
+}
+
+func TestDisconnectReasonWhileOpen(t *testing.T) {
+	_, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	c := dialServer(t, url)
+
+	if reason := c.DisconnectReason(); reason != DisconnectUnknown {
+		t.Fatalf("open connection has disconnect reason %v", reason)
+	}
+	c.Close()
+	if reason := c.DisconnectReason(); reason != DisconnectClientClose {
+		t.Fatalf("expected client close, got %v", reason)
+	}
+}
+
+func TestServerMount(t *testing.T) {
+	transports := map[string]func() transport.Transport{
+		"websocket": func() transport.Transport { return transport.GetDefaultWebsocketTransport() },
+		"polling":   func() transport.Transport { return transport.GetDefaultPollingTransport() },
+	}
+
+	for name, tr := range transports {
+		s := NewServer(tr())
+		s.On("echo", func(c *Channel, msg string) string {
+			return msg
+		})
+
+		mux := http.NewServeMux()
+		s.Mount(mux, "api/realtime")
+		mux.Handle("/stripped/", http.StripPrefix("/stripped", s))
+		httpServer := httptest.NewServer(mux)
+
+		for _, prefix := range []string{"/api/realtime", "/stripped"} {
+			url := "ws" + strings.TrimPrefix(httpServer.URL, "http") + prefix + socketioUrl
+			c, err := DialAndWait(url, tr(), time.Second)
+			if err != nil {
+				t.Fatalf("%s under %s: %v", name, prefix, err)
+			}
+			answer, err := c.Ack("echo", "hello", time.Second)
+			c.Close()
+			if err != nil || answer != `"hello"` {
+				t.Fatalf("%s under %s: unexpected answer %s, %v", name, prefix, answer, err)
+			}
+		}
+
+		resp, err := http.Get(httpServer.URL + socketioUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("%s is served outside of prefix, %d", name, resp.StatusCode)
+		}
+		httpServer.Close()
+	}
+}
+
+func TestConcurrentClose(t *testing.T) {
+	s, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	closed := make(chan struct{})
+	s.On("close", func(c *Channel) {
+		c.Close()
+		close(closed)
+	})
+	s.On(OnDisconnection, func(c *Channel) {
+		c.Close()
+	})
+
+	//closed from within handlers of both sides
+	c := dialServer(t, url)
+	c.On(OnDisconnection, func(h *Channel) {
+		h.Close()
+	})
+	if err := c.Emit("close", nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("handler closing its channel is deadlocked")
+	}
+	waitFor(t, "client to close", func() bool { return c.State() == StateClosed })
+
+	//closed from several goroutines at once
+	c = dialServer(t, url)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Close()
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("concurrent close is deadlocked")
+	}
+	if c.State() != StateClosed {
+		t.Fatalf("client is not closed, %v", c.State())
+	}
+	waitFor(t, "server channels to close", func() bool { return s.AmountOfSids() == 0 })
+}
+
+func TestOpenPacketPingParams(t *testing.T) {
+	transports := map[string]transport.Transport{}
+	wst := transport.GetDefaultWebsocketTransport()
+	wst.PingInterval = 7 * time.Second
+	wst.PingTimeout = 3 * time.Second
+	transports["websocket"] = wst
+	plt := transport.GetDefaultPollingTransport()
+	plt.PingInterval = 7 * time.Second
+	plt.PingTimeout = 3 * time.Second
+	transports["polling"] = plt
+
+	for name, tr := range transports {
+		s := NewServer(tr)
+		httpServer := httptest.NewServer(s)
+
+		var packet string
+		if name == "websocket" {
+			packet = readPacket(t, dialRaw(t, "ws"+strings.TrimPrefix(httpServer.URL, "http")+socketioUrl))
+		} else {
+			resp, err := http.Get(httpServer.URL + "/socket.io/?EIO=4&transport=polling")
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			packet = strings.Split(string(body), "\x1e")[0]
+		}
+		httpServer.Close()
+
+		hdr := openHeader(t, packet)
+		if hdr["pingInterval"] != float64(7000) || hdr["pingTimeout"] != float64(3000) {
+			t.Fatalf("%s open packet has ping params %v and %v", name, hdr["pingInterval"], hdr["pingTimeout"])
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package gosocketio
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graarh/golang-socketio/transport"
+)
+
+const (
+	//handshake header of client resuming previous session, it is not
+	//sent in query, so it does not get to access logs
+	resumeTokenHeader = "X-Resume-Token"
+
+	//session of closed connection is kept for resume during this time
+	DefaultSessionRetention = time.Minute
+
+	//resume token is accepted during this time after connection is opened
+	DefaultResumeTokenLifetime = 24 * time.Hour
+)
+
+/**
+State of closed connection kept for resume
+*/
+type session struct {
+	sid   string
+	rooms []string
+	data  map[string]interface{}
+	ack   *ackProcessor
+
+	//session is removed when retention time passes
+	expires time.Time
+}
+
+/**
+Let clients resume their sessions after reconnect: every connection gets
+resume token signed with given secret in its open packet, and connection
+presenting the token within DefaultSessionRetention after previous one is
+closed gets its sid, rooms, values set by Set, and pending acks, so answers
+to replayed ack requests reach their callers. Resume is done after
+middlewares accept the connection, values set by them are kept.
+Rooms of namespaces are not kept, clients connect to namespaces anew.
+ReconnectingClient presents the token itself in handshake header, so its
+transport should implement transport.HeaderTransport, token expires after
+DefaultResumeTokenLifetime, nil secret turns resume off.
+*/
+func (s *Server) EnableSessionResume(secret []byte) {
+	s.sessionsLock.Lock()
+	defer s.sessionsLock.Unlock()
+
+	s.resumeSecret = secret
+}
+
+func (s *Server) getResumeSecret() []byte {
+	s.sessionsLock.Lock()
+	defer s.sessionsLock.Unlock()
+
+	return s.resumeSecret
+}
+
+/**
+Set time during which resume token given in open packet is accepted,
+connection open for longer can't be resumed, zero means
+DefaultResumeTokenLifetime
+*/
+func (s *Server) SetResumeTokenLifetime(lifetime time.Duration) {
+	s.sessionsLock.Lock()
+	defer s.sessionsLock.Unlock()
+
+	s.resumeTokenLifetime = lifetime
+}
+
+func (s *Server) getResumeTokenLifetime() time.Duration {
+	s.sessionsLock.Lock()
+	defer s.sessionsLock.Unlock()
+
+	if s.resumeTokenLifetime <= 0 {
+		return DefaultResumeTokenLifetime
+	}
+	return s.resumeTokenLifetime
+}
+
+/**
+Get resume token of given sid, sid, expiry time in unix seconds and
+signature of both
+*/
+func resumeToken(secret []byte, sid string, expires time.Time) string {
+	payload := sid + "." + strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+/**
+Get sid of resume token, false if token is not signed with given secret
+or is expired
+*/
+func checkResumeToken(secret []byte, token string) (string, bool) {
+	i := strings.LastIndex(token, ".")
+	if i <= 0 {
+		return "", false
+	}
+	j := strings.LastIndex(token[:i], ".")
+	if j <= 0 {
+		return "", false
+	}
+
+	expires, err := strconv.ParseInt(token[j+1:i], 10, 64)
+	if err != nil {
+		return "", false
+	}
+	sid := token[:j]
+	if !hmac.Equal([]byte(resumeToken(secret, sid, time.Unix(expires, 0))), []byte(token)) {
+		return "", false
+	}
+	if time.Now().Unix() > expires {
+		return "", false
+	}
+	return sid, true
+}
+
+/**
+Keep session of closing connection for retention time, called by
+disconnection handler before rooms are left, false if resume is off
+or connection is closed by server on purpose
+*/
+func (s *Server) keepSession(c *Channel) bool {
+	if s.getResumeSecret() == nil {
+		return false
+	}
+	if reason := c.DisconnectReason(); reason == DisconnectServerClose || reason == DisconnectShutdown {
+		return false
+	}
+
+	sid := c.Id()
+	sess := &session{sid: sid, ack: c.ack, expires: time.Now().Add(DefaultSessionRetention)}
+	s.channelsLock.RLock()
+	for room := range s.rooms[c] {
+		sess.rooms = append(sess.rooms, room)
+	}
+	s.channelsLock.RUnlock()
+
+	c.dataLock.RLock()
+	sess.data = c.data
+	c.dataLock.RUnlock()
+
+	s.sessionsLock.Lock()
+	s.sessions[sid] = sess
+	s.sessionsLock.Unlock()
+
+	time.AfterFunc(DefaultSessionRetention, func() {
+		s.sessionsLock.Lock()
+		expired := s.sessions[sid] == sess
+		if expired {
+			delete(s.sessions, sid)
+		}
+		s.sessionsLock.Unlock()
+
+		if expired {
+			sess.ack.close()
+		}
+	})
+	return true
+}
+
+/**
+Get kept session of resume token presented on handshake and remove it
+from kept ones, so new connection gets its sid before the handshake is
+answered, nil if resume is off, token is invalid or session is not kept
+*/
+func (s *Server) takeSession(requestHeader http.Header) *session {
+	secret := s.getResumeSecret()
+	if secret == nil {
+		return nil
+	}
+	sid, ok := checkResumeToken(secret, requestHeader.Get(resumeTokenHeader))
+	if !ok {
+		return nil
+	}
+
+	s.sessionsLock.Lock()
+	defer s.sessionsLock.Unlock()
+
+	sess := s.sessions[sid]
+	delete(s.sessions, sid)
+	return sess
+}
+
+/**
+Keep again session taken by connection, which was not set up,
+expired one is dropped
+*/
+func (s *Server) returnSession(sess *session) {
+	if sess == nil {
+		return
+	}
+	if time.Now().After(sess.expires) {
+		sess.ack.close()
+		return
+	}
+
+	s.sessionsLock.Lock()
+	s.sessions[sess.sid] = sess
+	s.sessionsLock.Unlock()
+}
+
+/**
+Give new connection resume token, and restore given session taken by
+takeSession, connection already has its sid, called after middlewares
+and before open packet is sent
+*/
+func (s *Server) resumeSession(c *Channel, sess *session) {
+	secret := s.getResumeSecret()
+	if secret == nil {
+		s.returnSession(sess)
+		return
+	}
+
+	c.headerLock.Lock()
+	c.header.ResumeToken = resumeToken(secret, c.header.Sid, time.Now().Add(s.getResumeTokenLifetime()))
+	c.headerLock.Unlock()
+
+	if sess == nil {
+		return
+	}
+	c.ack = sess.ack
+	c.resumed = true
+	for key, val := range sess.data {
+		if _, ok := c.Get(key); !ok {
+			c.Set(key, val)
+		}
+	}
+	for _, room := range sess.rooms {
+		c.Join(room)
+	}
+}
+
+/**
+Check that connection continues session of previous one, see
+EnableSessionResume, valid on server side after connection is set up
+*/
+func (c *Channel) Resumed() bool {
+	if c.root != nil {
+		return c.root.Resumed()
+	}
+	return c.resumed
+}
+
+/**
+Get resume token given by server in open packet, empty if none
+*/
+func (c *Channel) resumeToken() string {
+	c.headerLock.RLock()
+	defer c.headerLock.RUnlock()
+
+	return c.header.ResumeToken
+}
+
+/**
+Get transport presenting resume token of previous connection in
+handshake header, transport is used as is if it can't send headers
+*/
+func resumeTransport(tr transport.Transport, token string) transport.Transport {
+	htr, ok := tr.(transport.HeaderTransport)
+	if !ok {
+		return tr
+	}
+	return &headerTransport{htr, http.Header{resumeTokenHeader: {token}}}
+}
@@ -0,0 +1,94 @@
+package gosocketio
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graarh/golang-socketio/transport"
+)
+
+func TestResumeTokenExpiry(t *testing.T) {
+	secret := []byte("secret")
+	expires := time.Now().Add(time.Minute)
+
+	token := resumeToken(secret, "prev", expires)
+	if sid, ok := checkResumeToken(secret, token); !ok || sid != "prev" {
+		t.Fatalf("valid token is not accepted, %q %v", sid, ok)
+	}
+
+	extended := strings.Replace(token, ".", ".9", 1)
+	if _, ok := checkResumeToken(secret, extended); ok {
+		t.Fatal("token with changed expiry is accepted")
+	}
+	if _, ok := checkResumeToken(secret, resumeToken(secret, "prev", time.Now().Add(-time.Second))); ok {
+		t.Fatal("expired token is accepted")
+	}
+}
+
+/**
+Connect raw client to resuming server and close it, so its session is
+kept, returns its sid and resume token
+*/
+func keptSession(t *testing.T, s *Server, url string) (string, string) {
+	socket := dialRaw(t, url)
+	hdr := openHeader(t, readPacket(t, socket))
+	sid, _ := hdr["sid"].(string)
+	token, _ := hdr["resumeToken"].(string)
+	if token == "" {
+		t.Fatal("open packet has no resume token")
+	}
+	socket.Close()
+
+	waitFor(t, "kept session", func() bool {
+		s.sessionsLock.Lock()
+		defer s.sessionsLock.Unlock()
+		return s.sessions[sid] != nil
+	})
+	return sid, token
+}
+
+func TestResumeSessionCookie(t *testing.T) {
+	s, url := startServer(t, transport.GetDefaultWebsocketTransport())
+	s.EnableSessionResume([]byte("secret"))
+	s.SetSidCookie(&SidCookie{})
+
+	var resumed sync.Map
+	s.On(OnConnection, func(c *Channel) {
+		resumed.Store(c.Id(), c.Resumed())
+	})
+
+	sid, token := keptSession(t, s, url)
+	socket, resp, err := websocket.DefaultDialer.Dial(url, http.Header{resumeTokenHeader: {token}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+
+	if got := openHeader(t, readPacket(t, socket))["sid"]; got != sid {
+		t.Fatalf("expected resumed sid %q, got %v", sid, got)
+	}
+	cookies := resp.Cookies()
+	if len(cookies) != 1 || cookies[0].Value != sid {
+		t.Fatalf("expected cookie with resumed sid %q, got %v", sid, cookies)
+	}
+	waitFor(t, "resumed connection", func() bool {
+		ok, _ := resumed.Load(sid)
+		return ok == true
+	})
+}
+
+func TestResumeTokenNotReadFromQuery(t *testing.T) {
+	s, rawUrl := startServer(t, transport.GetDefaultWebsocketTransport())
+	s.EnableSessionResume([]byte("secret"))
+
+	sid, token := keptSession(t, s, rawUrl)
+	socket := dialRaw(t, rawUrl+"&resume_token="+url.QueryEscape(token))
+	if got := openHeader(t, readPacket(t, socket))["sid"]; got == sid {
+		t.Fatal("session is resumed with token in query")
+	}
+}
@@ -0,0 +1,101 @@
+package gosocketio
+
+import (
+	"context"
+	"errors"
+	"github.com/graarh/golang-socketio/transport"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//event emitted to every client on server shutdown
+	ShutdownEvent = "shutdown"
+
+	shutdownPollInterval = 50 * time.Millisecond
+)
+
+var (
+	ErrorServerShutdown = errors.New("Server is shutting down")
+)
+
+func (s *Server) isShutdown() bool {
+	return atomic.LoadInt32(&s.shutdown) == 1
+}
+
+/**
+Close channel with close frame after already queued packets are sent
+*/
+func (c *Channel) closeAfterQueued(code int, reason string) {
+	if c.root != nil {
+		c = c.root
+	}
+	if !c.IsAlive() {
+		return
+	}
+
+	select {
+	case c.out <- outPacket{text: reason, closeCode: code}:
+	default:
+		//outgoing queue is full, wait for room instead of dropping queued
+		//packets, Shutdown closes the connection if its context is done
+		go func() {
+			select {
+			case c.out <- outPacket{text: reason, closeCode: code}:
+			case <-c.Context().Done():
+			}
+		}()
+	}
+}
+
+/**
+Close channel after packets queued so far are written, see Flush,
+connection is closed even if flush fails, flush error is returned.
+Packets dropped by OverflowDropOldest during the wait are not written.
+*/
+func (c *Channel) CloseGracefully(timeout time.Duration) error {
+	err := c.Flush(timeout)
+	c.Close()
+	return err
+}
+
+/**
+Stop accepting new connections, emit ShutdownEvent to every client
+and close connections, then wait until all of them are closed.
+Remaining connections are closed at once if context is done, its
+error is returned.
+*/
+func (s *Server) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&s.shutdown, 1)
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	notified := make(map[*Channel]struct{})
+	for {
+		//connections could be finishing their setup, so check them every time
+		channels := s.listAll()
+		if len(channels) == 0 {
+			return nil
+		}
+
+		for _, c := range channels {
+			if _, ok := notified[c]; ok {
+				continue
+			}
+			notified[c] = struct{}{}
+
+			c.Emit(ShutdownEvent, nil)
+			c.closeAfterQueued(transport.CloseGoingAway, ErrorServerShutdown.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			for _, c := range s.listAll() {
+				c.Close()
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package gosocketio
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+/**
+Snapshot of server statistics
+*/
+type Stats struct {
+	ActiveConnections int64
+	MessagesReceived  int64
+	MessagesSent      int64
+	BytesReceived     int64
+	BytesSent         int64
+	AcksPending       int64
+
+	//invocations of handled events by event name
+	Events map[string]int64
+}
+
+/**
+Server counters, updated atomically so reading them never blocks
+connection loops, allocated separately to keep 64-bit alignment
+*/
+type serverStats struct {
+	activeConnections int64
+	messagesReceived  int64
+	messagesSent      int64
+	bytesReceived     int64
+	bytesSent         int64
+	acksPending       int64
+
+	//event name to *int64 counter
+	events sync.Map
+}
+
+func (st *serverStats) received(size int) {
+	atomic.AddInt64(&st.messagesReceived, 1)
+	atomic.AddInt64(&st.bytesReceived, int64(size))
+}
+
+func (st *serverStats) sent(size int) {
+	atomic.AddInt64(&st.messagesSent, 1)
+	atomic.AddInt64(&st.bytesSent, int64(size))
+}
+
+func (st *serverStats) event(name string) {
+	counter, ok := st.events.Load(name)
+	if !ok {
+		counter, _ = st.events.LoadOrStore(name, new(int64))
+	}
+	atomic.AddInt64(counter.(*int64), 1)
+}
+
+/**
+Get stats of server channel, nil for client ones
+*/
+func (c *Channel) stats() *serverStats {
+	if c.server == nil {
+		return nil
+	}
+	return c.server.stats
+}
+
+/**
+Get snapshot of server statistics
+*/
+func (s *Server) Stats() Stats {
+	st := s.stats
+	result := Stats{
+		ActiveConnections: atomic.LoadInt64(&st.activeConnections),
+		MessagesReceived:  atomic.LoadInt64(&st.messagesReceived),
+		MessagesSent:      atomic.LoadInt64(&st.messagesSent),
+		BytesReceived:     atomic.LoadInt64(&st.bytesReceived),
+		BytesSent:         atomic.LoadInt64(&st.bytesSent),
+		AcksPending:       atomic.LoadInt64(&st.acksPending),
+		Events:            make(map[string]int64),
+	}
+
+	st.events.Range(func(name, counter interface{}) bool {
+		result.Events[name.(string)] = atomic.LoadInt64(counter.(*int64))
+		return true
+	})
+
+	return result
+}
@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+const (
+	//engine.io error codes of rejected requests
+	ErrorCodeTransportUnknown    = 0
+	ErrorCodeSessionUnknown      = 1
+	ErrorCodeBadHandshakeMethod  = 2
+	ErrorCodeBadRequest          = 3
+	ErrorCodeForbidden           = 4
+	ErrorCodeUnsupportedProtocol = 5
+
+	DefaultMaxHeaderBytes = 1024 * 32
+)
+
+var (
+	ErrorTransportUnknown    = errors.New("Transport unknown")
+	ErrorUnsupportedProtocol = errors.New("Unsupported protocol version")
+	ErrorHeaderTooLarge      = errors.New("Request header too large")
+)
+
+/**
+Engine.io error answer, clients report its code and message
+*/
+type engineIOError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+/**
+Answer engine.io request with error json and given status
+*/
+func writeEngineIOError(w http.ResponseWriter, status int, code int, message string) {
+	body, _ := json.Marshal(&engineIOError{Code: code, Message: message})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+/**
+Validate engine.io query and size of request headers before serving it,
+request is answered with engine.io error if it is wrong, missing EIO and
+transport are allowed for clients that do not send them
+*/
+func checkRequest(w http.ResponseWriter, r *http.Request, name string, maxHeaderBytes int) error {
+	query := r.URL.Query()
+
+	if tr := query.Get("transport"); tr != "" && tr != name {
+		writeEngineIOError(w, http.StatusBadRequest, ErrorCodeTransportUnknown, ErrorTransportUnknown.Error())
+		return ErrorTransportUnknown
+	}
+
+	if eio := query.Get("EIO"); eio != "" && eio != "3" && eio != "4" {
+		writeEngineIOError(w, http.StatusBadRequest, ErrorCodeUnsupportedProtocol, ErrorUnsupportedProtocol.Error())
+		return ErrorUnsupportedProtocol
+	}
+
+	if maxHeaderBytes > 0 && headerSize(r) > maxHeaderBytes {
+		writeEngineIOError(w, http.StatusRequestHeaderFieldsTooLarge, ErrorCodeBadRequest, "Bad request")
+		return ErrorHeaderTooLarge
+	}
+
+	return nil
+}
+
+/**
+Size of request uri and headers, as they were sent
+*/
+func headerSize(r *http.Request) int {
+	size := len(r.Method) + len(r.URL.RequestURI())
+	for name, values := range r.Header {
+		for _, value := range values {
+			//name, ": " and line end
+			size += len(name) + len(value) + 4
+		}
+	}
+	return size
+}
@@ -0,0 +1,199 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	MemDefaultPingInterval   = 30 * time.Second
+	MemDefaultPingTimeout    = 60 * time.Second
+	MemDefaultReceiveTimeout = 60 * time.Second
+)
+
+var (
+	ErrorNoMemoryHandler = errors.New("Memory transport has no handler")
+	ErrorNotHttp         = errors.New("Memory transport does not serve http")
+)
+
+/**
+Address of in-memory connection end
+*/
+type memoryAddr string
+
+func (a memoryAddr) Network() string {
+	return "memory"
+}
+
+func (a memoryAddr) String() string {
+	return string(a)
+}
+
+/**
+End of in-memory connection, like net.Pipe, write blocks until
+the peer reads the message, closing any end closes both
+*/
+type MemoryConnection struct {
+	in  chan string
+	out chan string
+
+	//shared by both ends
+	closed    chan struct{}
+	closeOnce *sync.Once
+
+	pingInterval   time.Duration
+	pingTimeout    time.Duration
+	receiveTimeout time.Duration
+
+	localAddr  net.Addr
+	remoteAddr net.Addr
+}
+
+/**
+Create pair of connected ends with given ping params,
+zero receive timeout means no timeout
+*/
+func NewMemoryPipe(pingInterval, pingTimeout, receiveTimeout time.Duration) (
+	client, server *MemoryConnection) {
+
+	toServer := make(chan string)
+	toClient := make(chan string)
+	closed := make(chan struct{})
+	closeOnce := &sync.Once{}
+
+	client = &MemoryConnection{
+		in:             toClient,
+		out:            toServer,
+		closed:         closed,
+		closeOnce:      closeOnce,
+		pingInterval:   pingInterval,
+		pingTimeout:    pingTimeout,
+		receiveTimeout: receiveTimeout,
+		localAddr:      memoryAddr("client"),
+		remoteAddr:     memoryAddr("server"),
+	}
+	server = &MemoryConnection{
+		in:             toServer,
+		out:            toClient,
+		closed:         closed,
+		closeOnce:      closeOnce,
+		pingInterval:   pingInterval,
+		pingTimeout:    pingTimeout,
+		receiveTimeout: receiveTimeout,
+		localAddr:      memoryAddr("server"),
+		remoteAddr:     memoryAddr("client"),
+	}
+	return client, server
+}
+
+func (mc *MemoryConnection) GetMessage() (message string, err error) {
+	var timeout <-chan time.Time
+	if mc.receiveTimeout > 0 {
+		timer := time.NewTimer(mc.receiveTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case message := <-mc.in:
+		return message, nil
+	case <-mc.closed:
+		return "", ErrorConnectionClosed
+	case <-timeout:
+		return "", ErrorReceiveTimeout
+	}
+}
+
+func (mc *MemoryConnection) WriteMessage(message string) error {
+	select {
+	case <-mc.closed:
+		return ErrorConnectionClosed
+	default:
+	}
+
+	select {
+	case mc.out <- message:
+		return nil
+	case <-mc.closed:
+		return ErrorConnectionClosed
+	}
+}
+
+func (mc *MemoryConnection) Close() {
+	mc.closeOnce.Do(func() {
+		close(mc.closed)
+	})
+}
+
+func (mc *MemoryConnection) PingParams() (interval, timeout time.Duration) {
+	return mc.pingInterval, mc.pingTimeout
+}
+
+func (mc *MemoryConnection) RemoteAddr() net.Addr {
+	return mc.remoteAddr
+}
+
+func (mc *MemoryConnection) LocalAddr() net.Addr {
+	return mc.localAddr
+}
+
+func (mc *MemoryConnection) TransportName() string {
+	return NameMemory
+}
+
+/**
+Transport connecting client to server in the same process without sockets,
+for tests of handlers, server side end of every connect is given to Handler
+*/
+type MemoryTransport struct {
+	PingInterval   time.Duration
+	PingTimeout    time.Duration
+	ReceiveTimeout time.Duration
+
+	//accepts server end of new connection, returned error is given
+	//to connecting client, url is the one client connects with
+	Handler func(conn Connection, url string) error
+}
+
+/**
+Create connection and give its server end to Handler, url is not resolved,
+only its query is of interest for the server, like EIO
+*/
+func (mt *MemoryTransport) Connect(url string) (conn Connection, err error) {
+	if mt.Handler == nil {
+		return nil, ErrorNoMemoryHandler
+	}
+
+	client, server := NewMemoryPipe(mt.PingInterval, mt.PingTimeout, mt.ReceiveTimeout)
+	if err := mt.Handler(server, url); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func (mt *MemoryTransport) HandleConnection(
+	w http.ResponseWriter, r *http.Request) (conn Connection, err error) {
+
+	http.Error(w, ErrorNotHttp.Error(), http.StatusNotImplemented)
+	return nil, ErrorNotHttp
+}
+
+func (mt *MemoryTransport) Serve(w http.ResponseWriter, r *http.Request) {
+}
+
+/**
+Returns in-memory transport with default params, Handler should be set,
+see Server.ServeMemory
+*/
+func GetDefaultMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{
+		PingInterval:   MemDefaultPingInterval,
+		PingTimeout:    MemDefaultPingTimeout,
+		ReceiveTimeout: MemDefaultReceiveTimeout,
+	}
+}
@@ -0,0 +1,647 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	PlDefaultPingInterval   = 30 * time.Second
+	PlDefaultPingTimeout    = 60 * time.Second
+	PlDefaultReceiveTimeout = 60 * time.Second
+	PlDefaultSendTimeout    = 60 * time.Second
+	PlDefaultMaxBodySize    = 1024 * 1024
+
+	pollingOpenPacket  = "0"
+	pollingClosePacket = "1"
+	pollingNoopPacket  = "6"
+	pollingPostAnswer  = "ok"
+
+	//engine.io v4 payload separator
+	pollingRecordSeparator = "\x1e"
+)
+
+var (
+	ErrorSessionNotFound   = errors.New("Session not found")
+	ErrorReceiveTimeout    = errors.New("Receive timeout")
+	ErrorPayloadWrong      = errors.New("Wrong payload")
+	ErrorPollingFailed     = errors.New("Polling request failed")
+	ErrorOpenPacketMissing = errors.New("Open packet missing")
+)
+
+/**
+Server side end of engine.io xhr polling connection
+*/
+type PollingConnection struct {
+	transport *PollingTransport
+	sid       string
+
+	//engine.io v4 uses its own payload framing
+	v4 bool
+
+	remoteAddr net.Addr
+	localAddr  net.Addr
+
+	in chan string
+
+	out     []string
+	outLock sync.Mutex
+	notify  chan struct{}
+
+	//set by CloseAfterWrite, connection is closed once queued
+	//messages are served
+	draining bool
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+/**
+Wait for message POSTed by client
+*/
+func (plc *PollingConnection) GetMessage() (message string, err error) {
+	select {
+	case message := <-plc.in:
+		return message, nil
+	case <-plc.closed:
+		return "", ErrorConnectionClosed
+	case <-time.After(plc.transport.ReceiveTimeout):
+		return "", ErrorReceiveTimeout
+	}
+}
+
+/**
+Put message to buffer, it will be sent on next GET request
+*/
+func (plc *PollingConnection) WriteMessage(message string) error {
+	select {
+	case <-plc.closed:
+		return ErrorConnectionClosed
+	default:
+	}
+
+	plc.outLock.Lock()
+	if plc.draining {
+		plc.outLock.Unlock()
+		return ErrorConnectionClosed
+	}
+	if plc.sid == "" && strings.HasPrefix(message, pollingOpenPacket) {
+		plc.register(message[len(pollingOpenPacket):])
+	}
+	plc.out = append(plc.out, message)
+	plc.outLock.Unlock()
+
+	plc.notifyGet()
+	return nil
+}
+
+/**
+Wake up GET request waiting for messages
+*/
+func (plc *PollingConnection) notifyGet() {
+	select {
+	case plc.notify <- struct{}{}:
+	default:
+	}
+}
+
+/**
+Session id is known only after the open packet is created, so store
+the connection by sid found in it
+*/
+func (plc *PollingConnection) register(header string) {
+	var hdr struct {
+		Sid string `json:"sid"`
+	}
+	if err := json.Unmarshal([]byte(header), &hdr); err != nil || hdr.Sid == "" {
+		return
+	}
+
+	plc.sid = hdr.Sid
+	plc.transport.sessionsLock.Lock()
+	plc.transport.sessions[plc.sid] = plc
+	plc.transport.sessionsLock.Unlock()
+}
+
+/**
+Close connection, if CloseAfterWrite is called before, connection
+is closed once queued messages are served
+*/
+func (plc *PollingConnection) Close() {
+	plc.outLock.Lock()
+	draining := plc.draining
+	plc.outLock.Unlock()
+
+	if !draining {
+		plc.closeNow()
+	}
+}
+
+/**
+Queue close packet after already queued messages, and close connection
+once next GET request serves them, or after send timeout if client does
+not poll, so messages like connect error reach client with no GET
+pending. Close code and reason are not sent, polling has no close frames.
+*/
+func (plc *PollingConnection) CloseAfterWrite(code int, reason string) {
+	plc.outLock.Lock()
+	if plc.draining {
+		plc.outLock.Unlock()
+		return
+	}
+	plc.draining = true
+	plc.out = append(plc.out, pollingClosePacket)
+	plc.outLock.Unlock()
+
+	plc.notifyGet()
+	time.AfterFunc(plc.transport.SendTimeout, plc.closeNow)
+}
+
+func (plc *PollingConnection) closeNow() {
+	plc.closeOnce.Do(func() {
+		close(plc.closed)
+
+		plc.outLock.Lock()
+		sid := plc.sid
+		plc.outLock.Unlock()
+
+		plc.transport.sessionsLock.Lock()
+		delete(plc.transport.sessions, sid)
+		plc.transport.sessionsLock.Unlock()
+	})
+}
+
+func (plc *PollingConnection) TransportName() string {
+	return NamePolling
+}
+
+func (plc *PollingConnection) PingParams() (interval, timeout time.Duration) {
+	return plc.transport.PingInterval, plc.transport.PingTimeout
+}
+
+func (plc *PollingConnection) PingJitter() int {
+	return plc.transport.PingJitter
+}
+
+/**
+Get remote address of the handshake request
+*/
+func (plc *PollingConnection) RemoteAddr() net.Addr {
+	return plc.remoteAddr
+}
+
+func (plc *PollingConnection) LocalAddr() net.Addr {
+	return plc.localAddr
+}
+
+/**
+Take all buffered messages, drained is true if they are the last ones
+before close
+*/
+func (plc *PollingConnection) flush() (messages []string, drained bool) {
+	plc.outLock.Lock()
+	defer plc.outLock.Unlock()
+
+	messages = plc.out
+	plc.out = nil
+	return messages, plc.draining
+}
+
+/**
+Answer GET request with buffered messages, waits until at least
+one message is available or ping timeout occurs
+*/
+func (plc *PollingConnection) serveGet(w http.ResponseWriter) {
+	messages, drained := plc.flush()
+	//notify could be left by messages served by previous request
+	timeout := time.After(plc.transport.PingTimeout)
+wait:
+	for len(messages) == 0 {
+		select {
+		case <-plc.notify:
+			messages, drained = plc.flush()
+		case <-plc.closed:
+			break wait
+		case <-timeout:
+			break wait
+		}
+	}
+	if len(messages) == 0 {
+		messages = []string{pollingNoopPacket}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.Write([]byte(encodePayload(messages, plc.v4)))
+	if drained {
+		plc.closeNow()
+	}
+}
+
+/**
+Accept messages POSTed by client
+*/
+func (plc *PollingConnection) servePost(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, plc.transport.MaxBodySize))
+	if err != nil {
+		http.Error(w, ErrorBadBuffer.Error(), http.StatusBadRequest)
+		return
+	}
+	if !utf8.Valid(body) {
+		http.Error(w, ErrorInvalidUTF8.Error(), http.StatusBadRequest)
+		return
+	}
+
+	messages, err := decodePayload(string(body), plc.v4)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, message := range messages {
+		select {
+		case plc.in <- message:
+		case <-plc.closed:
+			http.Error(w, ErrorConnectionClosed.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.Write([]byte(pollingPostAnswer))
+}
+
+/**
+Client side end of engine.io xhr polling connection
+*/
+type PollingClientConnection struct {
+	transport  *PollingTransport
+	getClient  *http.Client
+	postClient *http.Client
+	url        string
+	remoteAddr net.Addr
+	v4         bool
+
+	received []string
+}
+
+/**
+Return next received message, make GET request if nothing is received yet
+*/
+func (plcc *PollingClientConnection) GetMessage() (message string, err error) {
+	for len(plcc.received) == 0 {
+		resp, err := plcc.getClient.Get(plcc.url)
+		if err != nil {
+			return "", err
+		}
+		messages, err := readPayload(resp, plcc.v4)
+		if err != nil {
+			return "", err
+		}
+
+		for _, message := range messages {
+			if message != pollingNoopPacket {
+				plcc.received = append(plcc.received, message)
+			}
+		}
+	}
+
+	message = plcc.received[0]
+	plcc.received = plcc.received[1:]
+	return message, nil
+}
+
+func (plcc *PollingClientConnection) WriteMessage(message string) error {
+	resp, err := plcc.postClient.Post(plcc.url, "text/plain; charset=UTF-8",
+		strings.NewReader(encodePayload([]string{message}, plcc.v4)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ErrorPollingFailed
+	}
+	return nil
+}
+
+func (plcc *PollingClientConnection) Close() {
+	plcc.WriteMessage(pollingClosePacket)
+}
+
+func (plcc *PollingClientConnection) TransportName() string {
+	return NamePolling
+}
+
+func (plcc *PollingClientConnection) PingParams() (interval, timeout time.Duration) {
+	return plcc.transport.PingInterval, plcc.transport.PingTimeout
+}
+
+func (plcc *PollingClientConnection) PingJitter() int {
+	return plcc.transport.PingJitter
+}
+
+/**
+Get address of server host
+*/
+func (plcc *PollingClientConnection) RemoteAddr() net.Addr {
+	return plcc.remoteAddr
+}
+
+/**
+Every polling request uses its own connection, so there is no local address
+*/
+func (plcc *PollingClientConnection) LocalAddr() net.Addr {
+	return nil
+}
+
+type PollingTransport struct {
+	PingInterval   time.Duration
+	PingTimeout    time.Duration
+	ReceiveTimeout time.Duration
+	SendTimeout    time.Duration
+	MaxBodySize    int64
+
+	//random change of ping interval in percents, see WebsocketTransport.PingJitter
+	PingJitter int
+
+	//maximum size of request uri and headers in bytes, zero means no limit
+	MaxHeaderBytes int
+
+	sessions     map[string]*PollingConnection
+	pending      map[*http.Request]*PollingConnection
+	sessionsLock sync.RWMutex
+}
+
+/**
+Connect to polling endpoint, url could be given with ws:// scheme
+and websocket transport, it will be converted to polling one
+*/
+func (plt *PollingTransport) Connect(url string) (conn Connection, err error) {
+	url = strings.Replace(url, "transport=websocket", "transport=polling", 1)
+	if strings.HasPrefix(url, "ws") {
+		url = "http" + url[len("ws"):]
+	}
+
+	v4 := false
+	if parsed, err := neturl.Parse(url); err == nil {
+		v4 = isEngineIOv4(parsed.Query())
+	}
+
+	//long polling GET waits for server messages up to ping timeout
+	getClient := &http.Client{Timeout: plt.ReceiveTimeout + plt.PingTimeout}
+	resp, err := getClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := readPayload(resp, v4)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(messages) == 0 || !strings.HasPrefix(messages[0], pollingOpenPacket) {
+		return nil, ErrorOpenPacketMissing
+	}
+	var hdr struct {
+		Sid string `json:"sid"`
+	}
+	err = json.Unmarshal([]byte(messages[0][len(pollingOpenPacket):]), &hdr)
+	if err != nil || hdr.Sid == "" {
+		return nil, ErrorOpenPacketMissing
+	}
+
+	plcc := &PollingClientConnection{
+		transport:  plt,
+		getClient:  getClient,
+		postClient: &http.Client{Timeout: plt.SendTimeout},
+		url:        url + "&sid=" + hdr.Sid,
+		received:   messages,
+		v4:         v4,
+	}
+	if parsed, err := neturl.Parse(url); err == nil {
+		plcc.remoteAddr = textAddr(parsed.Host)
+	}
+
+	return plcc, nil
+}
+
+/**
+Create new connection on handshake request, or check that
+session exists for the following requests
+*/
+func (plt *PollingTransport) HandleConnection(
+	w http.ResponseWriter, r *http.Request) (conn Connection, err error) {
+
+	if err := checkRequest(w, r, NamePolling, plt.MaxHeaderBytes); err != nil {
+		return nil, err
+	}
+
+	sid := r.URL.Query().Get("sid")
+	if sid != "" {
+		if plt.getSession(sid) == nil {
+			writeEngineIOError(w, http.StatusBadRequest, ErrorCodeSessionUnknown, "Session ID unknown")
+			return nil, ErrorSessionNotFound
+		}
+		return nil, nil
+	}
+
+	if r.Method != "GET" {
+		writeEngineIOError(w, http.StatusBadRequest, ErrorCodeBadHandshakeMethod, "Bad handshake method")
+		return nil, ErrorMethodNotAllowed
+	}
+
+	plc := &PollingConnection{
+		transport:  plt,
+		v4:         isEngineIOv4(r.URL.Query()),
+		remoteAddr: textAddr(r.RemoteAddr),
+		in:         make(chan string),
+		notify:     make(chan struct{}, 1),
+		closed:     make(chan struct{}),
+	}
+	if localAddr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		plc.localAddr = localAddr
+	}
+
+	plt.sessionsLock.Lock()
+	plt.pending[r] = plc
+	plt.sessionsLock.Unlock()
+
+	return plc, nil
+}
+
+/**
+Serve GET requests with buffered messages and accept POSTed ones
+*/
+func (plt *PollingTransport) Serve(w http.ResponseWriter, r *http.Request) {
+	plt.sessionsLock.Lock()
+	plc, ok := plt.pending[r]
+	delete(plt.pending, r)
+	plt.sessionsLock.Unlock()
+
+	if !ok {
+		plc = plt.getSession(r.URL.Query().Get("sid"))
+	}
+	if plc == nil {
+		http.Error(w, ErrorSessionNotFound.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		plc.serveGet(w)
+	case "POST":
+		plc.servePost(w, r)
+	default:
+		http.Error(w, ErrorMethodNotAllowed.Error(), http.StatusMethodNotAllowed)
+	}
+}
+
+func (plt *PollingTransport) getSession(sid string) *PollingConnection {
+	plt.sessionsLock.RLock()
+	defer plt.sessionsLock.RUnlock()
+
+	return plt.sessions[sid]
+}
+
+/**
+Returns polling transport with default params
+*/
+func GetDefaultPollingTransport() *PollingTransport {
+	return &PollingTransport{
+		PingInterval:   PlDefaultPingInterval,
+		PingTimeout:    PlDefaultPingTimeout,
+		ReceiveTimeout: PlDefaultReceiveTimeout,
+		SendTimeout:    PlDefaultSendTimeout,
+		MaxBodySize:    PlDefaultMaxBodySize,
+		MaxHeaderBytes: DefaultMaxHeaderBytes,
+
+		sessions: make(map[string]*PollingConnection),
+		pending:  make(map[*http.Request]*PollingConnection),
+	}
+}
+
+/**
+Check that engine.io v4 is requested by EIO query parameter
+*/
+func isEngineIOv4(query neturl.Values) bool {
+	return query.Get("EIO") == "4"
+}
+
+/**
+Read and decode polling response payload
+*/
+func readPayload(resp *http.Response, v4 bool) ([]string, error) {
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrorPollingFailed
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, ErrorBadBuffer
+	}
+
+	return decodePayload(string(body), v4)
+}
+
+/**
+Length of message as javascript counts it, in utf-16 code units
+*/
+func jsLength(message string) int {
+	length := 0
+	for _, r := range message {
+		length++
+		if r > 0xFFFF {
+			length++
+		}
+	}
+	return length
+}
+
+/**
+Encode messages to engine.io v3 text payload, like 2:40
+*/
+func EncodePayload(messages []string) string {
+	result := ""
+	for _, message := range messages {
+		result += strconv.Itoa(jsLength(message)) + ":" + message
+	}
+	return result
+}
+
+/**
+Encode messages to engine.io v4 text payload, messages are
+separated by record separator
+*/
+func EncodePayloadV4(messages []string) string {
+	return strings.Join(messages, pollingRecordSeparator)
+}
+
+/**
+Decode engine.io v4 text payload to separate messages
+*/
+func DecodePayloadV4(payload string) []string {
+	if payload == "" {
+		return nil
+	}
+	return strings.Split(payload, pollingRecordSeparator)
+}
+
+func encodePayload(messages []string, v4 bool) string {
+	if v4 {
+		return EncodePayloadV4(messages)
+	}
+	return EncodePayload(messages)
+}
+
+func decodePayload(payload string, v4 bool) ([]string, error) {
+	if v4 {
+		return DecodePayloadV4(payload), nil
+	}
+	return DecodePayload(payload)
+}
+
+/**
+Decode engine.io v3 text payload to separate messages
+*/
+func DecodePayload(payload string) ([]string, error) {
+	var messages []string
+
+	for len(payload) > 0 {
+		pos := strings.IndexByte(payload, ':')
+		if pos <= 0 {
+			return nil, ErrorPayloadWrong
+		}
+		length, err := strconv.Atoi(payload[:pos])
+		if err != nil || length < 0 {
+			return nil, ErrorPayloadWrong
+		}
+		payload = payload[pos+1:]
+
+		end := 0
+		for count := 0; count < length; count++ {
+			if end >= len(payload) {
+				return nil, ErrorPayloadWrong
+			}
+			r, size := utf8.DecodeRuneInString(payload[end:])
+			if r > 0xFFFF {
+				count++
+			}
+			end += size
+		}
+
+		messages = append(messages, payload[:end])
+		payload = payload[end:]
+	}
+
+	return messages, nil
+}
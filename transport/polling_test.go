@@ -0,0 +1,181 @@
+package transport
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+Start polling server, that sends open packet of given sid and gives
+connection to the test
+*/
+func startPollingServer(t *testing.T, plt *PollingTransport, sid string) (string, chan Connection) {
+	conns := make(chan Connection, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := plt.HandleConnection(w, r)
+		if err != nil {
+			return
+		}
+		if conn != nil {
+			conn.WriteMessage(`0{"sid":"` + sid + `"}`)
+			conns <- conn
+		}
+		plt.Serve(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL + "/socket.io/?EIO=3&transport=polling", conns
+}
+
+func poll(t *testing.T, url string) (int, string) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestPollingCloseAfterWrite(t *testing.T) {
+	url, conns := startPollingServer(t, GetDefaultPollingTransport(), "abc")
+	poll(t, url)
+	conn := (<-conns).(*PollingConnection)
+
+	conn.WriteMessage(`44"nope"`)
+	conn.CloseAfterWrite(ClosePolicyViolation, "nope")
+	//close waits till client gets queued messages
+	conn.Close()
+	if err := conn.WriteMessage("42"); err != ErrorConnectionClosed {
+		t.Fatalf("message is queued after close packet, %v", err)
+	}
+
+	code, body := poll(t, url+"&sid=abc")
+	if code != http.StatusOK || body != `8:44"nope"1:1` {
+		t.Fatalf("unexpected poll answer %d %s", code, body)
+	}
+	if code, _ := poll(t, url+"&sid=abc"); code != http.StatusBadRequest {
+		t.Fatalf("connection is not closed after drain, %d", code)
+	}
+}
+
+func post(t *testing.T, url, payload string) int {
+	resp, err := http.Post(url, "text/plain;charset=UTF-8", strings.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestPollingPostToGetMessage(t *testing.T) {
+	payloads := map[string]string{
+		"EIO=3": `7:42["a"]8:42["bé"]`,
+		"EIO=4": "42[\"a\"]\x1e42[\"bé\"]",
+	}
+
+	for version, payload := range payloads {
+		url, conns := startPollingServer(t, GetDefaultPollingTransport(), "abc")
+		url = strings.Replace(url, "EIO=3", version, 1)
+		poll(t, url)
+		conn := <-conns
+
+		//post is answered when its messages are taken by reader
+		posted := make(chan int, 1)
+		go func() {
+			posted <- post(t, url+"&sid=abc", payload)
+		}()
+		for _, expected := range []string{`42["a"]`, `42["bé"]`} {
+			message, err := conn.GetMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if message != expected {
+				t.Fatalf("%s expected %s, got %s", version, expected, message)
+			}
+		}
+		if code := <-posted; code != http.StatusOK {
+			t.Fatalf("%s payload is not accepted, %d", version, code)
+		}
+	}
+}
+
+func TestPollingWriteFlushedByGet(t *testing.T) {
+	payloads := map[string]string{
+		"EIO=3": `7:42["a"]8:42["bé"]`,
+		"EIO=4": "42[\"a\"]\x1e42[\"bé\"]",
+	}
+
+	for version, payload := range payloads {
+		url, conns := startPollingServer(t, GetDefaultPollingTransport(), "abc")
+		url = strings.Replace(url, "EIO=3", version, 1)
+		poll(t, url)
+		conn := <-conns
+
+		//queued messages go in one payload of the next poll
+		conn.WriteMessage(`42["a"]`)
+		conn.WriteMessage(`42["bé"]`)
+		if code, body := poll(t, url+"&sid=abc"); code != http.StatusOK || body != payload {
+			t.Fatalf("%s unexpected poll answer %d %q", version, code, body)
+		}
+
+		//waiting poll is answered by the next write
+		answer := make(chan string, 1)
+		go func() {
+			_, body := poll(t, url+"&sid=abc")
+			answer <- body
+		}()
+		time.Sleep(20 * time.Millisecond)
+		conn.WriteMessage(`42["c"]`)
+		expected := `7:42["c"]`
+		if version == "EIO=4" {
+			expected = `42["c"]`
+		}
+		select {
+		case body := <-answer:
+			if body != expected {
+				t.Fatalf("%s expected %q, got %q", version, expected, body)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s waiting poll is not answered", version)
+		}
+	}
+}
+
+func TestPollingRefusesInvalidUTF8(t *testing.T) {
+	url, conns := startPollingServer(t, GetDefaultPollingTransport(), "abc")
+	poll(t, url)
+	<-conns
+
+	resp, err := http.Post(url+"&sid=abc", "text/plain;charset=UTF-8", strings.NewReader("5:42\"\xff\""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), ErrorInvalidUTF8.Error()) {
+		t.Fatalf("invalid payload is not refused, %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestPollingCloseAfterWriteTimeout(t *testing.T) {
+	plt := GetDefaultPollingTransport()
+	plt.SendTimeout = 10 * time.Millisecond
+	url, conns := startPollingServer(t, plt, "abc")
+	poll(t, url)
+	conn := (<-conns).(*PollingConnection)
+
+	conn.CloseAfterWrite(ClosePolicyViolation, "nope")
+	select {
+	case <-conn.closed:
+	case <-time.After(time.Second):
+		t.Fatal("connection without polls is not closed")
+	}
+	if code, _ := poll(t, url+"&sid=abc"); code != http.StatusBadRequest {
+		t.Fatalf("closed connection is served, %d", code)
+	}
+}
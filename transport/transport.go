@@ -1,10 +1,52 @@
 package transport
 
 import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
 	"net/http"
 	"time"
 )
 
+const (
+	/**
+	Text frame, the only one used by socket.io text protocol
+	*/
+	FrameText = iota
+	/**
+	Binary frame, used for binary attachments
+	*/
+	FrameBinary = iota
+)
+
+const (
+	//engine.io names of transports
+	NameWebsocket = "websocket"
+	NamePolling   = "polling"
+
+	//not engine.io one, name of in-memory transport
+	NameMemory = "memory"
+)
+
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+)
+
+const (
+	//greater ping jitter is capped, so interval stays at least half of the set one
+	MaxPingJitter = 50
+)
+
+var (
+	ErrorConnectionClosed = errors.New("Connection closed")
+	ErrorInvalidUTF8      = errors.New("Invalid UTF-8 in text message")
+)
+
 /**
 End-point connection for given transport
 */
@@ -28,6 +70,185 @@ type Connection interface {
 	Get ping time interval and ping request timeout
 	*/
 	PingParams() (interval, timeout time.Duration)
+
+	/**
+	Get address of the remote peer
+	*/
+	RemoteAddr() net.Addr
+
+	/**
+	Get local address, nil if connection is not bound to one
+	*/
+	LocalAddr() net.Addr
+}
+
+/**
+Connection that is able to exchange binary frames as well as text ones
+*/
+type BinaryConnection interface {
+	Connection
+
+	/**
+	Receive one more message of any frame type, block until received
+	*/
+	GetFrame() (message []byte, frameType int, err error)
+
+	/**
+	Send given binary message, block until sent
+	*/
+	WriteBinary(message []byte) error
+}
+
+/**
+Connection that is able to send text message given as bytes,
+without conversion to string
+*/
+type BytesConnection interface {
+	Connection
+
+	/**
+	Send given text message, block until sent, caller is free to reuse
+	message after it returns
+	*/
+	WriteBytes(message []byte) error
+}
+
+/**
+Connection that coalesces writes, made within interval, to one socket write
+*/
+type CoalescingConnection interface {
+	Connection
+
+	/**
+	Get coalescing interval, zero if writes are not coalesced
+	*/
+	CoalescingInterval() time.Duration
+
+	/**
+	Send given text messages with one socket write, block until sent,
+	every message is still delivered to the peer as a separate one
+	*/
+	WriteMessages(messages [][]byte) error
+}
+
+/**
+Connection that is able to close with close handshake, giving the peer a reason
+*/
+type GracefulConnection interface {
+	Connection
+
+	/**
+	Close current connection with given close code and reason
+	*/
+	CloseWithCode(code int, reason string) error
+}
+
+/**
+Connection that is able to close without waiting for the peer,
+for connections nobody reads from
+*/
+type AbortConnection interface {
+	Connection
+
+	/**
+	Send close frame with given code and reason, if transport has one,
+	and close connection once messages written before are delivered,
+	it does not wait for the peer answer
+	*/
+	CloseAfterWrite(code int, reason string)
+}
+
+/**
+Connection that is able to tell close code and text sent by peer
+*/
+type CloseStatusConnection interface {
+	Connection
+
+	/**
+	Get code and text of peer close frame, zero code if none is received
+	*/
+	CloseStatus() (code int, text string)
+}
+
+/**
+Connection that is able to tell jitter of its ping interval
+*/
+type JitterConnection interface {
+	Connection
+
+	/**
+	Get jitter of ping interval in percents, see JitterInterval
+	*/
+	PingJitter() int
+}
+
+/**
+Get interval randomly changed by up to given percent of it, in both
+directions, so pings of connections made at once spread over time,
+percent is capped by MaxPingJitter and by ratio of ping timeout to
+interval, so late ping still comes before peer gives up waiting for it
+*/
+func JitterInterval(interval, timeout time.Duration, percent int) time.Duration {
+	if percent > MaxPingJitter {
+		percent = MaxPingJitter
+	}
+	if interval > 0 && timeout > 0 {
+		if limit := int64(timeout) * 100 / int64(interval); int64(percent) > limit {
+			percent = int(limit)
+		}
+	}
+	delta := int64(interval) * int64(percent) / 100
+	if delta <= 0 {
+		return interval
+	}
+	return interval + time.Duration(rand.Int63n(2*delta+1)-delta)
+}
+
+/**
+Connection that is able to tell engine.io name of its transport
+*/
+type NamedConnection interface {
+	Connection
+
+	/**
+	Get transport name, like NameWebsocket
+	*/
+	TransportName() string
+}
+
+/**
+Connection that is able to tell what was negotiated during handshake
+*/
+type NegotiatedConnection interface {
+	Connection
+
+	/**
+	Get subprotocol, empty if none
+	*/
+	Subprotocol() string
+
+	/**
+	Check that message compression is on
+	*/
+	Compression() bool
+
+	/**
+	Get sizes of socket read and write buffers
+	*/
+	BufferSizes() (read, write int)
+}
+
+/**
+Connection that is able to override compression of one message
+*/
+type CompressionConnection interface {
+	Connection
+
+	/**
+	Send given message compressed or not, regardless of size threshold,
+	compression setting is ignored if it was not negotiated
+	*/
+	WriteMessageCompressed(message string, compress bool) error
 }
 
 /**
@@ -40,7 +261,8 @@ type Transport interface {
 	Connect(url string) (conn Connection, err error)
 
 	/**
-	Handle one server connection
+	Handle one server connection, conn is nil if request belongs
+	to already established connection, like next polling request
 	*/
 	HandleConnection(w http.ResponseWriter, r *http.Request) (conn Connection, err error)
 
@@ -49,3 +271,54 @@ type Transport interface {
 	*/
 	Serve(w http.ResponseWriter, r *http.Request)
 }
+
+/**
+Transport that is able to send extra headers with client handshake
+*/
+type HeaderTransport interface {
+	Transport
+
+	/**
+	Get client connection, given headers are added to transport ones
+	*/
+	ConnectWithHeader(url string, header http.Header) (conn Connection, err error)
+}
+
+/**
+Transport that is able to abort client connect when context is done
+*/
+type ContextTransport interface {
+	Transport
+
+	/**
+	Get client connection, dial and handshake are aborted when ctx is done
+	*/
+	ConnectContext(ctx context.Context, url string) (conn Connection, err error)
+}
+
+/**
+Header transport that is able to abort client connect when context is done
+*/
+type ContextHeaderTransport interface {
+	HeaderTransport
+
+	/**
+	Get client connection with given headers added to transport ones,
+	dial and handshake are aborted when ctx is done
+	*/
+	ConnectWithHeaderContext(ctx context.Context, url string, header http.Header) (
+		conn Connection, err error)
+}
+
+/**
+Address known only as text, like http.Request RemoteAddr or forwarded one
+*/
+type textAddr string
+
+func (a textAddr) Network() string {
+	return "tcp"
+}
+
+func (a textAddr) String() string {
+	return string(a)
+}
@@ -0,0 +1,45 @@
+package transport
+
+import (
+	"testing"
+	"time"
+)
+
+/**
+Check that jittered intervals stay within given bounds
+*/
+func checkJitterBounds(t *testing.T, interval, timeout time.Duration, percent int,
+	min, max time.Duration) {
+
+	for i := 0; i < 1000; i++ {
+		got := JitterInterval(interval, timeout, percent)
+		if got < min || got > max {
+			t.Fatalf("interval %v with jitter %d%% and timeout %v is %v, expected %v..%v",
+				interval, percent, timeout, got, min, max)
+		}
+	}
+}
+
+func TestJitterIntervalBounds(t *testing.T) {
+	second := time.Second
+
+	checkJitterBounds(t, 10*second, 0, 0, 10*second, 10*second)
+	checkJitterBounds(t, 10*second, 0, -10, 10*second, 10*second)
+	checkJitterBounds(t, 10*second, 0, 10, 9*second, 11*second)
+	//capped by MaxPingJitter
+	checkJitterBounds(t, 10*second, 0, 90, 5*second, 15*second)
+	//capped by timeout, late ping comes before peer waits interval and timeout
+	checkJitterBounds(t, 10*second, 2*second, 50, 8*second, 12*second)
+	checkJitterBounds(t, 10*second, 20*second, 30, 7*second, 13*second)
+	checkJitterBounds(t, 0, second, 50, 0, 0)
+}
+
+func TestJitterIntervalSpreads(t *testing.T) {
+	seen := make(map[time.Duration]struct{})
+	for i := 0; i < 100; i++ {
+		seen[JitterInterval(time.Second, time.Second, 20)] = struct{}{}
+	}
+	if len(seen) < 10 {
+		t.Fatalf("only %d different intervals", len(seen))
+	}
+}
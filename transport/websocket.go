@@ -1,11 +1,19 @@
 package transport
 
 import (
-	"errors"
-	"io/ioutil"
+	"bufio"
+	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
 )
@@ -13,11 +21,34 @@ import (
 const (
 	upgradeFailed = "Upgrade failed: "
 
+	headerForwardedFor = "X-Forwarded-For"
+
 	WsDefaultPingInterval   = 30 * time.Second
 	WsDefaultPingTimeout    = 60 * time.Second
 	WsDefaultReceiveTimeout = 60 * time.Second
 	WsDefaultSendTimeout    = 60 * time.Second
 	WsDefaultBufferSize     = 1024 * 32
+	WsDefaultFrameTimeout   = 30 * time.Second
+
+	WsDefaultHandshakeTimeout = 30 * time.Second
+	WsDefaultMaxMessageSize   = 1024 * 1024 * 10
+
+	WsDefaultCompressionThreshold = 256
+
+	WsCloseWaitTimeout = time.Second
+
+	//buffer size used by gorilla for zero ones
+	wsBufferSizeGorilla = 4096
+
+	headerExtensions = "Sec-Websocket-Extensions"
+	extensionDeflate = "permessage-deflate"
+)
+
+const (
+	//compression of message, by threshold or forced
+	compressDefault = iota
+	compressOn
+	compressOff
 )
 
 var (
@@ -26,51 +57,328 @@ var (
 	ErrorPacketWrong       = errors.New("Wrong packet type error")
 	ErrorMethodNotAllowed  = errors.New("Method not allowed")
 	ErrorHttpUpgradeFailed = errors.New("Http upgrade failed")
+	ErrorMessageTooLarge   = errors.New("Message too large")
+	ErrorClientCertMissing = errors.New("Client certificate required")
+	ErrorFrameTimeout      = errors.New("Frame timeout")
 )
 
 type WebsocketConnection struct {
 	socket    *websocket.Conn
 	transport *WebsocketTransport
+
+	//address taken from X-Forwarded-For, if enabled
+	forwardedAddr net.Addr
+
+	//tls state of server side connection, nil for plain http
+	tlsState *tls.ConnectionState
+
+	//negotiated during handshake
+	compression     bool
+	readBufferSize  int
+	writeBufferSize int
+
+	closeReceived     chan struct{}
+	closeReceivedOnce sync.Once
+
+	//close frame of peer, see CloseStatus, close frame sent first by this
+	//side is answered by peer with echo, which is not recorded
+	closeCode     int
+	closeText     string
+	closeSent     bool
+	closeCodeLock sync.Mutex
+
+	//socket is closed once, whatever close is called
+	closeOnce sync.Once
+
+	//deadline of message being read, see FrameTimeout, read deadline
+	//reset by pongs and pings does not go past it, zero if none
+	frameDeadline time.Time
+	deadlineLock  sync.Mutex
+
+	//gorilla connection supports only one concurrent writer
+	writeLock sync.Mutex
+
+	//write coalescing state, see SetWriteCoalescing
+	conn             *holdConn
+	coalesceInterval time.Duration
+	coalesced        *coalescedBatch
+	coalesceLock     sync.Mutex
+}
+
+/**
+Message waiting for coalesced flush
+*/
+type coalescedFrame struct {
+	msgType  int
+	data     []byte
+	compress int
+}
+
+/**
+Messages written within one coalescing interval, writers wait for done
+and get error of the flush
+*/
+type coalescedBatch struct {
+	frames []coalescedFrame
+	done   chan struct{}
+	err    error
+}
+
+/**
+Network connection under websocket, that is able to hold writes and
+send them with one write call, so coalesced frames go in one syscall
+*/
+type holdConn struct {
+	net.Conn
+
+	lock    sync.Mutex
+	holding bool
+	held    bytes.Buffer
+
+	//connection is unusable after failed flush, websocket did not see the error
+	err error
+}
+
+func (hc *holdConn) Write(p []byte) (int, error) {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	if hc.err != nil {
+		return 0, hc.err
+	}
+	if hc.holding {
+		return hc.held.Write(p)
+	}
+	return hc.Conn.Write(p)
+}
+
+/**
+Start holding writes till flush
+*/
+func (hc *holdConn) hold() {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	hc.holding = true
+}
+
+/**
+Write everything held with one call and stop holding
+*/
+func (hc *holdConn) flush() error {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	hc.holding = false
+	defer hc.held.Reset()
+	if hc.err != nil {
+		return hc.err
+	}
+	if hc.held.Len() == 0 {
+		return nil
+	}
+	if _, err := hc.Conn.Write(hc.held.Bytes()); err != nil {
+		hc.err = err
+		return err
+	}
+	return nil
+}
+
+/**
+Response writer, that wraps hijacked connection with holdConn
+*/
+type holdResponseWriter struct {
+	http.ResponseWriter
+	conn *holdConn
+}
+
+func (w *holdResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, ErrorHttpUpgradeFailed
+	}
+	netConn, brw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.conn = &holdConn{Conn: netConn}
+	return w.conn, brw, nil
 }
 type WebsocketTransportParams struct {
 	Headers         http.Header
 	TLSClientConfig *tls.Config
 }
 
-func (wsc *WebsocketConnection) GetMessage() (message string, err error) {
-	wsc.socket.SetReadDeadline(time.Now().Add(wsc.transport.ReceiveTimeout))
+/**
+Buffers for reading incoming messages, shared between connections
+*/
+var readBufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+/**
+Return buffer to pool, too large buffers are left to garbage collector
+*/
+func putReadBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= WsDefaultBufferSize*4 {
+		readBufferPool.Put(buf)
+	}
+}
+
+/**
+Read next message to buffer from pool, buffer should be put back by caller
+*/
+func (wsc *WebsocketConnection) readFrame() (buf *bytes.Buffer, frameType int, err error) {
+	deadline := time.Now().Add(wsc.transport.ReceiveTimeout)
+	wsc.socket.SetReadDeadline(deadline)
 	msgType, reader, err := wsc.socket.NextReader()
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		return nil, 0, ErrorConnectionClosed
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	//message is started, so the rest of it should come in time,
+	//however slowly its bytes are trickled
+	frameDeadline := false
+	if timeout := wsc.transport.FrameTimeout; timeout > 0 {
+		if limit := time.Now().Add(timeout); limit.Before(deadline) {
+			wsc.setFrameDeadline(limit)
+			frameDeadline = true
+		}
+	}
+
+	buf = readBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	_, err = buf.ReadFrom(reader)
+	if frameDeadline {
+		wsc.setFrameDeadline(time.Time{})
+	}
+	if err != nil {
+		putReadBuffer(buf)
+		if err == websocket.ErrReadLimit {
+			return nil, 0, ErrorMessageTooLarge
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() && frameDeadline {
+			return nil, 0, ErrorFrameTimeout
+		}
+		return nil, 0, ErrorBadBuffer
+	}
+
+	switch msgType {
+	case websocket.TextMessage:
+		//empty messages are not allowed
+		if buf.Len() == 0 {
+			putReadBuffer(buf)
+			return nil, 0, ErrorPacketWrong
+		}
+		//websocket requires valid text frames, and gorilla does not check
+		//them, peer is told with invalid payload close as RFC 6455 says
+		if !utf8.Valid(buf.Bytes()) {
+			putReadBuffer(buf)
+			wsc.CloseAfterWrite(CloseInvalidPayload, ErrorInvalidUTF8.Error())
+			return nil, 0, ErrorInvalidUTF8
+		}
+		return buf, FrameText, nil
+	case websocket.BinaryMessage:
+		return buf, FrameBinary, nil
+	}
+
+	putReadBuffer(buf)
+	return nil, 0, ErrorPacketWrong
+}
+
+/**
+Receive one more text or binary message, empty binary messages are valid
+*/
+func (wsc *WebsocketConnection) GetFrame() (message []byte, frameType int, err error) {
+	buf, frameType, err := wsc.readFrame()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer putReadBuffer(buf)
+
+	message = make([]byte, buf.Len())
+	copy(message, buf.Bytes())
+	return message, frameType, nil
+}
+
+func (wsc *WebsocketConnection) GetMessage() (message string, err error) {
+	buf, frameType, err := wsc.readFrame()
 	if err != nil {
 		return "", err
 	}
+	defer putReadBuffer(buf)
 
-	//support only text messages exchange
-	if msgType != websocket.TextMessage {
+	//text only exchange, use GetFrame for binary messages
+	if frameType != FrameText {
 		return "", ErrorBinaryMessage
 	}
 
-	data, err := ioutil.ReadAll(reader)
-	if err != nil {
-		return "", ErrorBadBuffer
+	return buf.String(), nil
+}
+
+/**
+Send message of given websocket type, or add it to the coalesced batch,
+if coalescing is on, and wait till the batch is flushed
+*/
+func (wsc *WebsocketConnection) write(msgType int, data []byte, compress int) error {
+	wsc.coalesceLock.Lock()
+	if wsc.coalesceInterval <= 0 || wsc.conn == nil {
+		wsc.coalesceLock.Unlock()
+		return wsc.writeFrame(msgType, data, compress)
 	}
-	text := string(data)
 
-	//empty messages are not allowed
-	if len(text) == 0 {
-		return "", ErrorPacketWrong
+	batch := wsc.coalesced
+	if batch == nil {
+		batch = &coalescedBatch{done: make(chan struct{})}
+		wsc.coalesced = batch
+		time.AfterFunc(wsc.coalesceInterval, func() {
+			wsc.flushBatch(batch)
+		})
 	}
+	//data is not copied, writer waits till it is flushed
+	batch.frames = append(batch.frames, coalescedFrame{msgType, data, compress})
+	wsc.coalesceLock.Unlock()
 
-	return text, nil
+	<-batch.done
+	return batch.err
 }
 
-func (wsc *WebsocketConnection) WriteMessage(message string) error {
+/**
+Send one message as one websocket frame, safe for concurrent use
+*/
+func (wsc *WebsocketConnection) writeFrame(msgType int, data []byte, compress int) error {
+	wsc.writeLock.Lock()
+	defer wsc.writeLock.Unlock()
+
+	return wsc.writeFrameLocked(msgType, data, compress)
+}
+
+/**
+Send one message as one websocket frame, should be called under writeLock
+*/
+func (wsc *WebsocketConnection) writeFrameLocked(msgType int, data []byte, compress int) error {
+	if wsc.transport.EnableCompression {
+		switch compress {
+		case compressOn:
+			wsc.socket.EnableWriteCompression(true)
+		case compressOff:
+			wsc.socket.EnableWriteCompression(false)
+		default:
+			wsc.socket.EnableWriteCompression(len(data) > wsc.transport.CompressionThreshold)
+		}
+	}
 	wsc.socket.SetWriteDeadline(time.Now().Add(wsc.transport.SendTimeout))
-	writer, err := wsc.socket.NextWriter(websocket.TextMessage)
+	writer, err := wsc.socket.NextWriter(msgType)
 	if err != nil {
 		return err
 	}
 
-	if _, err := writer.Write([]byte(message)); err != nil {
+	if _, err := writer.Write(data); err != nil {
 		return err
 	}
 	if err := writer.Close(); err != nil {
@@ -79,51 +387,781 @@ func (wsc *WebsocketConnection) WriteMessage(message string) error {
 	return nil
 }
 
+/**
+Send frames one after another with one socket write, should be called
+under writeLock, the frames are sent one by one if socket is not held
+*/
+func (wsc *WebsocketConnection) writeFramesLocked(frames []coalescedFrame) error {
+	if wsc.conn == nil {
+		for _, frame := range frames {
+			if err := wsc.writeFrameLocked(frame.msgType, frame.data, frame.compress); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	wsc.conn.hold()
+	var err error
+	for _, frame := range frames {
+		if err = wsc.writeFrameLocked(frame.msgType, frame.data, frame.compress); err != nil {
+			break
+		}
+	}
+	if flushErr := wsc.conn.flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+/**
+Enable write coalescing: messages written within given interval after the
+first one are sent together, with one socket write. Every message is still
+sent as its own websocket frame, so message boundaries are preserved, as
+socket.io expects one packet per frame. Write blocks till its batch is
+flushed and returns error of the flush. Zero disables coalescing, and
+flushes the batch being gathered at once.
+*/
+func (wsc *WebsocketConnection) SetWriteCoalescing(d time.Duration) {
+	wsc.coalesceLock.Lock()
+	wsc.coalesceInterval = d
+	batch := wsc.coalesced
+	wsc.coalesceLock.Unlock()
+
+	if d <= 0 && batch != nil {
+		wsc.flushBatch(batch)
+	}
+}
+
+/**
+Get interval set by SetWriteCoalescing, zero if writes are not coalesced
+*/
+func (wsc *WebsocketConnection) CoalescingInterval() time.Duration {
+	wsc.coalesceLock.Lock()
+	defer wsc.coalesceLock.Unlock()
+
+	if wsc.conn == nil {
+		return 0
+	}
+	return wsc.coalesceInterval
+}
+
+/**
+Send batch and wake its writers, if it is not flushed yet
+*/
+func (wsc *WebsocketConnection) flushBatch(batch *coalescedBatch) {
+	wsc.coalesceLock.Lock()
+	if wsc.coalesced != batch {
+		//flushed already, when coalescing was disabled
+		wsc.coalesceLock.Unlock()
+		return
+	}
+	wsc.coalesced = nil
+
+	//taken before the next batch could be started, so batches go in order
+	wsc.writeLock.Lock()
+	wsc.coalesceLock.Unlock()
+
+	batch.err = wsc.writeFramesLocked(batch.frames)
+	wsc.writeLock.Unlock()
+	close(batch.done)
+}
+
+/**
+Send given text messages at once, with one socket write, without waiting
+for coalescing interval
+*/
+func (wsc *WebsocketConnection) WriteMessages(messages [][]byte) error {
+	frames := make([]coalescedFrame, len(messages))
+	for i, message := range messages {
+		frames[i] = coalescedFrame{websocket.TextMessage, message, compressDefault}
+	}
+
+	wsc.writeLock.Lock()
+	defer wsc.writeLock.Unlock()
+
+	return wsc.writeFramesLocked(frames)
+}
+
+func (wsc *WebsocketConnection) WriteMessage(message string) error {
+	return wsc.write(websocket.TextMessage, []byte(message), compressDefault)
+}
+
+func (wsc *WebsocketConnection) WriteBytes(message []byte) error {
+	return wsc.write(websocket.TextMessage, message, compressDefault)
+}
+
+func (wsc *WebsocketConnection) WriteMessageCompressed(message string, compress bool) error {
+	if compress {
+		return wsc.write(websocket.TextMessage, []byte(message), compressOn)
+	}
+	return wsc.write(websocket.TextMessage, []byte(message), compressOff)
+}
+
+func (wsc *WebsocketConnection) WriteBinary(message []byte) error {
+	return wsc.write(websocket.BinaryMessage, message, compressDefault)
+}
+
+/**
+Hard close, drops connection without close handshake
+*/
 func (wsc *WebsocketConnection) Close() {
-	wsc.socket.Close()
+	wsc.closeOnce.Do(func() {
+		wsc.socket.Close()
+	})
+}
+
+/**
+Graceful close, sends close frame with given code and reason, waits
+briefly for the peer to answer with close and then closes connection
+*/
+func (wsc *WebsocketConnection) CloseWithCode(code int, reason string) error {
+	defer wsc.Close()
+
+	wsc.markCloseSent()
+	err := wsc.socket.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(wsc.transport.SendTimeout))
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-wsc.closeReceived:
+	case <-time.After(WsCloseWaitTimeout):
+	}
+	return nil
+}
+
+/**
+Send close frame with given code and reason and close socket at once,
+without waiting for close frame of the peer, as nobody reads it
+*/
+func (wsc *WebsocketConnection) CloseAfterWrite(code int, reason string) {
+	defer wsc.Close()
+
+	wsc.markCloseSent()
+	wsc.socket.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(wsc.transport.SendTimeout))
+}
+
+/**
+Remember that close frame is sent first by this side, so its echo
+is not taken as close status of the peer
+*/
+func (wsc *WebsocketConnection) markCloseSent() {
+	wsc.closeCodeLock.Lock()
+	defer wsc.closeCodeLock.Unlock()
+
+	if wsc.closeCode == 0 {
+		wsc.closeSent = true
+	}
+}
+
+/**
+Answer peer close frame, as default close handler does, and notify
+CloseWithCode that close handshake is complete, echo of close frame
+sent by this side is not answered and not recorded
+*/
+func (wsc *WebsocketConnection) handleClose(code int, text string) error {
+	wsc.closeCodeLock.Lock()
+	echo := wsc.closeSent
+	if !echo {
+		wsc.closeCode, wsc.closeText = code, text
+	}
+	wsc.closeCodeLock.Unlock()
+
+	wsc.closeReceivedOnce.Do(func() {
+		close(wsc.closeReceived)
+	})
+
+	if !echo {
+		wsc.socket.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(code, ""),
+			time.Now().Add(wsc.transport.SendTimeout))
+	}
+	return nil
+}
+
+/**
+Get code and text of peer close frame, like CloseGoingAway of reloaded
+page, zero code if peer did not send close frame or only answered close
+frame of this side
+*/
+func (wsc *WebsocketConnection) CloseStatus() (code int, text string) {
+	wsc.closeCodeLock.Lock()
+	defer wsc.closeCodeLock.Unlock()
+
+	return wsc.closeCode, wsc.closeText
+}
+
+func (wsc *WebsocketConnection) TransportName() string {
+	return NameWebsocket
 }
 
 func (wsc *WebsocketConnection) PingParams() (interval, timeout time.Duration) {
 	return wsc.transport.PingInterval, wsc.transport.PingTimeout
 }
 
+func (wsc *WebsocketConnection) PingJitter() int {
+	return wsc.transport.PingJitter
+}
+
+/**
+Set handler for websocket ping control frames, nil means default one,
+which answers with pong
+*/
+func (wsc *WebsocketConnection) SetPingHandler(h func(appData string) error) {
+	wsc.socket.SetPingHandler(h)
+}
+
+/**
+Set deadline of message being read and apply it to the socket, zero
+one means message is read, socket deadline is left as is then
+*/
+func (wsc *WebsocketConnection) setFrameDeadline(deadline time.Time) {
+	wsc.deadlineLock.Lock()
+	defer wsc.deadlineLock.Unlock()
+
+	wsc.frameDeadline = deadline
+	if !deadline.IsZero() {
+		wsc.socket.SetReadDeadline(deadline)
+	}
+}
+
+/**
+Set read deadline, that does not go past deadline of message being read,
+so pongs received between its fragments don't extend it
+*/
+func (wsc *WebsocketConnection) setReadDeadline(deadline time.Time) {
+	wsc.deadlineLock.Lock()
+	defer wsc.deadlineLock.Unlock()
+
+	if !wsc.frameDeadline.IsZero() && wsc.frameDeadline.Before(deadline) {
+		deadline = wsc.frameDeadline
+	}
+	wsc.socket.SetReadDeadline(deadline)
+}
+
+/**
+Set handler for websocket pong control frames, read deadline is reset
+on every pong before the handler is called, but not past FrameTimeout
+of message being read
+*/
+func (wsc *WebsocketConnection) SetPongHandler(h func(appData string) error) {
+	wsc.socket.SetPongHandler(func(appData string) error {
+		wsc.setReadDeadline(time.Now().Add(wsc.transport.ReceiveTimeout))
+		if h == nil {
+			return nil
+		}
+		return h(appData)
+	})
+}
+
+/**
+Send websocket ping control frame, peer should answer with pong
+within ping timeout, or the next read fails
+*/
+func (wsc *WebsocketConnection) Ping() error {
+	now := time.Now()
+	err := wsc.socket.WriteControl(websocket.PingMessage, nil, now.Add(wsc.transport.SendTimeout))
+	if err != nil {
+		return err
+	}
+
+	pongDeadline := now.Add(wsc.transport.PingTimeout)
+	if pongDeadline.Before(now.Add(wsc.transport.ReceiveTimeout)) {
+		wsc.setReadDeadline(pongDeadline)
+	}
+	return nil
+}
+
+/**
+Send control pings every ping interval until connection is closed
+*/
+func (wsc *WebsocketConnection) keepAlive() {
+	for {
+		time.Sleep(JitterInterval(wsc.transport.PingInterval, wsc.transport.PingTimeout,
+			wsc.transport.PingJitter))
+		if err := wsc.Ping(); err != nil {
+			return
+		}
+	}
+}
+
+/**
+Get remote address, forwarded one is preferred if UseForwardedFor is set
+*/
+func (wsc *WebsocketConnection) RemoteAddr() net.Addr {
+	if wsc.forwardedAddr != nil {
+		return wsc.forwardedAddr
+	}
+	return wsc.socket.RemoteAddr()
+}
+
+func (wsc *WebsocketConnection) LocalAddr() net.Addr {
+	return wsc.socket.LocalAddr()
+}
+
+/**
+Get tls state of the upgraded server connection, nil if it is not tls one
+*/
+func (wsc *WebsocketConnection) TLSConnectionState() *tls.ConnectionState {
+	return wsc.tlsState
+}
+
+/**
+Get subject of verified client certificate, empty if there is none
+*/
+func (wsc *WebsocketConnection) PeerCertificateSubject() string {
+	if wsc.tlsState == nil || len(wsc.tlsState.VerifiedChains) == 0 ||
+		len(wsc.tlsState.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	return wsc.tlsState.VerifiedChains[0][0].Subject.String()
+}
+
+/**
+Get subprotocol negotiated during handshake, empty if none
+*/
+func (wsc *WebsocketConnection) Subprotocol() string {
+	return wsc.socket.Subprotocol()
+}
+
+/**
+Check that permessage-deflate was negotiated during handshake
+*/
+func (wsc *WebsocketConnection) Compression() bool {
+	return wsc.compression
+}
+
+/**
+Get sizes of socket read and write buffers
+*/
+func (wsc *WebsocketConnection) BufferSizes() (read, write int) {
+	return wsc.readBufferSize, wsc.writeBufferSize
+}
+
+/**
+Record handshake results, deflate is negotiated if it is enabled
+and given header of the peer offers or accepts it
+*/
+func (wsc *WebsocketConnection) setNegotiated(deflate bool, header http.Header,
+	readBufferSize, writeBufferSize int) {
+
+	if deflate {
+		for _, value := range header[headerExtensions] {
+			if strings.Contains(value, extensionDeflate) {
+				wsc.compression = true
+			}
+		}
+	}
+
+	if readBufferSize <= 0 {
+		readBufferSize = wsBufferSizeGorilla
+	}
+	if writeBufferSize <= 0 {
+		writeBufferSize = wsBufferSizeGorilla
+	}
+	wsc.readBufferSize, wsc.writeBufferSize = readBufferSize, writeBufferSize
+}
+
 type WebsocketTransport struct {
 	PingInterval   time.Duration
 	PingTimeout    time.Duration
 	ReceiveTimeout time.Duration
 	SendTimeout    time.Duration
 	BufferSize     int
+
+	//every ping interval is randomly changed by up to this percent of
+	//PingInterval, so pings of clients connected at once do not align,
+	//peers are still told PingInterval, capped by MaxPingJitter and
+	//by PingTimeout, see JitterInterval
+	PingJitter int
+
+	//socket buffer sizes for server upgrades and client dials,
+	//BufferSize is used for zero ones
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	//client dial handshake timeout, zero means no timeout
+	HandshakeTimeout time.Duration
+
+	//negotiate permessage-deflate with given level, zero level is the default one
+	EnableCompression bool
+	CompressionLevel  int
+
+	//compress only messages larger than threshold in bytes, zero compresses all
+	CompressionThreshold int
+
+	//proxy for client connections, nil means direct connection
+	Proxy func(*http.Request) (*url.URL, error)
+
+	//origin check for server upgrades, nil allows any origin
+	CheckOrigin func(r *http.Request) bool
+
+	//take remote address from X-Forwarded-For header, for servers behind proxy
+	UseForwardedFor bool
+
+	//reject server upgrades without verified client certificate,
+	//http.Server should be configured to request them, see TlsServerConfig
+	RequireClientCert bool
+
+	//maximum size of incoming message in bytes, zero means no limit
+	MaxMessageSize int64
+
+	//time to receive the rest of started message, connection is closed
+	//with ErrorFrameTimeout if it is not complete in time, zero means
+	//only ReceiveTimeout applies
+	FrameTimeout time.Duration
+
+	//maximum size of server handshake request uri and headers in bytes,
+	//zero means no limit
+	MaxHeaderBytes int
+
+	//send websocket control pings every PingInterval, so silently dead
+	//peer is disconnected within PingTimeout
+	ControlPings bool
+
+	//custom dialer for client connections, if set HandshakeTimeout,
+	//EnableCompression, Proxy and Subprotocols are taken from it,
+	//TLSClientConfig is used only if dialer has none, Headers are still sent
+	Dialer *websocket.Dialer
+
+	//subprotocols requested by client or supported by server, in order of preference
+	Subprotocols []string
+
+	//pool of write buffers shared between connections, nil means
+	//every connection holds its own buffer
+	WriteBufferPool websocket.BufferPool
+
+	//coalesce writes of connections made within given interval,
+	//see SetWriteCoalescing, zero means every write is sent at once
+	WriteCoalescing time.Duration
+
 	Headers         http.Header
-	TLSClientConfig  *tls.Config
+	TLSClientConfig *tls.Config
 }
 
 func (wst *WebsocketTransport) Connect(url string) (conn Connection, err error) {
-    dialer := websocket.Dialer{TLSClientConfig: wst.TLSClientConfig}
-	socket, _, err := dialer.Dial(url, wst.Headers)
+	return wst.ConnectContext(context.Background(), url)
+}
+
+/**
+Connect with given context, cancelling the context aborts the dial
+including tls handshake
+*/
+func (wst *WebsocketTransport) ConnectContext(ctx context.Context, url string) (
+	conn Connection, err error) {
+
+	conn, _, err = wst.dial(ctx, url)
+	return conn, err
+}
+
+/**
+Connect and return handshake response as well, response is preserved
+on handshake failure so status code and headers could be inspected
+*/
+func (wst *WebsocketTransport) ConnectWithResponse(url string) (
+	conn Connection, resp *http.Response, err error) {
+
+	return wst.dial(context.Background(), url)
+}
+
+/**
+Connect with extra handshake headers, they replace transport
+headers of the same name
+*/
+func (wst *WebsocketTransport) ConnectWithHeader(url string, header http.Header) (
+	conn Connection, err error) {
+
+	return wst.ConnectWithHeaderContext(context.Background(), url, header)
+}
+
+/**
+Connect with extra handshake headers and given context, cancelling
+the context aborts the dial
+*/
+func (wst *WebsocketTransport) ConnectWithHeaderContext(ctx context.Context, url string,
+	header http.Header) (conn Connection, err error) {
+
+	headers := http.Header{}
+	for name, values := range wst.Headers {
+		headers[name] = values
+	}
+	for name, values := range header {
+		headers[name] = values
+	}
+
+	conn, _, err = wst.dialWithHeader(ctx, url, headers, nil)
+	return conn, err
+}
+
+/**
+Connect over already established connection, like tunneled one, instead
+of dialing host of url, the url is still used for handshake request,
+and tls handshake is made over the connection for wss url.
+Proxy is not used, connection is closed if handshake fails.
+*/
+func (wst *WebsocketTransport) ConnectOverConn(netConn net.Conn, url string) (
+	conn Connection, err error) {
+
+	conn, _, err = wst.dialWithHeader(context.Background(), url, wst.Headers, netConn)
+	return conn, err
+}
+
+func (wst *WebsocketTransport) readBufferSize() int {
+	if wst.ReadBufferSize > 0 {
+		return wst.ReadBufferSize
+	}
+	return wst.BufferSize
+}
+
+func (wst *WebsocketTransport) writeBufferSize() int {
+	if wst.WriteBufferSize > 0 {
+		return wst.WriteBufferSize
+	}
+	return wst.BufferSize
+}
+
+func (wst *WebsocketTransport) dial(ctx context.Context, url string) (
+	conn Connection, resp *http.Response, err error) {
+
+	return wst.dialWithHeader(ctx, url, wst.Headers, nil)
+}
+
+/**
+Dial url with given headers, over given connection if it is not nil
+*/
+func (wst *WebsocketTransport) dialWithHeader(ctx context.Context, url string,
+	header http.Header, netConn net.Conn) (conn Connection, resp *http.Response, err error) {
+
+	dialer := websocket.Dialer{
+		ReadBufferSize:    wst.readBufferSize(),
+		WriteBufferSize:   wst.writeBufferSize(),
+		TLSClientConfig:   wst.TLSClientConfig,
+		HandshakeTimeout:  wst.HandshakeTimeout,
+		EnableCompression: wst.EnableCompression,
+		Proxy:             wst.Proxy,
+		Subprotocols:      wst.Subprotocols,
+		WriteBufferPool:   wst.WriteBufferPool,
+	}
+	if wst.Dialer != nil {
+		dialer = *wst.Dialer
+		if dialer.TLSClientConfig == nil {
+			dialer.TLSClientConfig = wst.TLSClientConfig
+		}
+	}
+	if netConn != nil {
+		//tls, if any, is made by dialer over the connection
+		dialer.Proxy = nil
+		dialer.NetDial = nil
+		dialer.NetDialTLSContext = nil
+		dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return netConn, nil
+		}
+	}
+	held, stopWatch := holdDialer(ctx, &dialer)
+	socket, resp, err := dialer.DialContext(ctx, url, header)
+	stopWatch()
+	if err == nil && ctx.Err() != nil {
+		//connection could be aborted right after handshake
+		socket.Close()
+		err = ctx.Err()
+	}
 	if err != nil {
-		return nil, err
+		if ctx.Err() != nil {
+			return nil, resp, ctx.Err()
+		}
+		return nil, resp, err
+	}
+
+	wsc := wst.newConnection(socket, *held)
+	wsc.setNegotiated(dialer.EnableCompression, resp.Header,
+		dialer.ReadBufferSize, dialer.WriteBufferSize)
+
+	return wsc, resp, nil
+}
+
+/**
+Wrap connections made by dialer with holdConn, the last made one is
+stored to returned pointer, it is the one websocket is running over.
+Dialer does not watch context while handshake is read, so connection
+is aborted when ctx is done till returned func is called after dial.
+*/
+func holdDialer(ctx context.Context, dialer *websocket.Dialer) (**holdConn, func()) {
+	conn := new(*holdConn)
+	stopWatch := func() {}
+	wrap := func(netConn net.Conn, err error) (net.Conn, error) {
+		if err != nil {
+			return nil, err
+		}
+		stopWatch()
+		stopWatch = abortOnDone(ctx, netConn)
+		*conn = &holdConn{Conn: netConn}
+		return *conn, nil
+	}
+
+	if dial := dialer.NetDialTLSContext; dial != nil {
+		dialer.NetDialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return wrap(dial(ctx, network, addr))
+		}
 	}
+	switch {
+	case dialer.NetDialContext != nil:
+		dial := dialer.NetDialContext
+		dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return wrap(dial(ctx, network, addr))
+		}
+	case dialer.NetDial != nil:
+		dial := dialer.NetDial
+		dialer.NetDial = func(network, addr string) (net.Conn, error) {
+			return wrap(dial(network, addr))
+		}
+	default:
+		netDialer := &net.Dialer{}
+		dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return wrap(netDialer.DialContext(ctx, network, addr))
+		}
+	}
+	return conn, func() { stopWatch() }
+}
 
-	
+/**
+Expire deadline of connection when ctx is done, so blocked handshake
+read or write fails, returned func stops watching and waits for it
+*/
+func abortOnDone(ctx context.Context, netConn net.Conn) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
 
-	return &WebsocketConnection{socket, wst}, nil
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			netConn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+type upgradeHeaderKey struct{}
+
+/**
+Get request, which successful websocket upgrade is answered with given
+headers, like cookies of accepted connection, error answers do not
+carry them
+*/
+func WithUpgradeHeader(r *http.Request, header http.Header) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), upgradeHeaderKey{}, header))
+}
+
+/**
+Get cookies set on response writer before upgrade and headers given by
+WithUpgradeHeader, hijacked connection does not send headers of writer,
+so they are passed to upgrader
+*/
+func upgradeHeader(w http.ResponseWriter, r *http.Request) http.Header {
+	header := http.Header{}
+	if cookies := w.Header()["Set-Cookie"]; len(cookies) > 0 {
+		header["Set-Cookie"] = cookies
+	}
+	if extra, ok := r.Context().Value(upgradeHeaderKey{}).(http.Header); ok {
+		for name, values := range extra {
+			header[name] = append(header[name], values...)
+		}
+	}
+	if len(header) == 0 {
+		return nil
+	}
+	return header
 }
 
 func (wst *WebsocketTransport) HandleConnection(
 	w http.ResponseWriter, r *http.Request) (conn Connection, err error) {
 
+	if err := checkRequest(w, r, NameWebsocket, wst.MaxHeaderBytes); err != nil {
+		return nil, err
+	}
 	if r.Method != "GET" {
-		http.Error(w, upgradeFailed+ErrorMethodNotAllowed.Error(), 503)
+		writeEngineIOError(w, http.StatusBadRequest, ErrorCodeBadHandshakeMethod, "Bad handshake method")
 		return nil, ErrorMethodNotAllowed
 	}
 
-	socket, err := websocket.Upgrade(w, r, nil, wst.BufferSize, wst.BufferSize)
+	if wst.RequireClientCert && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		http.Error(w, upgradeFailed+ErrorClientCertMissing.Error(), http.StatusUnauthorized)
+		return nil, ErrorClientCertMissing
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:    wst.readBufferSize(),
+		WriteBufferSize:   wst.writeBufferSize(),
+		EnableCompression: wst.EnableCompression,
+		CheckOrigin:       wst.CheckOrigin,
+		Subprotocols:      wst.Subprotocols,
+		WriteBufferPool:   wst.WriteBufferPool,
+		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+			http.Error(w, upgradeFailed+reason.Error(), 503)
+		},
+	}
+	if upgrader.CheckOrigin == nil {
+		//allow all connections, as websocket.Upgrade does
+		upgrader.CheckOrigin = func(r *http.Request) bool {
+			return true
+		}
+	}
+	hw := &holdResponseWriter{ResponseWriter: w}
+	socket, err := upgrader.Upgrade(hw, r, upgradeHeader(w, r))
 	if err != nil {
-		http.Error(w, upgradeFailed+err.Error(), 503)
 		return nil, ErrorHttpUpgradeFailed
 	}
 
-	return &WebsocketConnection{socket, wst}, nil
+	wsc := wst.newConnection(socket, hw.conn)
+	wsc.tlsState = r.TLS
+	wsc.setNegotiated(upgrader.EnableCompression, r.Header,
+		upgrader.ReadBufferSize, upgrader.WriteBufferSize)
+	if forwarded := r.Header.Get(headerForwardedFor); wst.UseForwardedFor && forwarded != "" {
+		//first address is the client one, the rest are proxies
+		wsc.forwardedAddr = textAddr(strings.TrimSpace(strings.Split(forwarded, ",")[0]))
+	}
+
+	return wsc, nil
+}
+
+/**
+Wrap established socket and apply connection level params
+*/
+func (wst *WebsocketTransport) newConnection(socket *websocket.Conn, conn *holdConn) *WebsocketConnection {
+	if wst.MaxMessageSize > 0 {
+		socket.SetReadLimit(wst.MaxMessageSize)
+	}
+	if wst.EnableCompression && wst.CompressionLevel != 0 {
+		socket.SetCompressionLevel(wst.CompressionLevel)
+	}
+
+	wsc := &WebsocketConnection{
+		socket:           socket,
+		transport:        wst,
+		closeReceived:    make(chan struct{}),
+		conn:             conn,
+		coalesceInterval: wst.WriteCoalescing,
+	}
+	wsc.SetPongHandler(nil)
+	socket.SetCloseHandler(wsc.handleClose)
+	if wst.ControlPings {
+		go wsc.keepAlive()
+	}
+
+	return wsc
 }
 
 /**
@@ -141,6 +1179,14 @@ func GetDefaultWebsocketTransport() *WebsocketTransport {
 		ReceiveTimeout: WsDefaultReceiveTimeout,
 		SendTimeout:    WsDefaultSendTimeout,
 		BufferSize:     WsDefaultBufferSize,
+
+		HandshakeTimeout: WsDefaultHandshakeTimeout,
+		Proxy:            http.ProxyFromEnvironment,
+		MaxMessageSize:   WsDefaultMaxMessageSize,
+		MaxHeaderBytes:   DefaultMaxHeaderBytes,
+		FrameTimeout:     WsDefaultFrameTimeout,
+
+		CompressionThreshold: WsDefaultCompressionThreshold,
 	}
 }
 
@@ -150,3 +1196,24 @@ func TlsWebsocketTransport(Headers http.Header ,TLSClientConfig *tls.Config) *We
 	tr.TLSClientConfig = TLSClientConfig
 	return tr
 }
+
+/**
+Returns tls config for http.Server, that requires client certificates
+signed by given authorities
+*/
+func TlsServerConfig(clientCAs *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		ClientCAs:  clientCAs,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}
+}
+
+/**
+Returns websocket transport, that accepts only connections with
+verified client certificate
+*/
+func TlsServerWebsocketTransport() *WebsocketTransport {
+	tr := GetDefaultWebsocketTransport()
+	tr.RequireClientCert = true
+	return tr
+}
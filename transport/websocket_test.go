@@ -0,0 +1,595 @@
+package transport
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/**
+Start websocket server, that echoes every text message back
+*/
+func startEchoServer(t *testing.T, tr *WebsocketTransport) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := tr.HandleConnection(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			message, err := conn.GetMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(message); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+/**
+Make connected pair of websocket connections with given transports
+*/
+func websocketPair(tb testing.TB, serverTr, clientTr *WebsocketTransport) (
+	client, server *WebsocketConnection) {
+
+	accepted := make(chan *WebsocketConnection, 1)
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := serverTr.HandleConnection(w, r)
+		if err != nil {
+			accepted <- nil
+			return
+		}
+		accepted <- conn.(*WebsocketConnection)
+	}))
+	tb.Cleanup(httpServer.Close)
+
+	conn, err := clientTr.Connect(wsURL(httpServer))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	server = <-accepted
+	if server == nil {
+		tb.Fatal("server connection is not accepted")
+	}
+	client = conn.(*WebsocketConnection)
+	tb.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func wsURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/socket.io/?EIO=3&transport=websocket"
+}
+
+/**
+Start http CONNECT proxy, it counts tunnels it has made to the target
+*/
+func startConnectProxy(t *testing.T, target string, tunnels *int32) *url.URL {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			client, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer client.Close()
+				reader := bufio.NewReader(client)
+				req, err := http.ReadRequest(reader)
+				if err != nil || req.Method != http.MethodConnect || req.Host != target {
+					io.WriteString(client, "HTTP/1.1 400 Bad Request\r\n\r\n")
+					return
+				}
+				upstream, err := net.Dial("tcp", target)
+				if err != nil {
+					io.WriteString(client, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer upstream.Close()
+				atomic.AddInt32(tunnels, 1)
+				io.WriteString(client, "HTTP/1.1 200 Connection established\r\n\r\n")
+
+				go io.Copy(upstream, reader)
+				io.Copy(client, upstream)
+			}()
+		}
+	}()
+
+	return &url.URL{Scheme: "http", Host: listener.Addr().String()}
+}
+
+func TestConnectThroughProxy(t *testing.T) {
+	server := startEchoServer(t, GetDefaultWebsocketTransport())
+
+	var tunnels int32
+	proxyURL := startConnectProxy(t, strings.TrimPrefix(server.URL, "http://"), &tunnels)
+
+	tr := GetDefaultWebsocketTransport()
+	tr.Proxy = http.ProxyURL(proxyURL)
+	conn, err := tr.Connect(wsURL(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage("42[\"test\"]"); err != nil {
+		t.Fatal(err)
+	}
+	message, err := conn.GetMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message != "42[\"test\"]" {
+		t.Fatalf("unexpected echo %q", message)
+	}
+	if n := atomic.LoadInt32(&tunnels); n != 1 {
+		t.Fatalf("expected 1 proxy tunnel, got %d", n)
+	}
+}
+
+func TestConnectWithoutProxy(t *testing.T) {
+	server := startEchoServer(t, GetDefaultWebsocketTransport())
+
+	tr := GetDefaultWebsocketTransport()
+	tr.Proxy = nil
+	conn, err := tr.Connect(wsURL(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestDefaultConstructors(t *testing.T) {
+	var tr *WebsocketTransport = GetDefaultWebsocketTransport()
+	if tr.PingInterval != WsDefaultPingInterval || tr.BufferSize != WsDefaultBufferSize {
+		t.Fatal("default transport params are not set")
+	}
+
+	headers := http.Header{"X-Test": []string{"1"}}
+	tlsConfig := &tls.Config{ServerName: "example.com"}
+	tr = TlsWebsocketTransport(headers, tlsConfig)
+	if tr.TLSClientConfig != tlsConfig || tr.Headers.Get("X-Test") != "1" {
+		t.Fatal("tls transport params are not set")
+	}
+	if tr.PingInterval != WsDefaultPingInterval {
+		t.Fatal("tls transport has no default params")
+	}
+}
+
+/**
+Peer sends given message until connection is closed
+*/
+func benchmarkSender(conn *WebsocketConnection, message string) {
+	go func() {
+		for conn.WriteMessage(message) == nil {
+		}
+	}()
+}
+
+func BenchmarkGetMessagePooled(b *testing.B) {
+	client, server := websocketPair(b, GetDefaultWebsocketTransport(), GetDefaultWebsocketTransport())
+	benchmarkSender(server, strings.Repeat("x", 1024))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.GetMessage(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+//read as it was done before pooling, for comparison
+func BenchmarkGetMessageReadAll(b *testing.B) {
+	client, server := websocketPair(b, GetDefaultWebsocketTransport(), GetDefaultWebsocketTransport())
+	benchmarkSender(server, strings.Repeat("x", 1024))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, reader, err := client.socket.NextReader()
+		if err != nil {
+			b.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = string(data)
+	}
+}
+
+func TestCloseStatusOfPeerOnly(t *testing.T) {
+	client, server := websocketPair(t, GetDefaultWebsocketTransport(), GetDefaultWebsocketTransport())
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		for {
+			if _, err := client.GetMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		for {
+			if _, err := server.GetMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err := server.CloseWithCode(4000, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	<-readDone
+
+	if code, text := client.CloseStatus(); code != 4000 || text != "bye" {
+		t.Fatalf("expected peer close 4000 bye, got %d %q", code, text)
+	}
+	if code, text := server.CloseStatus(); code != 0 {
+		t.Fatalf("echo of own close frame is recorded, %d %q", code, text)
+	}
+}
+
+func TestInvalidUTF8ClosesWithInvalidPayload(t *testing.T) {
+	client, server := websocketPair(t, GetDefaultWebsocketTransport(), GetDefaultWebsocketTransport())
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		for {
+			if _, err := client.GetMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err := client.WriteMessage("42[\"ok\",\"é世\"]"); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := server.GetMessage(); err != nil || msg != "42[\"ok\",\"é世\"]" {
+		t.Fatalf("valid multibyte text is not received, %q %v", msg, err)
+	}
+
+	if err := client.WriteMessage("42[\"bad\",\"\xff\xfe\"]"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.GetMessage(); err != ErrorInvalidUTF8 {
+		t.Fatalf("expected ErrorInvalidUTF8, got %v", err)
+	}
+	<-readDone
+
+	if code, _ := client.CloseStatus(); code != CloseInvalidPayload {
+		t.Fatalf("expected invalid payload close %d, got %d", CloseInvalidPayload, code)
+	}
+}
+
+/**
+Client websocket frame with zero mask, so payload is written as is
+*/
+func maskedFrame(opcode byte, fin bool, payload string) []byte {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first, 0x80 | byte(len(payload)), 0, 0, 0, 0}
+	return append(frame, payload...)
+}
+
+func TestFrameTimeoutWithPongs(t *testing.T) {
+	serverTr := GetDefaultWebsocketTransport()
+	serverTr.FrameTimeout = 200 * time.Millisecond
+	serverTr.ReceiveTimeout = 5 * time.Second
+	client, server := websocketPair(t, serverTr, GetDefaultWebsocketTransport())
+
+	//message is trickled in fragments with pongs between them
+	raw := client.socket.UnderlyingConn()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		if _, err := raw.Write(maskedFrame(1, false, "42[")); err != nil {
+			return
+		}
+		for end := time.Now().Add(2 * time.Second); time.Now().Before(end); {
+			select {
+			case <-stop:
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+			if _, err := raw.Write(maskedFrame(10, true, "")); err != nil {
+				return
+			}
+			if _, err := raw.Write(maskedFrame(0, false, "1,")); err != nil {
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	if _, err := server.GetMessage(); err != ErrorFrameTimeout {
+		t.Fatalf("expected ErrorFrameTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("frame timeout is extended by pongs, message failed after %v", elapsed)
+	}
+}
+
+/**
+Peer reads messages until connection is closed
+*/
+func benchmarkReceiver(conn *WebsocketConnection) {
+	go func() {
+		for {
+			if _, err := conn.GetMessage(); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+const benchmarkPacket = `421["event",{"id":1,"name":"event","tags":["a","b"]}]`
+
+func BenchmarkWriteBytes(b *testing.B) {
+	client, server := websocketPair(b, GetDefaultWebsocketTransport(), GetDefaultWebsocketTransport())
+	benchmarkReceiver(server)
+	data := []byte(benchmarkPacket)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := client.WriteBytes(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+//written as string, as emit did before bytes path, for comparison
+func BenchmarkWriteMessage(b *testing.B) {
+	client, server := websocketPair(b, GetDefaultWebsocketTransport(), GetDefaultWebsocketTransport())
+	benchmarkReceiver(server)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := client.WriteMessage(benchmarkPacket); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+/**
+Network connection, that counts write calls
+*/
+type countingConn struct {
+	net.Conn
+	writes int32
+}
+
+func (cc *countingConn) Write(p []byte) (int, error) {
+	atomic.AddInt32(&cc.writes, 1)
+	return cc.Conn.Write(p)
+}
+
+func countWrites(wsc *WebsocketConnection) *countingConn {
+	counter := &countingConn{Conn: wsc.conn.Conn}
+	wsc.conn.Conn = counter
+	return counter
+}
+
+func receiveMessages(t *testing.T, conn *WebsocketConnection, n int) map[string]bool {
+	received := make(map[string]bool)
+	for i := 0; i < n; i++ {
+		message, err := conn.GetMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		received[message] = true
+	}
+	return received
+}
+
+func TestWriteCoalescingKeepsBoundaries(t *testing.T) {
+	clientTr := GetDefaultWebsocketTransport()
+	clientTr.WriteCoalescing = 100 * time.Millisecond
+	client, server := websocketPair(t, GetDefaultWebsocketTransport(), clientTr)
+	counter := countWrites(client)
+
+	const writers = 10
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			errs <- client.WriteMessage(fmt.Sprintf("42[\"message\",%d]", i))
+		}(i)
+	}
+	for i := 0; i < writers; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	received := receiveMessages(t, server, writers)
+	for i := 0; i < writers; i++ {
+		if !received[fmt.Sprintf("42[\"message\",%d]", i)] {
+			t.Fatalf("message %d is not received as a separate frame", i)
+		}
+	}
+	if n := atomic.LoadInt32(&counter.writes); n != 1 {
+		t.Fatalf("expected 1 socket write, got %d", n)
+	}
+}
+
+func TestWriteMessagesSingleWrite(t *testing.T) {
+	client, server := websocketPair(t, GetDefaultWebsocketTransport(), GetDefaultWebsocketTransport())
+	counter := countWrites(client)
+
+	messages := [][]byte{[]byte("2"), []byte("42[\"a\"]"), []byte("42[\"b\"]")}
+	if err := client.WriteMessages(messages); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range messages {
+		message, err := server.GetMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message != string(expected) {
+			t.Fatalf("expected %q, got %q", expected, message)
+		}
+	}
+	if n := atomic.LoadInt32(&counter.writes); n != 1 {
+		t.Fatalf("expected 1 socket write, got %d", n)
+	}
+}
+
+func TestWriteCoalescingFlushError(t *testing.T) {
+	clientTr := GetDefaultWebsocketTransport()
+	clientTr.WriteCoalescing = 20 * time.Millisecond
+	client, _ := websocketPair(t, GetDefaultWebsocketTransport(), clientTr)
+
+	//the last flush fails, its writers get the error
+	client.conn.Conn.Close()
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- client.WriteMessage("42[\"lost\"]")
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == nil {
+			t.Fatal("expected flush error")
+		}
+	}
+}
+
+func TestWriteCoalescingErrorBelongsToBatch(t *testing.T) {
+	clientTr := GetDefaultWebsocketTransport()
+	clientTr.WriteCoalescing = 20 * time.Millisecond
+	client, server := websocketPair(t, GetDefaultWebsocketTransport(), clientTr)
+
+	if err := client.WriteMessage("42[\"first\"]"); err != nil {
+		t.Fatal(err)
+	}
+	if message, err := server.GetMessage(); err != nil || message != "42[\"first\"]" {
+		t.Fatalf("unexpected message %q, %v", message, err)
+	}
+
+	client.conn.Conn.Close()
+	if err := client.WriteMessage("42[\"second\"]"); err == nil {
+		t.Fatal("expected error of the second write")
+	}
+}
+
+func TestWriteCoalescingDisable(t *testing.T) {
+	clientTr := GetDefaultWebsocketTransport()
+	clientTr.WriteCoalescing = time.Hour
+	client, server := websocketPair(t, GetDefaultWebsocketTransport(), clientTr)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- client.WriteMessage("42[\"pending\"]")
+	}()
+	//wait till the message is queued
+	for {
+		client.coalesceLock.Lock()
+		queued := client.coalesced != nil
+		client.coalesceLock.Unlock()
+		if queued {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	client.SetWriteCoalescing(0)
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued write is not flushed on disable")
+	}
+	if message, err := server.GetMessage(); err != nil || message != "42[\"pending\"]" {
+		t.Fatalf("unexpected message %q, %v", message, err)
+	}
+
+	//written at once now
+	if client.CoalescingInterval() != 0 {
+		t.Fatal("coalescing is not disabled")
+	}
+	if err := client.WriteMessage("42[\"direct\"]"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentWriteMessage(t *testing.T) {
+	const writers = 50
+	const messages = 20
+
+	client, server := websocketPair(t, GetDefaultWebsocketTransport(), GetDefaultWebsocketTransport())
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				message := fmt.Sprintf("42[\"message\",\"%d-%d-%s\"]", i, j, strings.Repeat("x", 512))
+				if err := client.WriteMessage(message); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+
+	received := receiveMessages(t, server, writers*messages)
+	wg.Wait()
+	for i := 0; i < writers; i++ {
+		for j := 0; j < messages; j++ {
+			message := fmt.Sprintf("42[\"message\",\"%d-%d-%s\"]", i, j, strings.Repeat("x", 512))
+			if !received[message] {
+				t.Fatalf("message %d-%d is not received intact", i, j)
+			}
+		}
+	}
+}
+
+func TestHandshakeChecksBeforeMethod(t *testing.T) {
+	tr := GetDefaultWebsocketTransport()
+	requests := map[string]error{
+		"/socket.io/?EIO=3&transport=polling":   ErrorTransportUnknown,
+		"/socket.io/?EIO=5&transport=websocket": ErrorUnsupportedProtocol,
+		"/socket.io/?EIO=3&transport=websocket": ErrorMethodNotAllowed,
+	}
+
+	for uri, expected := range requests {
+		w := httptest.NewRecorder()
+		_, err := tr.HandleConnection(w, httptest.NewRequest("POST", uri, nil))
+		if err != expected {
+			t.Fatalf("POST %s: expected %v, got %v", uri, expected, err)
+		}
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("POST %s: expected 400, got %d", uri, w.Code)
+		}
+	}
+}
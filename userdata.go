@@ -0,0 +1,49 @@
+package gosocketio
+
+/**
+Set value of given key on connection, like user of session set by
+middleware, values are shared by namespaces of the connection and
+dropped after it is closed and disconnection handlers are called
+*/
+func (c *Channel) Set(key string, val interface{}) {
+	if c.root != nil {
+		c.root.Set(key, val)
+		return
+	}
+
+	c.dataLock.Lock()
+	defer c.dataLock.Unlock()
+
+	if !c.IsAlive() {
+		return
+	}
+	if c.data == nil {
+		c.data = make(map[string]interface{})
+	}
+	c.data[key] = val
+}
+
+/**
+Get value of given key set on connection
+*/
+func (c *Channel) Get(key string) (interface{}, bool) {
+	if c.root != nil {
+		return c.root.Get(key)
+	}
+
+	c.dataLock.RLock()
+	defer c.dataLock.RUnlock()
+
+	val, ok := c.data[key]
+	return val, ok
+}
+
+/**
+Drop values of closed connection
+*/
+func (c *Channel) clearData() {
+	c.dataLock.Lock()
+	defer c.dataLock.Unlock()
+
+	c.data = nil
+}